@@ -1,12 +1,23 @@
 package client
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"example.com/agent_bridge/pkg/relay"
+	"example.com/agent_bridge/pkg/trickle"
 	"github.com/gorilla/websocket"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
@@ -20,7 +31,88 @@ type SignalMessage struct {
 	SDP       string `json:"sdp,omitempty"`
 	Candidate string `json:"candidate,omitempty"`
 	Data      string `json:"data,omitempty"`      // For screenshot base64 data
-	TargetID  string `json:"target_id,omitempty"` // Target peer for screenshot
+	TargetID  string `json:"target_id,omitempty"` // Target peer for screenshot and data; empty broadcasts data
+	Token     string `json:"token,omitempty"`     // Signed join token
+	Resume    bool   `json:"resume,omitempty"`    // In "join": take over this client's place after a lost connection
+	Code      string `json:"code,omitempty"`      // Machine-readable error code
+	Error     string `json:"error,omitempty"`     // Human-readable error message
+
+	// Remaining ICE candidate fields; the m-line index is a pointer since 0 is valid
+	SDPMid           *string `json:"sdp_mid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdp_mline_index,omitempty"`
+	UsernameFragment *string `json:"username_fragment,omitempty"`
+
+	RoomOptions *RoomOptions `json:"room_options,omitempty"` // Metadata for a room created by this join
+	RoomInfo    *RoomInfo    `json:"room_info,omitempty"`    // Sent in "room_info" after joining
+	Text        string       `json:"text,omitempty"`         // Operator message in "system"
+	Muted       bool         `json:"muted,omitempty"`        // In "force_mute": whether ClientID is muted
+
+	Recording *RecordingInfo `json:"recording,omitempty"` // Sent in "recording" when recording starts or stops
+
+	// Application data in "data"; the payload is either JSON or binary (base64 in JSON)
+	Topic   string          `json:"topic,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Binary  []byte          `json:"binary,omitempty"`
+}
+
+// ScreenshotTopic is the data topic screenshots are sent on, as JPEG bytes
+// Data on this topic is also passed to OnScreenshotReceived
+const ScreenshotTopic = "screenshot"
+
+// DataMessage is application data received from another peer
+type DataMessage struct {
+	From      string          // Sender's peer ID
+	Topic     string          // Chosen by the sender, e.g. ScreenshotTopic
+	Payload   json.RawMessage // Set if sent with SendData
+	Binary    []byte          // Set if sent with SendBinary
+	Broadcast bool            // Sent to the whole room rather than to this peer only
+}
+
+// RoomOptions is optional metadata for a room, applied if the join creates it
+// With join tokens enabled, the server only accepts it from agents and admins
+type RoomOptions struct {
+	Name               string `json:"name,omitempty"`
+	MaxPeers           int    `json:"max_peers,omitempty"`
+	MaxDurationSeconds int    `json:"max_duration_seconds,omitempty"`
+	DefaultPersona     string `json:"default_persona,omitempty"`
+	Mode               string `json:"mode,omitempty"` // RoomModeSFU (default) or RoomModeMCU
+}
+
+// Room modes for RoomOptions.Mode
+const (
+	RoomModeSFU = "sfu" // Each other peer's audio arrives as its own track
+	RoomModeMCU = "mcu" // The server mixes everyone else into one track from MixPeerID
+)
+
+// MixPeerID is the peer ID OnAudioReceived reports for the mixed track of an MCU room
+const MixPeerID = "mix"
+
+// Audio levels in -dBov, as the RFC 6464 audio level header extension carries them
+const (
+	AudioLevelSilence = 127
+	// AudioLevelSpeech is what WriteOpus reports for packets that are not silence
+	AudioLevelSpeech = 30
+)
+
+// audioLevelURI identifies the RFC 6464 audio level header extension
+const audioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+// RoomInfo describes the joined room
+type RoomInfo struct {
+	ID             string `json:"id"`
+	Name           string `json:"name,omitempty"`
+	MaxPeers       int    `json:"max_peers,omitempty"`
+	DefaultPersona string `json:"default_persona,omitempty"`
+	Mode           string `json:"mode"`                 // RoomModeSFU or RoomModeMCU
+	CreatedAt      int64  `json:"created_at"`           // Unix seconds
+	ExpiresAt      int64  `json:"expires_at,omitempty"` // Unix seconds; unset without a max duration
+}
+
+// RecordingInfo is the room's recording state
+type RecordingInfo struct {
+	Active    bool   `json:"active"`
+	StartedAt int64  `json:"started_at,omitempty"` // Unix seconds
+	StartedBy string `json:"started_by,omitempty"` // Peer ID, or "admin"
 }
 
 // AudioCallback is called when audio is received from another peer
@@ -32,6 +124,95 @@ type PeerEventCallback func(peerID string, joined bool)
 // ScreenshotCallback is called when a screenshot is received from another peer
 type ScreenshotCallback func(peerID string, imageData string)
 
+// RoomInfoCallback is called with the room's metadata after joining
+type RoomInfoCallback func(info RoomInfo)
+
+// SystemMessageCallback is called with messages broadcast by an operator
+type SystemMessageCallback func(text string)
+
+// ForceMuteCallback is called when an operator mutes or unmutes a peer's forwarded audio
+type ForceMuteCallback func(peerID string, muted bool)
+
+// RecordingCallback is called when recording of the room starts or stops, and after
+// joining a room that is being recorded
+type RecordingCallback func(info RecordingInfo)
+
+// ActiveSpeakerCallback is called when the room's dominant speaker changes
+// peerID is empty when nobody is speaking
+type ActiveSpeakerCallback func(peerID string)
+
+// DataCallback is called with application data from another peer
+type DataCallback func(msg DataMessage)
+
+// ErrorCallback is called when the server reports an error, e.g. a rejected join
+type ErrorCallback func(code, message string)
+
+// ConnectionState is the state of the client's connection to the server
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"   // Joining the room
+	StateConnected    ConnectionState = "connected"    // In the room
+	StateReconnecting ConnectionState = "reconnecting" // Lost the server; rejoining with backoff
+	StateDisconnected ConnectionState = "disconnected" // Disconnected, left for good or gave up reconnecting
+)
+
+// ConnectionStateCallback is called when the connection state changes
+// It must not call Connect or Disconnect.
+type ConnectionStateCallback func(state ConnectionState)
+
+// ReconnectPolicy controls rejoining after the connection to the server drops
+type ReconnectPolicy struct {
+	Disabled     bool          // Give up at once
+	InitialDelay time.Duration // Delay before the first attempt, doubled for each further one
+	MaxDelay     time.Duration // Upper bound of the delay
+	MaxAttempts  int           // Attempts before giving up; 0 for no limit
+}
+
+// DefaultReconnectPolicy is used unless SetReconnectPolicy is called; it also fills
+// in zero delays of other policies
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+}
+
+// delay returns how long to wait before a reconnect attempt (counting from 1)
+// Half the delay is random, so clients that dropped together don't retry together
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	d := cmp.Or(p.InitialDelay, DefaultReconnectPolicy.InitialDelay)
+	maxDelay := cmp.Or(p.MaxDelay, DefaultReconnectPolicy.MaxDelay)
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// sessionEndingErrors are the server errors after which rejoining is pointless
+var sessionEndingErrors = map[string]bool{
+	"unauthorized": true, "room_full": true, "replaced": true, "room_closed": true, "kicked": true,
+}
+
+// Reasons a connect fails or a session ends; see ConnectContext and Err
+var (
+	ErrDisconnected   = errors.New("disconnected")                  // Disconnect was called
+	ErrConnectionLost = errors.New("connection to the server lost") // And not regained
+	ErrICEFailed      = errors.New("ICE failed")
+	ErrTimeout        = errors.New("timed out connecting")
+
+	errAlreadyConnected = errors.New("already connected")
+)
+
+// ServerError is an error from the server that rejected the join or ended the session
+type ServerError struct {
+	Code    string // Machine-readable, e.g. "unauthorized", "room_full" or "kicked"
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error (%s): %s", e.Code, e.Message)
+}
+
 // Client represents an audio bridge client
 type Client struct {
 	ID             string
@@ -43,23 +224,66 @@ type Client struct {
 	onAudio        AudioCallback
 	onPeerEvent    PeerEventCallback
 	onScreenshot   ScreenshotCallback
+	onError        ErrorCallback
+	onRoomInfo     RoomInfoCallback
+	onSystem       SystemMessageCallback
+	onForceMute    ForceMuteCallback
+	onRecording    RecordingCallback
+	onSpeaker      ActiveSpeakerCallback
+	onData         DataCallback
+	token          string
+	roomOptions    *RoomOptions
 	mu             sync.Mutex
 	writeMu        sync.Mutex // separate mutex for WebSocket writes
 	rtpMu          sync.Mutex // mutex for RTP writing
-	connected      bool
+	connected      bool       // In a room, including while reconnecting
 	done           chan struct{}
+	err            error         // Why the session ended; set when done is closed
+	mediaReady     chan struct{} // Closed when the PeerConnection first connects
+	iceFailed      chan struct{} // Closed when ICE fails
+	// Set by options
+	iceServers    []webrtc.ICEServer
+	settingEngine *webrtc.SettingEngine
+	codecs        []webrtc.RTPCodecParameters
+	header        http.Header
+	tlsConfig     *tls.Config
+	dialer        *websocket.Dialer
+	// Reconnection after the connection to the server drops
+	reconnect ReconnectPolicy
+	attempts  int // Reconnect attempts since the last join succeeded
+	onState   ConnectionStateCallback
+	stateMu   sync.Mutex
+	state     ConnectionState
+	// The PeerConnection a resume replaces, closed once the server lets us back in
+	previousPC *webrtc.PeerConnection
+	// Remote candidates waiting for their description; used only by handleMessages
+	candidates trickle.Buffer
 	// RTP state for outgoing audio
 	rtpSeqNum    uint16
 	rtpTimestamp uint32
+	audioSender  *webrtc.RTPSender
+	audioLevelID uint8 // Negotiated audio level extension ID; 0 if not negotiated
+	// Data channel to the server, which relays application data between peers
+	relay   *webrtc.DataChannel
+	relayMu sync.Mutex // Serializes the frames of each message
+	relayID atomic.Uint32
 }
 
 // NewClient creates a new audio bridge client
-func NewClient(id, serverURL string) *Client {
-	return &Client{
-		ID:        id,
-		ServerURL: serverURL,
-		done:      make(chan struct{}),
+func NewClient(id, serverURL string, opts ...Option) *Client {
+	c := &Client{
+		ID:         id,
+		ServerURL:  serverURL,
+		done:       make(chan struct{}),
+		iceServers: defaultICEServers,
+		codecs:     []webrtc.RTPCodecParameters{defaultCodec},
+		reconnect:  DefaultReconnectPolicy,
+		state:      StateDisconnected,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // OnAudioReceived sets the callback for received audio tracks
@@ -77,23 +301,159 @@ func (c *Client) OnScreenshotReceived(callback ScreenshotCallback) {
 	c.onScreenshot = callback
 }
 
+// OnError sets the callback for errors reported by the server
+func (c *Client) OnError(callback ErrorCallback) {
+	c.onError = callback
+}
+
+// OnRoomInfo sets the callback for the room metadata sent after joining
+func (c *Client) OnRoomInfo(callback RoomInfoCallback) {
+	c.onRoomInfo = callback
+}
+
+// OnSystemMessage sets the callback for operator broadcasts
+func (c *Client) OnSystemMessage(callback SystemMessageCallback) {
+	c.onSystem = callback
+}
+
+// OnForceMute sets the callback for operator mutes; peerID may be this client's ID
+func (c *Client) OnForceMute(callback ForceMuteCallback) {
+	c.onForceMute = callback
+}
+
+// OnRecording sets the callback for changes to the room's recording state
+func (c *Client) OnRecording(callback RecordingCallback) {
+	c.onRecording = callback
+}
+
+// OnActiveSpeaker sets the callback for changes of the room's dominant speaker
+func (c *Client) OnActiveSpeaker(callback ActiveSpeakerCallback) {
+	c.onSpeaker = callback
+}
+
+// OnData sets the callback for application data from other peers
+func (c *Client) OnData(callback DataCallback) {
+	c.onData = callback
+}
+
+// OnConnectionStateChange sets the callback for changes of the connection to the server
+func (c *Client) OnConnectionStateChange(callback ConnectionStateCallback) {
+	c.onState = callback
+}
+
+// SetReconnectPolicy sets how the client rejoins after the connection to the server drops
+func (c *Client) SetReconnectPolicy(policy ReconnectPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnect = policy
+}
+
+// SetRoomOptions sets metadata for the room, used if this client's join creates it
+func (c *Client) SetRoomOptions(opts RoomOptions) {
+	c.roomOptions = &opts
+}
+
+// SetToken sets the signed join token sent when joining a room
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
 // Connect establishes connection to the server and joins a room
+// It returns once the join is sent; use ConnectContext to wait for media.
+// If the connection drops later, the client rejoins the room with the same ID
+// according to its ReconnectPolicy.
 func (c *Client) Connect(room string) error {
+	return c.start(context.Background(), room)
+}
+
+// ConnectContext joins a room like Connect, then waits until media flows, i.e. the
+// PeerConnection is connected
+// If that fails, the client disconnects and returns why: ErrTimeout (also matching
+// context.DeadlineExceeded) when ctx expires, a *ServerError when the join is
+// rejected, or ErrICEFailed.
+func (c *Client) ConnectContext(ctx context.Context, room string) error {
+	if err := c.start(ctx, room); err != nil {
+		if ctxErr := contextError(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	done, ready, failed := c.done, c.mediaReady, c.iceFailed
+	c.mu.Unlock()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-done:
+		return c.Err()
+	case <-failed:
+		err = ErrICEFailed
+	case <-ctx.Done():
+		err = contextError(ctx)
+	}
+	c.leave(err)
+	return err
+}
+
+// contextError returns ctx's error, as ErrTimeout if its deadline passed
+func contextError(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+// start dials the server and sends the join
+func (c *Client) start(ctx context.Context, room string) error {
+	if c.IsConnected() {
+		return errAlreadyConnected
+	}
+	c.setState(StateConnecting)
+	if err := c.connect(ctx, room); err != nil {
+		if err != errAlreadyConnected {
+			c.setState(StateDisconnected)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Client) connect(ctx context.Context, room string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.connected {
-		return fmt.Errorf("already connected")
+		return errAlreadyConnected
 	}
 
 	c.Room = room
+	c.done = make(chan struct{})
+	c.err = nil
+	c.mediaReady = make(chan struct{})
+	c.iceFailed = make(chan struct{})
+	c.attempts = 0
+	if err := c.dial(ctx, false); err != nil {
+		return err
+	}
 
+	c.connected = true
+	log.Printf("[%s] Connected to room %s", c.ID, room)
+
+	return nil
+}
+
+// dial opens the WebSocket and a new PeerConnection, and joins c.Room; c.mu must be held
+// A resuming join takes over this client's place in the room after a dropped connection.
+func (c *Client) dial(ctx context.Context, resume bool) error {
 	// Connect to WebSocket
-	conn, _, err := websocket.DefaultDialer.Dial(c.ServerURL, nil)
+	conn, _, err := c.wsDialer().DialContext(ctx, c.ServerURL, c.header)
 	if err != nil {
 		return fmt.Errorf("websocket dial failed: %w", err)
 	}
-	c.conn = conn
 
 	// Create PeerConnection
 	pc, err := c.createPeerConnection()
@@ -101,33 +461,33 @@ func (c *Client) Connect(room string) error {
 		conn.Close()
 		return fmt.Errorf("failed to create peer connection: %w", err)
 	}
-	c.peerConnection = pc
 
-	// Create audio track for sending
-	audioTrack, err := webrtc.NewTrackLocalStaticRTP(
-		webrtc.RTPCodecCapability{
-			MimeType:    webrtc.MimeTypeOpus,
-			ClockRate:   48000,
-			Channels:    2,
-			SDPFmtpLine: "minptime=10;useinbandfec=1",
-		},
-		fmt.Sprintf("audio-%s", c.ID),
-		fmt.Sprintf("stream-%s", c.ID),
-	)
-	if err != nil {
-		pc.Close()
-		conn.Close()
-		return fmt.Errorf("failed to create audio track: %w", err)
+	// Create audio track for sending; it outlives reconnects, so writers keep using it
+	if c.audioTrack == nil {
+		audioTrack, err := webrtc.NewTrackLocalStaticRTP(
+			c.codecs[0].RTPCodecCapability,
+			fmt.Sprintf("audio-%s", c.ID),
+			fmt.Sprintf("stream-%s", c.ID),
+		)
+		if err != nil {
+			pc.Close()
+			conn.Close()
+			return fmt.Errorf("failed to create audio track: %w", err)
+		}
+		c.audioTrack = audioTrack
 	}
-	c.audioTrack = audioTrack
 
 	// Add the track to the peer connection
-	sender, err := pc.AddTrack(audioTrack)
+	sender, err := pc.AddTrack(c.audioTrack)
 	if err != nil {
 		pc.Close()
 		conn.Close()
 		return fmt.Errorf("failed to add track: %w", err)
 	}
+	c.rtpMu.Lock()
+	c.audioSender = sender
+	c.audioLevelID = 0
+	c.rtpMu.Unlock()
 
 	// Read and discard RTCP packets
 	go func() {
@@ -139,14 +499,26 @@ func (c *Client) Connect(room string) error {
 		}
 	}()
 
+	// The server creates its end of the data relay with the same ID
+	if err := c.openRelay(pc); err != nil {
+		pc.Close()
+		conn.Close()
+		return fmt.Errorf("failed to create data channel: %w", err)
+	}
+
 	// Set up ICE candidate handling
 	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		if candidate == nil {
+			c.sendMessage(SignalMessage{Type: "end_of_candidates"})
 			return
 		}
+		init := trickle.CandidateInit(pc, candidate)
 		c.sendMessage(SignalMessage{
-			Type:      "candidate",
-			Candidate: candidate.ToJSON().Candidate,
+			Type:             "candidate",
+			Candidate:        init.Candidate,
+			SDPMid:           init.SDPMid,
+			SDPMLineIndex:    init.SDPMLineIndex,
+			UsernameFragment: init.UsernameFragment,
 		})
 	})
 
@@ -164,61 +536,171 @@ func (c *Client) Connect(room string) error {
 	})
 
 	// Handle connection state
+	ready, failed := c.mediaReady, c.iceFailed
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		log.Printf("[%s] Connection state: %s", c.ID, state.String())
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			c.notify(ready)
+		case webrtc.PeerConnectionStateFailed:
+			c.notify(failed)
+			// Ask the server for an offer with fresh ICE credentials
+			go c.RestartICE()
+		}
 	})
 
+	// Renegotiating needs signaling, so a rejoin brings a new PeerConnection; media
+	// keeps flowing on the previous one until the server has taken the resume
+	if c.previousPC == nil {
+		c.previousPC = c.peerConnection
+	} else if c.peerConnection != nil {
+		c.peerConnection.Close() // From an attempt that never got in
+	}
+	c.writeMu.Lock()
+	c.conn = conn
+	c.writeMu.Unlock()
+	c.peerConnection = pc
+	c.candidates = trickle.Buffer{}
+
 	// Start message handler
-	go c.handleMessages()
+	go c.handleMessages(conn, c.done)
 
 	// Join the room - server will send offer after we join
 	c.sendMessage(SignalMessage{
-		Type:     "join",
-		Room:     room,
-		ClientID: c.ID,
+		Type:        "join",
+		Room:        c.Room,
+		ClientID:    c.ID,
+		Token:       c.token,
+		RoomOptions: c.roomOptions,
+		Resume:      resume,
 	})
 
-	c.connected = true
-	log.Printf("[%s] Connected to room %s", c.ID, room)
-
 	return nil
 }
 
+// notify closes a channel that signals an event of the session, unless it is closed
+func (c *Client) notify(ch chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// connectionLost handles the end of the WebSocket because of cause: the client
+// rejoins with backoff, unless the server ended the session (ended is set) or the
+// policy says not to
+func (c *Client) connectionLost(ended *ServerError, cause error) {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return
+	}
+	policy := c.reconnect
+	if ended != nil || policy.Disabled {
+		err := fmt.Errorf("%w: %w", ErrConnectionLost, cause)
+		if ended != nil {
+			err = ended
+		}
+		c.close(err)
+		c.mu.Unlock()
+		log.Printf("[%s] Disconnected: %v", c.ID, err)
+		c.setState(StateDisconnected)
+		return
+	}
+	done := c.done
+	c.mu.Unlock()
+
+	c.setState(StateReconnecting)
+	for {
+		c.mu.Lock()
+		c.attempts++
+		attempt := c.attempts
+		c.mu.Unlock()
+
+		if policy.MaxAttempts > 0 && attempt > policy.MaxAttempts {
+			log.Printf("[%s] Giving up reconnecting after %d attempts", c.ID, policy.MaxAttempts)
+			c.mu.Lock()
+			if c.connected {
+				c.close(fmt.Errorf("%w after %d attempts: %w", ErrConnectionLost, policy.MaxAttempts, cause))
+			}
+			c.mu.Unlock()
+			c.setState(StateDisconnected)
+			return
+		}
+
+		wait := policy.delay(attempt)
+		log.Printf("[%s] Reconnecting in %s (attempt %d)", c.ID, wait.Round(time.Millisecond), attempt)
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+
+		c.mu.Lock()
+		if !c.connected {
+			c.mu.Unlock()
+			return
+		}
+		err := c.dial(context.Background(), true)
+		c.mu.Unlock()
+		if err == nil {
+			return
+		}
+		log.Printf("[%s] Reconnect failed: %v", c.ID, err)
+		cause = err
+	}
+}
+
 func (c *Client) createPeerConnection() (*webrtc.PeerConnection, error) {
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
-		},
+		ICEServers: c.iceServers,
 	}
 
 	mediaEngine := &webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:    webrtc.MimeTypeOpus,
-			ClockRate:   48000,
-			Channels:    2,
-			SDPFmtpLine: "minptime=10;useinbandfec=1",
-		},
-		PayloadType: 111,
-	}, webrtc.RTPCodecTypeAudio); err != nil {
+	for _, codec := range c.codecs {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+	// The server detects the active speaker from audio levels
+	if err := mediaEngine.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: audioLevelURI}, webrtc.RTPCodecTypeAudio,
+	); err != nil {
 		return nil, err
 	}
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	options := []func(*webrtc.API){webrtc.WithMediaEngine(mediaEngine)}
+	if c.settingEngine != nil {
+		options = append(options, webrtc.WithSettingEngine(*c.settingEngine))
+	}
+	api := webrtc.NewAPI(options...)
 	return api.NewPeerConnection(config)
 }
 
-func (c *Client) handleMessages() {
+// handleMessages reads one WebSocket connection until it ends, then hands over to
+// connectionLost unless the client was disconnected
+func (c *Client) handleMessages(conn *websocket.Conn, done chan struct{}) {
+	joined := false        // The server sent room_info on this connection
+	var ended *ServerError // The server ended the session
 	for {
 		select {
-		case <-c.done:
+		case <-done:
 			return
 		default:
 		}
 
 		var msg SignalMessage
-		if err := c.conn.ReadJSON(&msg); err != nil {
+		if err := conn.ReadJSON(&msg); err != nil {
+			select {
+			case <-done:
+				return
+			default:
+			}
 			log.Printf("[%s] Read error: %v", c.ID, err)
+			c.connectionLost(ended, err)
 			return
 		}
 
@@ -229,6 +711,8 @@ func (c *Client) handleMessages() {
 			c.handleAnswer(msg)
 		case "candidate":
 			c.handleCandidate(msg)
+		case "end_of_candidates":
+			c.handleCandidate(SignalMessage{})
 		case "peer_joined":
 			log.Printf("[%s] Peer joined: %s", c.ID, msg.ClientID)
 			if c.onPeerEvent != nil {
@@ -239,11 +723,59 @@ func (c *Client) handleMessages() {
 			if c.onPeerEvent != nil {
 				c.onPeerEvent(msg.ClientID, false)
 			}
+		case "room_info":
+			joined = true
+			c.mu.Lock()
+			c.attempts = 0
+			if c.previousPC != nil {
+				c.previousPC.Close()
+				c.previousPC = nil
+			}
+			c.mu.Unlock()
+			c.setState(StateConnected)
+			if msg.RoomInfo != nil {
+				log.Printf("[%s] Joined %s room %s (%s)", c.ID, msg.RoomInfo.Mode, msg.RoomInfo.ID, msg.RoomInfo.Name)
+				if c.onRoomInfo != nil {
+					c.onRoomInfo(*msg.RoomInfo)
+				}
+			}
+		case "system":
+			log.Printf("[%s] System message: %s", c.ID, msg.Text)
+			if c.onSystem != nil {
+				c.onSystem(msg.Text)
+			}
+		case "force_mute":
+			log.Printf("[%s] Peer %s force muted: %v", c.ID, msg.ClientID, msg.Muted)
+			if c.onForceMute != nil {
+				c.onForceMute(msg.ClientID, msg.Muted)
+			}
+		case "recording":
+			if msg.Recording != nil {
+				log.Printf("[%s] Room recording active: %v", c.ID, msg.Recording.Active)
+				if c.onRecording != nil {
+					c.onRecording(*msg.Recording)
+				}
+			}
+		case "active_speaker":
+			if c.onSpeaker != nil {
+				c.onSpeaker(msg.ClientID)
+			}
+		case "error":
+			log.Printf("[%s] Server error (%s): %s", c.ID, msg.Code, msg.Error)
+			// Errors before room_info reject the join
+			if !joined || sessionEndingErrors[msg.Code] {
+				ended = &ServerError{Code: msg.Code, Message: msg.Error}
+			}
+			if c.onError != nil {
+				c.onError(msg.Code, msg.Error)
+			}
 		case "screenshot":
 			log.Printf("[%s] Screenshot received from: %s (%d bytes)", c.ID, msg.ClientID, len(msg.Data))
 			if c.onScreenshot != nil {
 				c.onScreenshot(msg.ClientID, msg.Data)
 			}
+		case "data":
+			c.handleData(msg)
 		}
 	}
 }
@@ -258,6 +790,7 @@ func (c *Client) handleOffer(msg SignalMessage) {
 		log.Printf("[%s] Failed to set remote description: %v", c.ID, err)
 		return
 	}
+	c.candidates.Flush(c.peerConnection)
 
 	answer, err := c.peerConnection.CreateAnswer(nil)
 	if err != nil {
@@ -269,6 +802,7 @@ func (c *Client) handleOffer(msg SignalMessage) {
 		log.Printf("[%s] Failed to set local description: %v", c.ID, err)
 		return
 	}
+	c.updateAudioLevelID()
 
 	c.sendMessage(SignalMessage{
 		Type: "answer",
@@ -284,25 +818,178 @@ func (c *Client) handleAnswer(msg SignalMessage) {
 
 	if err := c.peerConnection.SetRemoteDescription(answer); err != nil {
 		log.Printf("[%s] Failed to set remote description: %v", c.ID, err)
+		return
 	}
+	c.candidates.Flush(c.peerConnection)
 }
 
+// handleCandidate adds a remote candidate, buffering it until its description is applied
+// An empty candidate marks the end of the server's candidates
 func (c *Client) handleCandidate(msg SignalMessage) {
 	candidate := webrtc.ICECandidateInit{
-		Candidate: msg.Candidate,
+		Candidate:        msg.Candidate,
+		SDPMid:           msg.SDPMid,
+		SDPMLineIndex:    msg.SDPMLineIndex,
+		UsernameFragment: msg.UsernameFragment,
 	}
 
-	if err := c.peerConnection.AddICECandidate(candidate); err != nil {
+	if err := c.candidates.Add(c.peerConnection, candidate); err != nil {
 		log.Printf("[%s] Failed to add ICE candidate: %v", c.ID, err)
 	}
 }
 
+// RestartICE asks the server to renegotiate with fresh ICE credentials
+// Use it after a network change; the server also restarts ICE when its side fails
+func (c *Client) RestartICE() error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	return c.sendMessage(SignalMessage{Type: "ice_restart"})
+}
+
+// StartRecording asks the server to record the room
+// With join tokens enabled, only agents and admins may; refusals arrive through OnError
+func (c *Client) StartRecording() error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	return c.sendMessage(SignalMessage{Type: "start_recording"})
+}
+
+// StopRecording asks the server to stop recording the room
+func (c *Client) StopRecording() error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	return c.sendMessage(SignalMessage{Type: "stop_recording"})
+}
+
 func (c *Client) sendMessage(msg SignalMessage) error {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 	return c.conn.WriteJSON(msg)
 }
 
+// SendData sends payload, encoded as JSON, to another peer on a topic
+// An empty targetID sends it to every other peer in the room. Data goes over the
+// data channel once it is open, and over signaling until then.
+func (c *Client) SendData(targetID, topic string, payload any) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	if c.DataChannelOpen() {
+		return c.sendRelay(relay.Header{TargetID: targetID, Topic: topic, JSON: true}, data)
+	}
+	return c.sendMessage(SignalMessage{Type: "data", TargetID: targetID, Topic: topic, Payload: data})
+}
+
+// SendBinary sends binary data to another peer on a topic
+// An empty targetID sends it to every other peer in the room. Data goes over the
+// data channel once it is open, without the base64 overhead and without holding up
+// signaling; until then it goes over signaling, where the server's limit is lower.
+func (c *Client) SendBinary(targetID, topic string, data []byte) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	if c.DataChannelOpen() {
+		return c.sendRelay(relay.Header{TargetID: targetID, Topic: topic}, data)
+	}
+	return c.sendMessage(SignalMessage{Type: "data", TargetID: targetID, Topic: topic, Binary: data})
+}
+
+// DataChannelOpen reports whether data is sent over the data channel
+func (c *Client) DataChannelOpen() bool {
+	c.relayMu.Lock()
+	defer c.relayMu.Unlock()
+	return c.relay != nil && c.relay.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+// openRelay creates the client's end of the data channel
+func (c *Client) openRelay(pc *webrtc.PeerConnection) error {
+	negotiated := true
+	id := uint16(relay.ChannelID)
+	dc, err := pc.CreateDataChannel(relay.Label, &webrtc.DataChannelInit{
+		Negotiated: &negotiated,
+		ID:         &id,
+	})
+	if err != nil {
+		return err
+	}
+	c.relayMu.Lock()
+	c.relay = dc
+	c.relayMu.Unlock()
+	// The server enforces the size limit
+	recv := relay.NewAssembler(0)
+
+	dc.OnOpen(func() {
+		log.Printf("[%s] Data channel open", c.ID)
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		m, err := recv.Add(msg.Data)
+		if err != nil {
+			log.Printf("[%s] Dropped data frame: %v", c.ID, err)
+			return
+		}
+		if m != nil {
+			c.deliverData(DataMessage{
+				From:      m.From,
+				Topic:     m.Topic,
+				Payload:   m.Payload,
+				Binary:    m.Payload,
+				Broadcast: m.TargetID == "",
+			}, m.JSON)
+		}
+	})
+	return nil
+}
+
+// sendRelay sends a message over the data channel, split into frames
+func (c *Client) sendRelay(h relay.Header, payload []byte) error {
+	h.ID = c.relayID.Add(1)
+	frames, err := relay.Frames(h, payload)
+	if err != nil {
+		return err
+	}
+	c.relayMu.Lock()
+	defer c.relayMu.Unlock()
+	for _, frame := range frames {
+		if err := c.relay.Send(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) handleData(msg SignalMessage) {
+	c.deliverData(DataMessage{
+		From:      msg.ClientID,
+		Topic:     msg.Topic,
+		Payload:   msg.Payload,
+		Binary:    msg.Binary,
+		Broadcast: msg.TargetID == "",
+	}, len(msg.Payload) > 0)
+}
+
+// deliverData passes received data to the callbacks; isJSON tells which payload is set
+func (c *Client) deliverData(data DataMessage, isJSON bool) {
+	if isJSON {
+		data.Binary = nil
+	} else {
+		data.Payload = nil
+	}
+	if data.Topic == ScreenshotTopic && c.onScreenshot != nil {
+		log.Printf("[%s] Screenshot received from: %s (%d bytes)", c.ID, data.From, len(data.Binary))
+		c.onScreenshot(data.From, base64.StdEncoding.EncodeToString(data.Binary))
+	}
+	if c.onData != nil {
+		c.onData(data)
+	}
+}
+
 // WriteRTP writes a raw RTP packet to the audio track
 func (c *Client) WriteRTP(data []byte) error {
 	if c.audioTrack == nil {
@@ -313,7 +1000,20 @@ func (c *Client) WriteRTP(data []byte) error {
 }
 
 // WriteOpus writes an Opus payload with proper RTP headers
+// Without a measured level, packets of up to 3 bytes (what Opus sends for silence)
+// are marked silent and others AudioLevelSpeech; use WriteOpusWithLevel when the
+// PCM is at hand
 func (c *Client) WriteOpus(opusData []byte) error {
+	level := uint8(AudioLevelSpeech)
+	if len(opusData) <= 3 {
+		level = AudioLevelSilence
+	}
+	return c.WriteOpusWithLevel(opusData, level)
+}
+
+// WriteOpusWithLevel writes an Opus payload with its audio level in -dBov (0-127),
+// as computed by AudioLevel
+func (c *Client) WriteOpusWithLevel(opusData []byte, level uint8) error {
 	if c.audioTrack == nil {
 		return fmt.Errorf("audio track not initialized")
 	}
@@ -321,6 +1021,7 @@ func (c *Client) WriteOpus(opusData []byte) error {
 	c.rtpMu.Lock()
 	seqNum := c.rtpSeqNum
 	timestamp := c.rtpTimestamp
+	levelID := c.audioLevelID
 	c.rtpSeqNum++
 	c.rtpTimestamp += 960 // 20ms at 48kHz
 	c.rtpMu.Unlock()
@@ -338,46 +1039,149 @@ func (c *Client) WriteOpus(opusData []byte) error {
 		},
 		Payload: opusData,
 	}
+	if levelID != 0 {
+		ext, err := rtp.AudioLevelExtension{Level: min(level, AudioLevelSilence), Voice: level < AudioLevelSilence}.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := packet.Header.SetExtension(levelID, ext); err != nil {
+			return err
+		}
+	}
 
 	return c.audioTrack.WriteRTP(packet)
 }
 
+// updateAudioLevelID looks up the audio level extension ID after a negotiation
+func (c *Client) updateAudioLevelID() {
+	c.rtpMu.Lock()
+	defer c.rtpMu.Unlock()
+	if c.audioSender == nil {
+		return
+	}
+	c.audioLevelID = 0
+	for _, ext := range c.audioSender.GetParameters().HeaderExtensions {
+		if ext.URI == audioLevelURI {
+			c.audioLevelID = uint8(ext.ID)
+		}
+	}
+}
+
+// AudioLevel returns the level of 16-bit PCM in -dBov (0 is full scale, 127 silence),
+// for WriteOpusWithLevel
+func AudioLevel(pcm []int16) uint8 {
+	if len(pcm) == 0 {
+		return AudioLevelSilence
+	}
+	var sum float64
+	for _, s := range pcm {
+		v := float64(s) / 32768
+		sum += v * v
+	}
+	rms := math.Sqrt(sum / float64(len(pcm)))
+	if rms == 0 {
+		return AudioLevelSilence
+	}
+	dbov := -20 * math.Log10(rms)
+	return uint8(math.Round(max(0, min(dbov, AudioLevelSilence))))
+}
+
 // GetAudioTrack returns the local audio track for direct RTP writing
 func (c *Client) GetAudioTrack() *webrtc.TrackLocalStaticRTP {
 	return c.audioTrack
 }
 
-// Disconnect closes the connection
+// Disconnect leaves the room and closes the connection
 func (c *Client) Disconnect() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.leave(ErrDisconnected)
+	return nil
+}
 
+// leave ends the session with err, telling the server not to hold our place for a resume
+func (c *Client) leave(err error) {
+	c.mu.Lock()
 	if !c.connected {
-		return nil
+		c.mu.Unlock()
+		return
 	}
+	c.sendMessage(SignalMessage{Type: "leave"})
+	c.close(err)
+	c.mu.Unlock()
+
+	log.Printf("[%s] Disconnected: %v", c.ID, err)
+	c.setState(StateDisconnected)
+}
 
+// close ends the session with err; c.mu must be held
+func (c *Client) close(err error) {
+	c.err = err
 	close(c.done)
 
 	if c.peerConnection != nil {
 		c.peerConnection.Close()
 	}
+	if c.previousPC != nil {
+		c.previousPC.Close()
+		c.previousPC = nil
+	}
 
 	if c.conn != nil {
 		c.conn.Close()
 	}
 
 	c.connected = false
-	log.Printf("[%s] Disconnected", c.ID)
-	return nil
 }
 
-// IsConnected returns whether the client is connected
+// IsConnected returns whether the client is in a room, including while it reconnects
 func (c *Client) IsConnected() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.connected
 }
 
+// Done returns a channel that is closed when the current session ends: on
+// Disconnect, on an error from the server that ends it, or on giving up reconnecting
+func (c *Client) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Err returns nil until Done is closed, then why the session ended: ErrDisconnected
+// after Disconnect, a *ServerError when the server rejected the join or ended the
+// session (e.g. "kicked"), ErrConnectionLost wrapping the last failure when
+// reconnecting gave up, or what ConnectContext returned
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// State returns the state of the connection to the server
+func (c *Client) State() ConnectionState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// setState records a connection state change and reports it
+// Once disconnected, only Connect moves the state on, so late events of the old
+// connection are ignored.
+func (c *Client) setState(state ConnectionState) {
+	c.stateMu.Lock()
+	if state == c.state || (c.state == StateDisconnected && state != StateConnecting) {
+		c.stateMu.Unlock()
+		return
+	}
+	c.state = state
+	c.stateMu.Unlock()
+
+	log.Printf("[%s] Server connection: %s", c.ID, state)
+	if c.onState != nil {
+		c.onState(state)
+	}
+}
+
 // AudioReader is a helper to read audio from a track
 type AudioReader struct {
 	Track  *webrtc.TrackRemote
@@ -419,21 +1223,21 @@ func (g *SimpleAudioGenerator) GenerateFrame() []byte {
 	packet := make([]byte, 12+3) // RTP header (12) + minimal Opus frame
 
 	// RTP header
-	packet[0] = 0x80                           // Version 2
-	packet[1] = 111                            // Payload type (Opus)
-	packet[2] = byte(g.seqNum >> 8)            // Sequence number
-	packet[3] = byte(g.seqNum)                 // Sequence number
-	packet[4] = byte(g.timestamp >> 24)        // Timestamp
-	packet[5] = byte(g.timestamp >> 16)        // Timestamp
-	packet[6] = byte(g.timestamp >> 8)         // Timestamp
-	packet[7] = byte(g.timestamp)              // Timestamp
-	packet[8] = 0                              // SSRC (would be set by WebRTC)
-	packet[9] = 0                              // SSRC
-	packet[10] = 0                             // SSRC
-	packet[11] = 1                             // SSRC
-	packet[12] = 0xFC                          // Opus TOC byte (silence frame)
-	packet[13] = 0xFF                          // Opus frame
-	packet[14] = 0xFE                          // Opus frame
+	packet[0] = 0x80                    // Version 2
+	packet[1] = 111                     // Payload type (Opus)
+	packet[2] = byte(g.seqNum >> 8)     // Sequence number
+	packet[3] = byte(g.seqNum)          // Sequence number
+	packet[4] = byte(g.timestamp >> 24) // Timestamp
+	packet[5] = byte(g.timestamp >> 16) // Timestamp
+	packet[6] = byte(g.timestamp >> 8)  // Timestamp
+	packet[7] = byte(g.timestamp)       // Timestamp
+	packet[8] = 0                       // SSRC (would be set by WebRTC)
+	packet[9] = 0                       // SSRC
+	packet[10] = 0                      // SSRC
+	packet[11] = 1                      // SSRC
+	packet[12] = 0xFC                   // Opus TOC byte (silence frame)
+	packet[13] = 0xFF                   // Opus frame
+	packet[14] = 0xFE                   // Opus frame
 
 	g.seqNum++
 	g.timestamp += uint32(g.frameSize)
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAudioLevel(t *testing.T) {
+	square := make([]int16, 960)
+	sine := make([]int16, 960)
+	for i := range square {
+		square[i] = math.MaxInt16
+		if i%2 == 1 {
+			square[i] = -math.MaxInt16
+		}
+		// -20 dBFS RMS: amplitude 0.1 * sqrt(2) of full scale
+		sine[i] = int16(0.1 * math.Sqrt2 * 32767 * math.Sin(2*math.Pi*440*float64(i)/48000))
+	}
+
+	for _, tc := range []struct {
+		name string
+		pcm  []int16
+		want uint8
+	}{
+		{"full scale", square, 0},
+		{"-20 dBFS sine", sine, 20},
+		{"silence", make([]int16, 960), AudioLevelSilence},
+		{"empty", nil, AudioLevelSilence},
+	} {
+		if got := AudioLevel(tc.pcm); got != tc.want {
+			t.Errorf("%s: level %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// Option configures a Client; pass options to NewClient
+type Option func(*Client)
+
+// defaultICEServers are used unless WithICEServers is given
+var defaultICEServers = []webrtc.ICEServer{
+	{URLs: []string{"stun:stun.l.google.com:19302"}},
+}
+
+// defaultCodec is the audio codec used unless WithCodecs is given
+var defaultCodec = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeOpus,
+		ClockRate:   48000,
+		Channels:    2,
+		SDPFmtpLine: "minptime=10;useinbandfec=1",
+	},
+	PayloadType: 111,
+}
+
+// WithICEServers replaces the default public STUN server with the given STUN and
+// TURN servers
+// With none, only host candidates are gathered, e.g. for loopback tests.
+func WithICEServers(servers ...webrtc.ICEServer) Option {
+	return func(c *Client) {
+		c.iceServers = servers
+	}
+}
+
+// WithSettingEngine sets the SettingEngine of the client's PeerConnections, e.g. to
+// restrict interfaces, ports or network types
+func WithSettingEngine(se webrtc.SettingEngine) Option {
+	return func(c *Client) {
+		c.settingEngine = &se
+	}
+}
+
+// WithCodecs registers the given audio codecs instead of the default Opus
+// (48 kHz stereo, in-band FEC); the first is the codec of the outgoing track
+// WriteOpus assumes Opus at 48 kHz; use WriteRTP for other codecs.
+func WithCodecs(codecs ...webrtc.RTPCodecParameters) Option {
+	return func(c *Client) {
+		if len(codecs) > 0 {
+			c.codecs = codecs
+		}
+	}
+}
+
+// WithHeader adds a header to the WebSocket handshake, e.g. for an authenticating proxy
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		if c.header == nil {
+			c.header = make(http.Header)
+		}
+		c.header.Add(key, value)
+	}
+}
+
+// WithToken sets the signed join token sent when joining a room
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithTLSConfig sets the TLS configuration for wss:// server URLs, e.g. a private CA
+// or a client certificate
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDialer sets the WebSocket dialer instead of websocket.DefaultDialer, e.g. for
+// a proxy or handshake timeout; a WithTLSConfig config takes precedence over its own
+func WithDialer(dialer *websocket.Dialer) Option {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// WithReconnectPolicy sets how the client rejoins after the connection to the server
+// drops, as SetReconnectPolicy does
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(c *Client) {
+		c.reconnect = policy
+	}
+}
+
+// wsDialer returns the dialer for the signaling connection
+func (c *Client) wsDialer() *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if c.dialer != nil {
+		dialer = *c.dialer
+	}
+	if c.tlsConfig != nil {
+		dialer.TLSClientConfig = c.tlsConfig
+	}
+	return &dialer
+}
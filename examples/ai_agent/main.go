@@ -19,8 +19,12 @@ import (
 	"example.com/agent_bridge/pkg/audio"
 	"example.com/agent_bridge/pkg/deepgram"
 	"example.com/agent_bridge/pkg/elevenlabs"
+	"example.com/agent_bridge/pkg/llm"
+	"example.com/agent_bridge/pkg/localtts"
 	"example.com/agent_bridge/pkg/openai"
 	"example.com/agent_bridge/pkg/stt"
+	"example.com/agent_bridge/pkg/tts"
+	"example.com/agent_bridge/pkg/turn"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
@@ -28,17 +32,20 @@ import (
 
 // Persona represents an AI agent persona configuration
 type Persona struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	VoiceID     string `json:"voice_id"`
-	VoiceName   string `json:"voice_name"`
-	Prompt      string `json:"prompt"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	VoiceID     string   `json:"voice_id"`
+	VoiceName   string   `json:"voice_name"`
+	Prompt      string   `json:"prompt"`
+	Tools       []string `json:"tools,omitempty"` // Names of registered tools this persona may call
 }
 
 // PromptsConfig holds all available personas
 type PromptsConfig struct {
 	Personas map[string]Persona `json:"personas"`
 	Default  string             `json:"default"`
+	LLM      llm.Config         `json:"llm"`
+	VAD      VADSettings        `json:"vad"`
 	Settings struct {
 		MaxResponseSentences int  `json:"max_response_sentences"`
 		AllowScreenContext   bool `json:"allow_screen_context"`
@@ -87,6 +94,22 @@ func getDefaultConfigPath() string {
 	return "config/prompts.json"
 }
 
+// VADSettings configures local voice activity detection on incoming audio
+type VADSettings struct {
+	Enabled        bool `json:"enabled"`
+	Aggressiveness int  `json:"aggressiveness"` // 0-3, higher filters more noise
+	MinSpeechMs    int  `json:"min_speech_ms"`  // Voiced audio needed before speech starts
+	HangoverMs     int  `json:"hangover_ms"`    // Silence needed before speech ends
+	GateSTT        bool `json:"gate_stt"`       // Only send audio around detected speech to STT
+}
+
+// sttTail is how long audio keeps flowing to STT after local speech end, so the
+// provider's own endpointing (utterance end after 1000 ms of silence) still fires
+const sttTail = 1500 * time.Millisecond
+
+// sttKeepAliveInterval is how often a gated STT session is kept alive
+const sttKeepAliveInterval = 5 * time.Second
+
 // STTProvider indicates which speech-to-text provider to use
 type STTProvider string
 
@@ -95,17 +118,149 @@ const (
 	STTProviderAssemblyAI STTProvider = "assemblyai"
 )
 
+// LLM providers; all of them speak the OpenAI chat completions protocol
+const (
+	LLMProviderOpenAI = "openai"
+	LLMProviderOllama = "ollama"
+	LLMProviderVLLM   = "vllm"
+)
+
+// newLLMClient creates the configured LLM backend, or nil if none is configured
+func newLLMClient(config llm.Config) (llm.Client, error) {
+	baseURL := config.BaseURL
+
+	switch config.Provider {
+	case "":
+		return nil, nil
+
+	case LLMProviderOpenAI:
+		if config.APIKey == "" && baseURL == "" {
+			return nil, fmt.Errorf("openai LLM requires an API key or a base URL")
+		}
+
+	case LLMProviderOllama:
+		if baseURL == "" {
+			baseURL = "http://localhost:11434/v1"
+		}
+		if config.Model == "" {
+			return nil, fmt.Errorf("ollama LLM requires a model (e.g. llama3.1)")
+		}
+
+	case LLMProviderVLLM:
+		if baseURL == "" {
+			baseURL = "http://localhost:8000/v1"
+		}
+		if config.Model == "" {
+			return nil, fmt.Errorf("vllm LLM requires the served model name")
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown LLM provider: %s", config.Provider)
+	}
+
+	// Local servers usually serve a single model, so use it for vision too
+	visionModel := config.VisionModel
+	if visionModel == "" && config.Provider != LLMProviderOpenAI {
+		visionModel = config.Model
+	}
+
+	return openai.NewClient(openai.Config{
+		APIKey:       config.APIKey,
+		BaseURL:      baseURL,
+		Headers:      config.Headers,
+		Model:        config.Model,
+		VisionModel:  visionModel,
+		SystemPrompt: config.SystemPrompt,
+
+		MaxHistoryMessages: config.MaxHistoryMessages,
+		MaxHistoryTokens:   config.MaxHistoryTokens,
+		SummarizeHistory:   config.SummarizeHistory,
+	}), nil
+}
+
+// TTSProvider indicates which text-to-speech provider to use
+type TTSProvider string
+
+const (
+	TTSProviderElevenLabs TTSProvider = "elevenlabs"
+	TTSProviderOpenAI     TTSProvider = "openai" // Any OpenAI-compatible /audio/speech server
+	TTSProviderPiper      TTSProvider = "piper"  // Local, offline
+	TTSProviderEspeak     TTSProvider = "espeak" // Local, offline
+)
+
+// TTSConfig holds text-to-speech provider settings
+type TTSConfig struct {
+	Provider         TTSProvider
+	ElevenLabsAPIKey string
+	OpenAIAPIKey     string
+	BaseURL          string // OpenAI-compatible server base URL
+	Model            string // Provider model; for piper, the path to the .onnx voice
+	Voice            string // Overrides the persona voice
+	SampleRate       int    // Output sample rate for OpenAI-compatible and piper providers
+}
+
+// newTTSClient creates the configured TTS client, or nil if TTS is disabled
+func newTTSClient(config TTSConfig, persona *Persona) (tts.Client, error) {
+	switch config.Provider {
+	case "":
+		return nil, nil
+
+	case TTSProviderElevenLabs:
+		if config.ElevenLabsAPIKey == "" {
+			return nil, fmt.Errorf("elevenlabs TTS requires an API key")
+		}
+		voiceID := config.Voice
+		if voiceID == "" {
+			voiceID = persona.VoiceID
+		}
+		if voiceID == "" {
+			voiceID = "21m00Tcm4TlvDq8ikWAM" // Default to Rachel
+		}
+		return elevenlabs.NewClient(elevenlabs.Config{
+			APIKey:  config.ElevenLabsAPIKey,
+			VoiceID: voiceID,
+			Model:   config.Model,
+		}).TTS(), nil
+
+	case TTSProviderOpenAI:
+		return openai.NewSpeechClient(openai.SpeechConfig{
+			APIKey:     config.OpenAIAPIKey,
+			BaseURL:    config.BaseURL,
+			Model:      config.Model,
+			Voice:      config.Voice,
+			SampleRate: config.SampleRate,
+		}), nil
+
+	case TTSProviderPiper:
+		if config.Model == "" {
+			return nil, fmt.Errorf("piper TTS requires a voice model path (-tts-model)")
+		}
+		sampleRate := config.SampleRate
+		if sampleRate == 0 {
+			sampleRate = 22050
+		}
+		return localtts.NewPiper(config.Model, sampleRate), nil
+
+	case TTSProviderEspeak:
+		return localtts.NewEspeak(config.Voice), nil
+	}
+
+	return nil, fmt.Errorf("unknown TTS provider: %s", config.Provider)
+}
+
 // AIAgent represents a voice AI agent that can process and respond to audio
 type AIAgent struct {
 	ID               string
 	PersonaName      string
 	client           *client.Client
-	sttClient        stt.Client
 	sttProvider      STTProvider
 	deepgramAPIKey   string
 	assemblyAIAPIKey string
-	openaiClient     *openai.Client
-	elevenlabsClient *elevenlabs.Client
+	llmClient        llm.Client
+	keepHistory      bool // conversation_memory setting; when false history resets between utterances
+	tools            *llm.Registry
+	enabledTools     []string
+	ttsClient        tts.Client
 	audioPipeline    *audio.AudioPipeline
 	activePeers      map[string]bool
 	peersMu          sync.RWMutex
@@ -114,19 +269,18 @@ type AIAgent struct {
 	statsMu          sync.Mutex
 	decoders         map[string]*audio.OpusDecoder
 	decodersMu       sync.Mutex
-	sttMu            sync.Mutex
 
-	// Transcript accumulation
-	transcriptMu       sync.Mutex
-	pendingTranscript  strings.Builder
-	lastTranscriptTime time.Time
-	processingLLM      bool
+	// One STT session per remote peer so concurrent speakers stay separate
+	sttSessions map[string]stt.Client
+	sttMu       sync.Mutex
+
+	// Local voice activity detection, per peer in handleIncomingAudio
+	vad VADSettings
 
-	// Interruption handling
-	speakingMu     sync.Mutex
-	isSpeaking     bool
-	cancelSpeaking chan struct{}
-	cancelLLM      context.CancelFunc
+	// Turn-taking: Listening -> Thinking -> Speaking, driven by STT and playback events
+	turns         *turn.Machine
+	onStateChange turn.StateChangeCallback
+	stateMu       sync.Mutex // Guards onStateChange, which the machine's goroutine reads
 
 	// Screenshot handling
 	screenshotMu     sync.Mutex
@@ -135,7 +289,7 @@ type AIAgent struct {
 }
 
 // NewAIAgent creates a new AI agent with the specified persona
-func NewAIAgent(id, serverURL, deepgramAPIKey, assemblyAIAPIKey, openaiAPIKey, elevenlabsAPIKey string, persona *Persona) *AIAgent {
+func NewAIAgent(id, serverURL, deepgramAPIKey, assemblyAIAPIKey string, llmConfig llm.Config, conversationMemory bool, ttsClient tts.Client, vad VADSettings, persona *Persona, clientOpts ...client.Option) (*AIAgent, error) {
 	// Build the system prompt with screen context ability
 	systemPrompt := persona.Prompt
 	if !strings.Contains(strings.ToLower(systemPrompt), "screen") {
@@ -143,34 +297,16 @@ func NewAIAgent(id, serverURL, deepgramAPIKey, assemblyAIAPIKey, openaiAPIKey, e
 		systemPrompt += " You can also see the user's screen when they share it - reference what you see when relevant."
 	}
 
-	var oaiClient *openai.Client
-	if openaiAPIKey != "" {
-		oaiClient = openai.NewClient(openai.Config{
-			APIKey:       openaiAPIKey,
-			Model:        "gpt-4o-mini", // Cost-optimized for text
-			VisionModel:  "gpt-4o",      // Auto-used when images are included
-			SystemPrompt: systemPrompt,
-		})
-	}
-
-	voiceID := persona.VoiceID
-	if voiceID == "" {
-		voiceID = "21m00Tcm4TlvDq8ikWAM" // Default to Rachel
-	}
-
-	var elevenClient *elevenlabs.Client
-	if elevenlabsAPIKey != "" {
-		elevenClient = elevenlabs.NewClient(elevenlabs.Config{
-			APIKey:  elevenlabsAPIKey,
-			VoiceID: voiceID,
-			Model:   "eleven_turbo_v2_5",
-		})
+	llmConfig.SystemPrompt = systemPrompt
+	llmClient, err := newLLMClient(llmConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	var pipeline *audio.AudioPipeline
-	if elevenClient != nil {
-		var err error
-		pipeline, err = audio.NewAudioPipeline()
+	if ttsClient != nil {
+		format := ttsClient.Format()
+		pipeline, err = audio.NewAudioPipeline(format.SampleRate, format.Channels)
 		if err != nil {
 			log.Printf("Warning: Failed to create audio pipeline: %v", err)
 		}
@@ -184,88 +320,139 @@ func NewAIAgent(id, serverURL, deepgramAPIKey, assemblyAIAPIKey, openaiAPIKey, e
 		sttProvider = STTProviderDeepgram
 	}
 
-	return &AIAgent{
+	agent := &AIAgent{
 		ID:               id,
 		PersonaName:      persona.Name,
-		client:           client.NewClient(id, serverURL),
+		client:           client.NewClient(id, serverURL, clientOpts...),
 		sttProvider:      sttProvider,
 		deepgramAPIKey:   deepgramAPIKey,
 		assemblyAIAPIKey: assemblyAIAPIKey,
-		openaiClient:     oaiClient,
-		elevenlabsClient: elevenClient,
+		llmClient:        llmClient,
+		keepHistory:      conversationMemory,
+		ttsClient:        ttsClient,
 		audioPipeline:    pipeline,
 		activePeers:      make(map[string]bool),
 		decoders:         make(map[string]*audio.OpusDecoder),
-	}
-}
+		sttSessions:      make(map[string]stt.Client),
+		vad:              vad,
 
-// handleTranscript processes transcripts from Deepgram
-func (a *AIAgent) handleTranscript(transcript string, isFinal bool) {
-	// Check if we should interrupt current speech
-	a.speakingMu.Lock()
-	speaking := a.isSpeaking
-	a.speakingMu.Unlock()
-
-	if speaking && transcript != "" {
-		log.Printf("[%s] INTERRUPTION detected: %s", a.ID, transcript)
-		a.interrupt()
+		tools:        llm.NewRegistry(),
+		enabledTools: persona.Tools,
 	}
+	// With local VAD, barge-in reacts to voice onset instead of the first interim transcript
+	agent.turns = turn.NewMachine(turn.Config{
+		Respond:             agent.respond,
+		OnStateChange:       agent.handleStateChange,
+		BargeInOnTranscript: !vad.Enabled,
+	})
+	agent.registerBuiltinTools()
 
-	a.transcriptMu.Lock()
-	defer a.transcriptMu.Unlock()
+	return agent, nil
+}
 
-	// Log the transcript
-	marker := ""
-	if isFinal {
-		marker = "[FINAL]"
+// RegisterTool adds a tool to the agent's registry
+// The tool is offered to the model only if the persona enables it by name
+func (a *AIAgent) RegisterTool(tool llm.Tool) error {
+	if err := a.tools.Register(tool); err != nil {
+		return err
 	}
-	log.Printf("[%s] TRANSCRIPT %s %s", a.ID, marker, transcript)
-
-	// Accumulate transcript (only final transcripts to avoid duplicates)
-	if isFinal && transcript != "" {
-		if a.pendingTranscript.Len() > 0 {
-			a.pendingTranscript.WriteString(" ")
-		}
-		a.pendingTranscript.WriteString(transcript)
-		a.lastTranscriptTime = time.Now()
+	if a.llmClient != nil {
+		a.llmClient.SetTools(a.tools.Subset(a.enabledTools))
 	}
+	return nil
+}
 
-	// Don't trigger LLM here - wait for utterance end
+// currentTimeArgs are the arguments of the get_current_time tool
+type currentTimeArgs struct {
+	Timezone string `json:"timezone,omitempty" description:"IANA time zone such as Europe/Berlin; defaults to the agent's local time"`
 }
 
-// handleUtteranceEnd is called when Deepgram detects the user finished speaking
-func (a *AIAgent) handleUtteranceEnd() {
-	a.transcriptMu.Lock()
-	defer a.transcriptMu.Unlock()
+// lookAtScreenArgs are the arguments of the look_at_screen tool
+type lookAtScreenArgs struct {
+	Question string `json:"question" description:"What to look for or describe on the shared screen"`
+}
 
-	if a.pendingTranscript.Len() == 0 || a.processingLLM {
-		return
+// registerBuiltinTools registers the tools every agent provides
+func (a *AIAgent) registerBuiltinTools() {
+	tools := []llm.Tool{
+		llm.NewTool("get_current_time", "Get the current date and time",
+			func(ctx context.Context, args currentTimeArgs) (string, error) {
+				now := time.Now()
+				if args.Timezone != "" {
+					loc, err := time.LoadLocation(args.Timezone)
+					if err != nil {
+						return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+					}
+					now = now.In(loc)
+				}
+				return now.Format("Monday, January 2, 2006 15:04 MST"), nil
+			}),
+
+		llm.NewTool("look_at_screen", "Look at the most recent screenshot of the user's shared screen",
+			func(ctx context.Context, args lookAtScreenArgs) (string, error) {
+				a.screenshotMu.Lock()
+				screenshot := a.latestScreenshot
+				peerID := a.screenshotPeerID
+				a.screenshotMu.Unlock()
+
+				if screenshot == "" {
+					return "No screen is being shared right now.", nil
+				}
+				describer, ok := a.llmClient.(llm.ImageDescriber)
+				if !ok {
+					return "", fmt.Errorf("the LLM backend cannot look at images")
+				}
+				log.Printf("[%s] Tool look_at_screen: %s (screen from %s)", a.ID, args.Question, peerID)
+				return describer.DescribeImage(ctx, args.Question, screenshot)
+			}),
 	}
 
-	fullTranscript := a.pendingTranscript.String()
-	a.pendingTranscript.Reset()
-	a.processingLLM = true
+	for _, tool := range tools {
+		if err := a.RegisterTool(tool); err != nil {
+			log.Printf("[%s] Failed to register tool %s: %v", a.ID, tool.Name, err)
+		}
+	}
+}
 
-	log.Printf("[%s] UTTERANCE END - processing: %s", a.ID, fullTranscript)
+// OnStateChange sets a callback for turn-taking state transitions
+func (a *AIAgent) OnStateChange(callback turn.StateChangeCallback) {
+	a.stateMu.Lock()
+	a.onStateChange = callback
+	a.stateMu.Unlock()
+}
 
-	// Process with LLM in background
-	go a.processWithLLM(fullTranscript)
+// State returns the current turn-taking state
+func (a *AIAgent) State() turn.State {
+	return a.turns.State()
 }
 
-// interrupt stops current speech and cancels pending LLM request
-func (a *AIAgent) interrupt() {
-	// Stop current TTS playback
-	a.speakingMu.Lock()
-	if a.cancelSpeaking != nil {
-		close(a.cancelSpeaking)
-		a.cancelSpeaking = nil
+// handleStateChange logs transitions and forwards them to the registered callback
+func (a *AIAgent) handleStateChange(from, to turn.State, cause turn.Event) {
+	log.Printf("[%s] STATE %s -> %s (%s from %s)", a.ID, from, to, cause.Type, cause.PeerID)
+	a.stateMu.Lock()
+	callback := a.onStateChange
+	a.stateMu.Unlock()
+	if callback != nil {
+		callback(from, to, cause)
 	}
-	a.speakingMu.Unlock()
+}
 
-	// Cancel pending LLM request
-	if a.cancelLLM != nil {
-		a.cancelLLM()
+// handleTranscript processes transcripts from a peer's STT session
+func (a *AIAgent) handleTranscript(peerID, transcript string, isFinal bool) {
+	// Log the transcript
+	marker := ""
+	if isFinal {
+		marker = "[FINAL]"
 	}
+	log.Printf("[%s] TRANSCRIPT %s %s: %s", a.ID, marker, peerID, transcript)
+
+	// The state machine accumulates final text and decides on barge-in
+	a.turns.Dispatch(turn.Event{Type: turn.EventTranscript, PeerID: peerID, Text: transcript, Final: isFinal})
+}
+
+// handleUtteranceEnd is called when a peer's STT session detects they finished speaking
+func (a *AIAgent) handleUtteranceEnd(peerID string) {
+	a.turns.Dispatch(turn.Event{Type: turn.EventUtteranceEnd, PeerID: peerID})
 }
 
 // screenKeywords are words that indicate the user wants to discuss what's on screen
@@ -288,24 +475,27 @@ func wantsScreenContext(transcript string) bool {
 	return false
 }
 
-// processWithLLM sends transcript to OpenAI and speaks the response
-func (a *AIAgent) processWithLLM(transcript string) {
-	ctx, cancel := context.WithCancel(context.Background())
-	a.cancelLLM = cancel
-
-	defer func() {
-		cancel()
-		a.cancelLLM = nil
-		a.transcriptMu.Lock()
-		a.processingLLM = false
-		a.transcriptMu.Unlock()
-	}()
+// respond answers an utterance; it is started by the turn-taking state machine,
+// which cancels ctx on barge-in. All response stages share ctx, so they stop together
+func (a *AIAgent) respond(ctx context.Context, resp turn.Response) {
+	log.Printf("[%s] UTTERANCE END from %s - processing: %s", a.ID, resp.Utterance.PeerID, resp.Utterance.Text)
+	a.processWithLLM(ctx, resp.ID, resp.Utterance.PeerID, resp.Utterance.Text)
+}
 
-	if a.openaiClient == nil {
+// processWithLLM sends a speaker's transcript to the LLM and speaks the response
+// Sentences are handed to TTS as soon as they are complete, so playback starts
+// while the rest of the response is still streaming
+func (a *AIAgent) processWithLLM(ctx context.Context, responseID int, speaker, transcript string) {
+	if a.llmClient == nil {
 		return
 	}
 
-	log.Printf("[%s] USER: %s", a.ID, transcript)
+	log.Printf("[%s] USER (%s): %s", a.ID, speaker, transcript)
+
+	// Without conversation memory every utterance starts a fresh conversation
+	if !a.keepHistory {
+		a.llmClient.ClearHistory()
+	}
 
 	// Check if we have a screenshot and the user wants screen context
 	a.screenshotMu.Lock()
@@ -314,171 +504,458 @@ func (a *AIAgent) processWithLLM(transcript string) {
 
 	includeScreenshot := screenshot != "" && wantsScreenContext(transcript)
 
-	// Collect the full response for TTS
+	// Start the speech stage; it consumes sentences as the LLM produces them
+	sentences := make(chan string, 16)
+	speechDone := make(chan struct{})
+	spoken := &spokenTracker{}
+	speaking := a.ttsClient != nil && a.audioPipeline != nil
+	go func() {
+		defer close(speechDone)
+		if speaking {
+			a.speakSentences(ctx, responseID, sentences, spoken)
+		}
+		// Drain anything left (TTS disabled or failed) so the LLM stage never blocks
+		for range sentences {
+		}
+	}()
+
+	// Collect the full response for logging and split it into sentences for TTS
 	var fullResponse strings.Builder
-	var err error
+	var splitter sentenceSplitter
+	emit := func(sentence string) {
+		select {
+		case sentences <- sentence:
+		case <-ctx.Done():
+		}
+	}
+	onChunk := func(chunk string, done bool) {
+		if done {
+			return
+		}
+		fullResponse.WriteString(chunk)
+		fmt.Print(chunk) // Stream to console
+		for _, sentence := range splitter.Push(chunk) {
+			emit(sentence)
+		}
+	}
 
+	var err error
 	if includeScreenshot {
 		log.Printf("[%s] Including screenshot in LLM request (detected screen-related query)", a.ID)
-		err = a.openaiClient.ChatStreamWithImage(ctx, transcript, screenshot, func(chunk string, done bool) {
-			if !done {
-				fullResponse.WriteString(chunk)
-				fmt.Print(chunk) // Stream to console
-			}
-		})
+		err = a.llmClient.ChatStreamWithImageAs(ctx, speaker, transcript, screenshot, onChunk)
 	} else {
-		err = a.openaiClient.ChatStreamWithContext(ctx, transcript, func(chunk string, done bool) {
-			if !done {
-				fullResponse.WriteString(chunk)
-				fmt.Print(chunk) // Stream to console
-			}
-		})
+		err = a.llmClient.ChatStreamAs(ctx, speaker, transcript, onChunk)
 	}
 	fmt.Println()
 
+	if err == nil {
+		if rest := splitter.Flush(); rest != "" {
+			emit(rest)
+		}
+	}
+	close(sentences)
+	<-speechDone
+
+	// On barge-in, make the history match what the user actually heard
+	if ctx.Err() != nil {
+		if !spoken.Started() {
+			// Cancelled while thinking (always, without TTS); the state machine asks
+			// the utterance again
+			a.llmClient.DiscardLastTurn()
+		} else {
+			heard := spoken.Text()
+			log.Printf("[%s] ASSISTANT (interrupted, spoken): %s", a.ID, heard)
+			a.llmClient.MarkInterrupted(heard)
+		}
+	}
+
 	if err != nil {
 		if ctx.Err() != nil {
 			log.Printf("[%s] LLM request cancelled (interrupted)", a.ID)
 			return
 		}
-		log.Printf("[%s] OpenAI error: %v", a.ID, err)
+		log.Printf("[%s] LLM error: %v", a.ID, err)
 		return
 	}
 
-	responseText := fullResponse.String()
-	log.Printf("[%s] ASSISTANT: %s", a.ID, responseText)
+	log.Printf("[%s] ASSISTANT: %s", a.ID, fullResponse.String())
+}
 
-	// Convert to speech and send back
-	if a.elevenlabsClient != nil && a.audioPipeline != nil && responseText != "" {
-		a.speakResponse(responseText)
-	}
+// speechFrame is an encoded Opus frame and the index of the sentence it belongs to
+type speechFrame struct {
+	opus     []byte
+	level    uint8 // RFC 6464 audio level, from the frame's PCM
+	sentence int
 }
 
-// speakResponse converts text to speech and sends it via WebRTC
-func (a *AIAgent) speakResponse(text string) {
-	// Set up speaking state and cancellation channel
-	a.speakingMu.Lock()
-	a.isSpeaking = true
-	a.cancelSpeaking = make(chan struct{})
-	cancelCh := a.cancelSpeaking
-	a.speakingMu.Unlock()
+// spokenTracker records how much of each sentence was encoded and actually sent
+// so an interrupted response can be reduced to what the user heard
+type spokenTracker struct {
+	sentences []string
+	encoded   []int  // Frames encoded per sentence
+	complete  []bool // Synthesis of the sentence finished
+	sent      []int  // Frames written to the track per sentence
+	mu        sync.Mutex
+}
 
-	defer func() {
-		a.speakingMu.Lock()
-		a.isSpeaking = false
-		a.cancelSpeaking = nil
-		a.speakingMu.Unlock()
-	}()
+// addSentence registers a sentence and returns its index
+func (t *spokenTracker) addSentence(sentence string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sentences = append(t.sentences, sentence)
+	t.encoded = append(t.encoded, 0)
+	t.complete = append(t.complete, false)
+	t.sent = append(t.sent, 0)
+	return len(t.sentences) - 1
+}
 
-	log.Printf("[%s] Speaking response...", a.ID)
+func (t *spokenTracker) frameEncoded(sentence int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.encoded[sentence]++
+}
 
-	// Reset the pipeline buffer
-	a.audioPipeline.Reset()
+func (t *spokenTracker) sentenceComplete(sentence int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.complete[sentence] = true
+}
 
-	// Get audio from ElevenLabs
-	pcmData, err := a.elevenlabsClient.Synthesize(text)
-	if err != nil {
-		log.Printf("[%s] ElevenLabs error: %v", a.ID, err)
-		return
+func (t *spokenTracker) frameSent(sentence int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent[sentence]++
+}
+
+// Started reports whether any audio was sent
+func (t *spokenTracker) Started() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, n := range t.sent {
+		if n > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	log.Printf("[%s] Got %d bytes of PCM audio from ElevenLabs (22050Hz mono)", a.ID, len(pcmData))
+// Text returns the spoken prefix: fully played sentences plus the words of the
+// interrupted sentence in proportion to the frames that were sent
+func (t *spokenTracker) Text() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	// Process through pipeline (resample, encode to Opus)
-	opusFrames, err := a.audioPipeline.ProcessChunk(pcmData)
-	if err != nil {
-		log.Printf("[%s] Audio pipeline error: %v", a.ID, err)
-		return
+	var parts []string
+	for i, sentence := range t.sentences {
+		if t.sent[i] == 0 {
+			break
+		}
+		if t.complete[i] && t.sent[i] >= t.encoded[i] {
+			parts = append(parts, sentence)
+			continue
+		}
+
+		// Partially played; synthesis may still have been running, so this is an estimate
+		words := strings.Fields(sentence)
+		n := len(words) * t.sent[i] / t.encoded[i]
+		if n > 0 {
+			parts = append(parts, strings.Join(words[:n], " "))
+		}
+		break
 	}
+	return strings.Join(parts, " ")
+}
 
-	// Flush remaining samples
-	flushFrames, _ := a.audioPipeline.Flush()
-	opusFrames = append(opusFrames, flushFrames...)
+// speakSentences synthesizes each sentence as it arrives and plays the audio via WebRTC
+// Synthesis of later sentences overlaps with playback of earlier ones
+func (a *AIAgent) speakSentences(ctx context.Context, responseID int, sentences <-chan string, spoken *spokenTracker) {
+	frames := make(chan speechFrame, 256)
 
-	duration := float64(len(opusFrames)) * 20 / 1000 // seconds
-	log.Printf("[%s] Sending %d Opus frames (%.1f seconds of audio)", a.ID, len(opusFrames), duration)
+	// TTS stage: sentence -> streamed PCM -> Opus frames
+	go func() {
+		defer close(frames)
+
+		// Reset the pipeline buffer
+		a.audioPipeline.Reset()
+
+		send := func(sentence int, encoded []audio.EncodedFrame) bool {
+			for _, frame := range encoded {
+				spoken.frameEncoded(sentence)
+				select {
+				case frames <- speechFrame{opus: frame.Opus, level: client.AudioLevel(frame.PCM), sentence: sentence}:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
 
-	// Send frames with proper timing (20ms per frame)
-	ticker := time.NewTicker(20 * time.Millisecond)
-	defer ticker.Stop()
+		last := -1
+		for sentence := range sentences {
+			index := spoken.addSentence(sentence)
+			err := a.ttsClient.SynthesizeStream(ctx, sentence, func(pcmData []byte) {
+				// Process through pipeline (resample, encode to Opus)
+				encoded, err := a.audioPipeline.ProcessChunk(pcmData)
+				if err != nil {
+					log.Printf("[%s] Audio pipeline error: %v", a.ID, err)
+					return
+				}
+				send(index, encoded)
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("[%s] TTS error: %v", a.ID, err)
+				}
+				return
+			}
+			spoken.sentenceComplete(index)
+			last = index
+		}
+
+		// Flush remaining samples; they belong to the last sentence
+		if last >= 0 {
+			flushFrames, _ := a.audioPipeline.Flush()
+			send(last, flushFrames)
+		}
+	}()
+
+	a.playFrames(ctx, responseID, frames, spoken)
+}
+
+// playFrames sends Opus frames with real-time pacing until the channel closes or ctx is cancelled
+// Every frame written to the track is recorded in spoken
+func (a *AIAgent) playFrames(ctx context.Context, responseID int, frames <-chan speechFrame, spoken *spokenTracker) {
+	const frameDuration = 20 * time.Millisecond
+
+	sent := 0
+	defer func() {
+		// Drain so the TTS stage can exit
+		for range frames {
+		}
 
-	for i, opusData := range opusFrames {
+		duration := float64(sent) * 20 / 1000 // seconds
+		if ctx.Err() != nil {
+			log.Printf("[%s] Speech interrupted after %d frames (%.1f seconds)", a.ID, sent, duration)
+		} else if sent > 0 {
+			log.Printf("[%s] Finished speaking (%d frames, %.1f seconds)", a.ID, sent, duration)
+		}
+	}()
+
+	var next time.Time
+	for {
+		var frame speechFrame
 		select {
-		case <-cancelCh:
-			log.Printf("[%s] Speech interrupted at frame %d/%d", a.ID, i, len(opusFrames))
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			if err := a.client.WriteOpus(opusData); err != nil {
-				log.Printf("[%s] Failed to send audio frame %d: %v", a.ID, i, err)
+		case f, ok := <-frames:
+			if !ok {
+				return
+			}
+			frame = f
+		}
+
+		if sent == 0 {
+			a.turns.PlaybackStarted(responseID)
+			log.Printf("[%s] Speaking response...", a.ID)
+		}
+
+		// Pace frames at 20ms; after an underrun restart the clock instead of bursting
+		now := time.Now()
+		if next.Before(now) {
+			next = now
+		}
+		if wait := next.Sub(now); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
 				return
+			case <-timer.C:
 			}
+		}
+		next = next.Add(frameDuration)
 
-			a.statsMu.Lock()
-			a.audioSent += int64(len(opusData))
-			a.statsMu.Unlock()
+		if err := a.client.WriteOpusWithLevel(frame.opus, frame.level); err != nil {
+			log.Printf("[%s] Failed to send audio frame %d: %v", a.ID, sent, err)
+			return
 		}
+		spoken.frameSent(frame.sentence)
+		sent++
+
+		a.statsMu.Lock()
+		a.audioSent += int64(len(frame.opus))
+		a.statsMu.Unlock()
 	}
+}
 
-	log.Printf("[%s] Finished speaking", a.ID)
+// sentenceSplitter accumulates streamed LLM text and cuts it into speakable sentences
+type sentenceSplitter struct {
+	buf strings.Builder
 }
 
-// ensureSTTConnected connects to the configured STT provider if not already connected
-func (a *AIAgent) ensureSTTConnected() error {
-	a.sttMu.Lock()
-	defer a.sttMu.Unlock()
+// minSentenceChars keeps very short fragments ("Hi.") attached to the next sentence
+const minSentenceChars = 12
 
-	// No STT provider configured
-	if a.sttProvider == "" {
-		return nil
-	}
+// Push adds a text delta and returns any sentences completed by it
+func (s *sentenceSplitter) Push(delta string) []string {
+	s.buf.WriteString(delta)
+	text := s.buf.String()
 
-	// Already connected
-	if a.sttClient != nil && a.sttClient.IsConnected() {
-		return nil
+	var sentences []string
+	start := 0
+	for i := 0; i < len(text)-1; i++ {
+		if !isSentenceEnd(text[i]) {
+			continue
+		}
+		// Skip closing quotes/brackets that belong to the sentence
+		end := i + 1
+		for end < len(text) && strings.IndexByte("\"')]", text[end]) >= 0 {
+			end++
+		}
+		// A boundary needs trailing whitespace, otherwise it may be "3.5" or "e.g."
+		if text[i] != '\n' && (end >= len(text) || (text[end] != ' ' && text[end] != '\n')) {
+			continue
+		}
+		sentence := strings.TrimSpace(text[start:end])
+		if len(sentence) < minSentenceChars && text[i] != '\n' {
+			continue
+		}
+		if sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+		i = end - 1
 	}
 
-	// Create client based on provider
+	s.buf.Reset()
+	s.buf.WriteString(text[start:])
+	return sentences
+}
+
+// Flush returns whatever text is left once the stream ends
+func (s *sentenceSplitter) Flush() string {
+	rest := strings.TrimSpace(s.buf.String())
+	s.buf.Reset()
+	return rest
+}
+
+func isSentenceEnd(b byte) bool {
+	return b == '.' || b == '!' || b == '?' || b == '\n'
+}
+
+// newSTTClient creates an STT client for the configured provider, or nil if none is configured
+func (a *AIAgent) newSTTClient() stt.Client {
 	switch a.sttProvider {
 	case STTProviderDeepgram:
-		client := deepgram.NewClient(deepgram.Config{
+		return deepgram.NewClient(deepgram.Config{
 			APIKey:         a.deepgramAPIKey,
 			SampleRate:     48000,
 			Channels:       2,
 			UtteranceEndMs: 1000,
 		})
-		client.OnTranscript(a.handleTranscript)
-		client.OnUtteranceEnd(a.handleUtteranceEnd)
-
-		if err := client.Connect(); err != nil {
-			log.Printf("[%s] Warning: Deepgram connection failed: %v", a.ID, err)
-			return err
-		}
-		a.sttClient = client
-		log.Printf("[%s] Using Deepgram for speech-to-text", a.ID)
-
 	case STTProviderAssemblyAI:
-		client := assemblyai.NewClient(assemblyai.Config{
+		return assemblyai.NewClient(assemblyai.Config{
 			APIKey:         a.assemblyAIAPIKey,
 			SampleRate:     48000,
 			Channels:       2,
 			UtteranceEndMs: 1000,
 		})
-		client.OnTranscript(a.handleTranscript)
-		client.OnUtteranceEnd(a.handleUtteranceEnd)
+	}
+	return nil
+}
 
-		if err := client.Connect(); err != nil {
-			log.Printf("[%s] Warning: AssemblyAI connection failed: %v", a.ID, err)
-			return err
-		}
-		a.sttClient = client
-		log.Printf("[%s] Using AssemblyAI for speech-to-text", a.ID)
+// ensureSTTSession connects an STT session for the peer if one is not already connected
+func (a *AIAgent) ensureSTTSession(peerID string) error {
+	a.sttMu.Lock()
+	defer a.sttMu.Unlock()
+
+	// Already connected
+	if session, ok := a.sttSessions[peerID]; ok && session.IsConnected() {
+		return nil
+	}
+
+	session := a.newSTTClient()
+	if session == nil {
+		// No STT provider configured
+		return nil
+	}
+
+	// Bind the peer ID so transcripts and utterance ends are attributed to the speaker
+	session.OnTranscript(func(transcript string, isFinal bool) {
+		a.handleTranscript(peerID, transcript, isFinal)
+	})
+	session.OnUtteranceEnd(func() {
+		a.handleUtteranceEnd(peerID)
+	})
+
+	if err := session.Connect(); err != nil {
+		log.Printf("[%s] Warning: %s connection failed for %s: %v", a.ID, a.sttProvider, peerID, err)
+		return err
 	}
+	a.sttSessions[peerID] = session
+	log.Printf("[%s] Opened %s speech-to-text session for %s", a.ID, a.sttProvider, peerID)
 
 	return nil
 }
 
+// getSTTSession returns the STT session for a peer, or nil if there is none
+func (a *AIAgent) getSTTSession(peerID string) stt.Client {
+	a.sttMu.Lock()
+	defer a.sttMu.Unlock()
+	return a.sttSessions[peerID]
+}
+
+// closeSTTSession tears down the STT session and pending transcript for a peer
+func (a *AIAgent) closeSTTSession(peerID string) {
+	a.sttMu.Lock()
+	session, ok := a.sttSessions[peerID]
+	delete(a.sttSessions, peerID)
+	a.sttMu.Unlock()
+
+	if ok {
+		session.Close()
+		log.Printf("[%s] Closed speech-to-text session for %s", a.ID, peerID)
+	}
+
+	a.turns.Dispatch(turn.Event{Type: turn.EventPeerLeft, PeerID: peerID})
+
+	a.decodersMu.Lock()
+	delete(a.decoders, peerID)
+	a.decodersMu.Unlock()
+}
+
+// SetToken sets the signed join token used to join the room
+func (a *AIAgent) SetToken(token string) {
+	a.client.SetToken(token)
+}
+
+// SetRoomOptions sets the room metadata used if the agent's join creates the room
+func (a *AIAgent) SetRoomOptions(opts client.RoomOptions) {
+	a.client.SetRoomOptions(opts)
+}
+
+// Done is closed when the agent's session in the room ends, e.g. when it is kicked
+func (a *AIAgent) Done() <-chan struct{} {
+	return a.client.Done()
+}
+
+// Err returns why the session ended, once Done is closed
+func (a *AIAgent) Err() error {
+	return a.client.Err()
+}
+
+// StartRecording asks the server to record the room, for reviewing the conversation
+func (a *AIAgent) StartRecording() error {
+	return a.client.StartRecording()
+}
+
+// connectTimeout bounds joining the room until media flows
+const connectTimeout = 30 * time.Second
+
 // Start connects to the bridge and begins processing
 func (a *AIAgent) Start(room string) error {
+	a.turns.Start()
+
 	// Set up audio callback
 	a.client.OnAudioReceived(func(peerID string, track *webrtc.TrackRemote) {
 		a.handleIncomingAudio(peerID, track)
@@ -487,8 +964,6 @@ func (a *AIAgent) Start(room string) error {
 	// Set up peer event callback
 	a.client.OnPeerEvent(func(peerID string, joined bool) {
 		a.peersMu.Lock()
-		defer a.peersMu.Unlock()
-
 		if joined {
 			a.activePeers[peerID] = true
 			log.Printf("[%s] New peer connected: %s (total: %d)", a.ID, peerID, len(a.activePeers))
@@ -496,6 +971,38 @@ func (a *AIAgent) Start(room string) error {
 			delete(a.activePeers, peerID)
 			log.Printf("[%s] Peer disconnected: %s (total: %d)", a.ID, peerID, len(a.activePeers))
 		}
+		a.peersMu.Unlock()
+
+		if !joined {
+			a.closeSTTSession(peerID)
+		}
+	})
+
+	// Log the room we joined; it may have been created by someone else with other settings
+	a.client.OnRoomInfo(func(info client.RoomInfo) {
+		log.Printf("[%s] Room %s: mode %s, name %q, default persona %q, max peers %d", a.ID, info.ID, info.Mode, info.Name, info.DefaultPersona, info.MaxPeers)
+	})
+
+	a.client.OnRecording(func(info client.RecordingInfo) {
+		if info.Active {
+			log.Printf("[%s] Room is being recorded (started by %s)", a.ID, info.StartedBy)
+		} else {
+			log.Printf("[%s] Room recording stopped", a.ID)
+		}
+	})
+
+	// The server tracks who is talking from audio levels, without decoding
+	a.client.OnActiveSpeaker(func(peerID string) {
+		if peerID == "" {
+			log.Printf("[%s] Nobody is speaking", a.ID)
+		} else {
+			log.Printf("[%s] Active speaker: %s", a.ID, peerID)
+		}
+	})
+
+	// The client rejoins by itself after losing the server; the room sees no leave
+	a.client.OnConnectionStateChange(func(state client.ConnectionState) {
+		log.Printf("[%s] Bridge connection %s", a.ID, state)
 	})
 
 	// Set up screenshot callback
@@ -507,8 +1014,10 @@ func (a *AIAgent) Start(room string) error {
 		log.Printf("[%s] Received screenshot from %s (%d bytes)", a.ID, peerID, len(imageData))
 	})
 
-	// Connect to the audio bridge
-	if err := a.client.Connect(room); err != nil {
+	// Connect to the audio bridge, and wait until audio can flow
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := a.client.ConnectContext(ctx, room); err != nil {
 		return fmt.Errorf("connection failed: %w", err)
 	}
 
@@ -538,8 +1047,8 @@ func (a *AIAgent) getOrCreateDecoder(peerID string) (*audio.OpusDecoder, error)
 func (a *AIAgent) handleIncomingAudio(peerID string, track *webrtc.TrackRemote) {
 	log.Printf("[%s] Processing audio stream from: %s", a.ID, peerID)
 
-	// Connect to STT provider when we start receiving audio
-	if err := a.ensureSTTConnected(); err != nil {
+	// Open an STT session for this peer when we start receiving its audio
+	if err := a.ensureSTTSession(peerID); err != nil {
 		log.Printf("[%s] STT not available: %v", a.ID, err)
 	}
 
@@ -550,6 +1059,26 @@ func (a *AIAgent) handleIncomingAudio(peerID string, track *webrtc.TrackRemote)
 		return
 	}
 
+	// Local voice activity detection; events drive barge-in, speech state gates STT
+	var vad *audio.VAD
+	var gate *sttGate
+	if a.vad.Enabled {
+		vad = audio.NewVAD(audio.VADConfig{
+			SampleRate:     decoder.SampleRate(),
+			Channels:       decoder.Channels(),
+			Aggressiveness: a.vad.Aggressiveness,
+			MinSpeechMs:    a.vad.MinSpeechMs,
+			HangoverMs:     a.vad.HangoverMs,
+		})
+		vad.OnEvent(func(event audio.VADEvent) {
+			a.handleVADEvent(peerID, event)
+		})
+		if a.vad.GateSTT {
+			gate = newSTTGate(a.vad.MinSpeechMs)
+		}
+	}
+	bytesPerSecond := decoder.SampleRate() * decoder.Channels() * 2
+
 	buf := make([]byte, 1500)
 	packet := &rtp.Packet{}
 
@@ -581,19 +1110,112 @@ func (a *AIAgent) handleIncomingAudio(peerID string, track *webrtc.TrackRemote)
 			continue
 		}
 
-		// Send to STT for transcription
-		a.sttMu.Lock()
-		sttClient := a.sttClient
-		a.sttMu.Unlock()
+		chunks := [][]byte{pcmBytes}
+		keepAlive := false
+		if vad != nil {
+			vad.Process(pcmBytes)
+			if gate != nil {
+				duration := time.Duration(len(pcmBytes)) * time.Second / time.Duration(bytesPerSecond)
+				chunks, keepAlive = gate.Filter(pcmBytes, duration, vad.Speaking())
+			}
+		}
 
-		if sttClient != nil && sttClient.IsConnected() {
-			if err := sttClient.SendAudio(pcmBytes); err != nil {
-				log.Printf("[%s] STT send error: %v", a.ID, err)
+		// Send to this peer's STT session for transcription
+		sttSession := a.getSTTSession(peerID)
+		if sttSession == nil || !sttSession.IsConnected() {
+			continue
+		}
+		for _, chunk := range chunks {
+			if err := sttSession.SendAudio(chunk); err != nil {
+				log.Printf("[%s] STT send error for %s: %v", a.ID, peerID, err)
+				break
 			}
 		}
+		if keepAlive {
+			a.keepSTTAlive(peerID, sttSession, bytesPerSecond)
+		}
 	}
 }
 
+// handleVADEvent forwards local voice activity to the turn-taking state machine
+func (a *AIAgent) handleVADEvent(peerID string, event audio.VADEvent) {
+	log.Printf("[%s] VAD %s from %s at %v", a.ID, event.Type, peerID, event.Offset)
+	switch event.Type {
+	case audio.SpeechStart:
+		a.turns.Dispatch(turn.Event{Type: turn.EventSpeechStart, PeerID: peerID})
+	case audio.SpeechEnd:
+		a.turns.Dispatch(turn.Event{Type: turn.EventSpeechEnd, PeerID: peerID})
+	}
+}
+
+// keepSTTAlive holds a gated STT session open, with silence if the provider has no keepalive message
+func (a *AIAgent) keepSTTAlive(peerID string, session stt.Client, bytesPerSecond int) {
+	var err error
+	if k, ok := session.(stt.KeepAliver); ok {
+		err = k.KeepAlive()
+	} else {
+		err = session.SendAudio(make([]byte, bytesPerSecond/10))
+	}
+	if err != nil {
+		log.Printf("[%s] STT keepalive error for %s: %v", a.ID, peerID, err)
+	}
+}
+
+// sttGate forwards a peer's audio to STT only around detected speech, so paid
+// providers are not sent silence
+type sttGate struct {
+	preRoll    [][]byte // Recent gated audio, sent when speech starts so the onset is not clipped
+	preRollDur []time.Duration
+	preRollMax time.Duration
+	tail       time.Duration // Audio still to forward after speech end
+	idle       time.Duration // Gated audio since the last keepalive
+}
+
+// newSTTGate creates a gate whose pre-roll covers the VAD's speech start delay
+func newSTTGate(minSpeechMs int) *sttGate {
+	if minSpeechMs == 0 {
+		minSpeechMs = 100 // VAD default
+	}
+	return &sttGate{preRollMax: time.Duration(minSpeechMs)*time.Millisecond + 300*time.Millisecond}
+}
+
+// Filter returns the chunks to send to STT for one chunk of audio, and whether
+// the session is due for a keepalive
+func (g *sttGate) Filter(chunk []byte, duration time.Duration, speaking bool) ([][]byte, bool) {
+	if speaking {
+		chunks := append(g.preRoll, chunk)
+		g.preRoll = nil
+		g.preRollDur = nil
+		g.tail = sttTail
+		g.idle = 0
+		return chunks, false
+	}
+
+	if g.tail > 0 {
+		g.tail -= duration
+		return [][]byte{chunk}, false
+	}
+
+	g.preRoll = append(g.preRoll, chunk)
+	g.preRollDur = append(g.preRollDur, duration)
+	var total time.Duration
+	for _, d := range g.preRollDur {
+		total += d
+	}
+	for total > g.preRollMax && len(g.preRoll) > 1 {
+		total -= g.preRollDur[0]
+		g.preRoll = g.preRoll[1:]
+		g.preRollDur = g.preRollDur[1:]
+	}
+
+	g.idle += duration
+	if g.idle >= sttKeepAliveInterval {
+		g.idle = 0
+		return nil, true
+	}
+	return nil, false
+}
+
 // SendAudio sends audio to all connected peers
 func (a *AIAgent) SendAudio(data []byte) error {
 	a.statsMu.Lock()
@@ -625,9 +1247,15 @@ func (a *AIAgent) GetStats() (received, sent int64, peers int) {
 
 // Stop disconnects the agent
 func (a *AIAgent) Stop() {
-	if a.sttClient != nil {
-		a.sttClient.Close()
+	a.turns.Stop()
+
+	a.sttMu.Lock()
+	for peerID, session := range a.sttSessions {
+		session.Close()
+		delete(a.sttSessions, peerID)
 	}
+	a.sttMu.Unlock()
+
 	a.client.Disconnect()
 	log.Printf("[%s] AI Agent stopped", a.ID)
 }
@@ -636,12 +1264,33 @@ func main() {
 	// Parse flags
 	id := flag.String("id", "", "Agent ID (required)")
 	room := flag.String("room", "ai-room", "Room to join")
+	roomName := flag.String("room-name", "", "Display name for the room, if the agent creates it")
+	roomMaxPeers := flag.Int("room-max-peers", 0, "Max peers for the room, if the agent creates it (0 for the server default)")
+	roomMaxDuration := flag.Duration("room-max-duration", 0, "Max lifetime of the room, if the agent creates it (0 for no limit)")
+	roomMode := flag.String("room-mode", "", "Room mode, if the agent creates it: sfu (a track per peer) or mcu (one mixed track)")
 	server := flag.String("server", "ws://localhost:8080/ws", "Server URL")
+	token := flag.String("token", os.Getenv("JOIN_TOKEN"), "Signed join token (or JOIN_TOKEN env)")
+	iceServers := flag.String("ice-servers", os.Getenv("ICE_SERVERS"), "Comma-separated STUN/TURN URLs replacing the public STUN server (or ICE_SERVERS env)")
+	iceUsername := flag.String("ice-username", os.Getenv("ICE_USERNAME"), "TURN username (or ICE_USERNAME env)")
+	iceCredential := flag.String("ice-credential", os.Getenv("ICE_CREDENTIAL"), "TURN credential (or ICE_CREDENTIAL env)")
+	record := flag.Bool("record", false, "Ask the server to record the room after joining (needs -recordings-dir on the server)")
 	sendTest := flag.Bool("test-audio", true, "Send test audio")
 	deepgramKey := flag.String("deepgram-key", os.Getenv("DEEPGRAM_API_KEY"), "Deepgram API key (STT)")
 	assemblyAIKey := flag.String("assemblyai-key", os.Getenv("ASSEMBLYAI_API_KEY"), "AssemblyAI API key (STT)")
 	openaiKey := flag.String("openai-key", os.Getenv("OPENAI_API_KEY"), "OpenAI API key")
+	llmProvider := flag.String("llm", "", "LLM backend: openai, ollama or vllm (overrides config)")
+	llmURL := flag.String("llm-url", "", "Base URL of an OpenAI-compatible LLM server (overrides config)")
+	llmModel := flag.String("llm-model", "", "LLM model (overrides config)")
+	llmHeaders := flag.String("llm-headers", "", "Extra LLM request headers as Key=Value,Key2=Value2")
 	elevenlabsKey := flag.String("elevenlabs-key", os.Getenv("ELEVENLABS_API_KEY"), "ElevenLabs API key")
+	ttsProvider := flag.String("tts", "", "TTS provider: elevenlabs, openai, piper or espeak (default: elevenlabs if key is set)")
+	ttsURL := flag.String("tts-url", "", "Base URL of an OpenAI-compatible TTS server")
+	ttsModel := flag.String("tts-model", "", "TTS model (for piper: path to the .onnx voice)")
+	ttsVoice := flag.String("tts-voice", "", "TTS voice (overrides the persona voice)")
+	ttsRate := flag.Int("tts-rate", 0, "Output sample rate of the openai or piper TTS provider")
+	vadFlag := flag.Bool("vad", false, "Enable local voice activity detection (overrides config)")
+	vadAggressiveness := flag.Int("vad-aggressiveness", -1, "VAD aggressiveness 0-3 (overrides config)")
+	vadGate := flag.Bool("vad-gate-stt", false, "Only send detected speech to STT (implies -vad)")
 	personaFlag := flag.String("persona", "", "Persona to use (see -list-personas)")
 	listPersonas := flag.Bool("list-personas", false, "List available personas")
 	configPath := flag.String("config", "", "Path to prompts.json config file")
@@ -671,6 +1320,7 @@ func main() {
 			},
 			Default: "assistant",
 		}
+		promptsConfig.Settings.ConversationMemory = true
 	}
 
 	// Handle list-personas flag
@@ -696,6 +1346,15 @@ func main() {
 		fmt.Println("\nOptions:")
 		fmt.Println("  -room <room>              Room to join (default: ai-room)")
 		fmt.Println("  -server <url>             Server URL (default: ws://localhost:8080/ws)")
+		fmt.Println("  -token <token>            Signed join token (or JOIN_TOKEN env)")
+		fmt.Println("  -ice-servers <urls>       STUN/TURN URLs, e.g. turn:turn.example.com:3478 (or ICE_SERVERS env)")
+		fmt.Println("  -ice-username <name>      TURN username (or ICE_USERNAME env)")
+		fmt.Println("  -ice-credential <secret>  TURN credential (or ICE_CREDENTIAL env)")
+		fmt.Println("  -room-name <name>         Room display name, if the agent creates the room")
+		fmt.Println("  -room-max-peers <n>       Room capacity, if the agent creates the room")
+		fmt.Println("  -room-max-duration <d>    Room lifetime (e.g. 1h), if the agent creates the room")
+		fmt.Println("  -room-mode <mode>         sfu or mcu (one mixed track: a single decoder and STT stream)")
+		fmt.Println("  -record                   Record the room on the server after joining")
 		fmt.Println("  -persona <name>           Persona to use (see -list-personas)")
 		fmt.Println("  -prompt <text>            Custom system prompt (overrides persona)")
 		fmt.Println("  -config <path>            Path to prompts.json config file")
@@ -703,7 +1362,19 @@ func main() {
 		fmt.Println("  -deepgram-key <key>       Deepgram API key for STT (or DEEPGRAM_API_KEY env)")
 		fmt.Println("  -assemblyai-key <key>     AssemblyAI API key for STT (or ASSEMBLYAI_API_KEY env)")
 		fmt.Println("  -openai-key <key>         OpenAI API key (or OPENAI_API_KEY env)")
+		fmt.Println("  -llm <provider>           LLM backend: openai, ollama, vllm")
+		fmt.Println("  -llm-url <url>            Base URL of an OpenAI-compatible LLM server")
+		fmt.Println("  -llm-model <model>        LLM model")
+		fmt.Println("  -llm-headers <k=v,...>    Extra LLM request headers")
 		fmt.Println("  -elevenlabs-key <key>     ElevenLabs API key (or ELEVENLABS_API_KEY env)")
+		fmt.Println("  -tts <provider>           TTS provider: elevenlabs, openai, piper, espeak")
+		fmt.Println("  -tts-url <url>            Base URL of an OpenAI-compatible TTS server")
+		fmt.Println("  -tts-model <model>        TTS model (for piper: path to the .onnx voice)")
+		fmt.Println("  -tts-voice <voice>        TTS voice (overrides the persona voice)")
+		fmt.Println("  -tts-rate <hz>            Output sample rate of the openai or piper provider")
+		fmt.Println("  -vad                      Enable local voice activity detection for barge-in")
+		fmt.Println("  -vad-aggressiveness <n>   VAD aggressiveness 0-3")
+		fmt.Println("  -vad-gate-stt             Only send detected speech to STT")
 		fmt.Println("  -test-audio=false         Disable test audio")
 		fmt.Println("\nSTT Provider Selection:")
 		fmt.Println("  If AssemblyAI key is provided, it will be used. Otherwise Deepgram is used.")
@@ -740,19 +1411,117 @@ func main() {
 	} else {
 		log.Println("Warning: No STT API key provided (DEEPGRAM_API_KEY or ASSEMBLYAI_API_KEY). Speech-to-text disabled.")
 	}
-	if *openaiKey == "" {
-		log.Println("Warning: No OpenAI API key. LLM responses disabled.")
+
+	// Determine LLM backend: config file first, flags override
+	llmConfig := promptsConfig.LLM
+	if *llmProvider != "" {
+		llmConfig.Provider = *llmProvider
+	}
+	if *llmURL != "" {
+		llmConfig.BaseURL = *llmURL
 	}
-	if *elevenlabsKey == "" {
-		log.Println("Warning: No ElevenLabs API key. Text-to-speech disabled.")
+	if *llmModel != "" {
+		llmConfig.Model = *llmModel
+	}
+	if *llmHeaders != "" {
+		if llmConfig.Headers == nil {
+			llmConfig.Headers = make(map[string]string)
+		}
+		for _, pair := range strings.Split(*llmHeaders, ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				log.Fatalf("Invalid -llm-headers entry: %q (expected Key=Value)", pair)
+			}
+			llmConfig.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	// The OpenAI key is only sent to OpenAI itself, never to a local or custom server
+	openaiLLM := llmConfig.Provider == "" || llmConfig.Provider == LLMProviderOpenAI
+	if llmConfig.APIKey == "" && openaiLLM && llmConfig.BaseURL == "" {
+		llmConfig.APIKey = *openaiKey
+	}
+	if llmConfig.Provider == "" && llmConfig.APIKey != "" {
+		llmConfig.Provider = LLMProviderOpenAI
+	}
+	if llmConfig.Provider == "" {
+		log.Println("Warning: No LLM backend (OpenAI API key or -llm). LLM responses disabled.")
+	} else {
+		log.Printf("Using %s LLM backend (model: %s)", llmConfig.Provider, llmConfig.Model)
+	}
+
+	// Determine TTS provider
+	provider := TTSProvider(*ttsProvider)
+	if provider == "" && *elevenlabsKey != "" {
+		provider = TTSProviderElevenLabs
+	}
+	ttsClient, err := newTTSClient(TTSConfig{
+		Provider:         provider,
+		ElevenLabsAPIKey: *elevenlabsKey,
+		OpenAIAPIKey:     *openaiKey,
+		BaseURL:          *ttsURL,
+		Model:            *ttsModel,
+		Voice:            *ttsVoice,
+		SampleRate:       *ttsRate,
+	}, &persona)
+	if err != nil {
+		log.Fatalf("Failed to configure TTS: %v", err)
+	}
+	if ttsClient == nil {
+		log.Println("Warning: No TTS provider (ElevenLabs API key or -tts). Text-to-speech disabled.")
+	} else {
+		log.Printf("Using %s for text-to-speech", provider)
+	}
+
+	// Local VAD: config file first, flags override
+	vadSettings := promptsConfig.VAD
+	if *vadFlag || *vadGate {
+		vadSettings.Enabled = true
+	}
+	if *vadGate {
+		vadSettings.GateSTT = true
+	}
+	if *vadAggressiveness >= 0 {
+		vadSettings.Aggressiveness = *vadAggressiveness
+	}
+	if vadSettings.Enabled {
+		log.Printf("Using local voice activity detection (aggressiveness %d, STT gating: %v)", vadSettings.Aggressiveness, vadSettings.GateSTT)
+	}
+
+	// Locked-down networks need their own STUN/TURN servers
+	var clientOpts []client.Option
+	if *iceServers != "" {
+		clientOpts = append(clientOpts, client.WithICEServers(webrtc.ICEServer{
+			URLs:       strings.Split(*iceServers, ","),
+			Username:   *iceUsername,
+			Credential: *iceCredential,
+		}))
 	}
 
 	// Create and start the AI agent
-	agent := NewAIAgent(*id, *server, *deepgramKey, *assemblyAIKey, *openaiKey, *elevenlabsKey, &persona)
+	agent, err := NewAIAgent(*id, *server, *deepgramKey, *assemblyAIKey, llmConfig, promptsConfig.Settings.ConversationMemory, ttsClient, vadSettings, &persona, clientOpts...)
+	if err != nil {
+		log.Fatalf("Failed to configure LLM: %v", err)
+	}
+
+	if *token != "" {
+		agent.SetToken(*token)
+	}
+	agent.SetRoomOptions(client.RoomOptions{
+		Name:               *roomName,
+		MaxPeers:           *roomMaxPeers,
+		MaxDurationSeconds: int(roomMaxDuration.Seconds()),
+		DefaultPersona:     personaKey,
+		Mode:               *roomMode,
+	})
 
 	if err := agent.Start(*room); err != nil {
 		log.Fatalf("Failed to start agent: %v", err)
 	}
+	if *record {
+		if err := agent.StartRecording(); err != nil {
+			log.Printf("[%s] Failed to request recording: %v", *id, err)
+		}
+	}
 
 	// Start test audio if enabled
 	done := make(chan struct{})
@@ -782,7 +1551,11 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	log.Printf("[%s] Agent running. Press Ctrl+C to stop.", *id)
-	<-sigChan
+	select {
+	case <-sigChan:
+	case <-agent.Done():
+		log.Printf("[%s] Session ended: %v", *id, agent.Err())
+	}
 
 	// Cleanup
 	close(done)
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"example.com/agent_bridge/pkg/audio"
 	"example.com/agent_bridge/pkg/stt"
 	"github.com/gorilla/websocket"
 )
@@ -33,6 +34,9 @@ type Client struct {
 
 	// Audio buffer for accumulating chunks (AssemblyAI requires 50-1000ms per send)
 	audioBuffer []byte
+
+	// Resamples mono input to 16kHz, with filter state carried across chunks
+	resampler *audio.Resampler
 }
 
 // Config holds AssemblyAI connection settings
@@ -77,19 +81,23 @@ type TerminateMessage struct {
 
 // NewClient creates a new AssemblyAI client
 func NewClient(config Config) *Client {
-	if config.SampleRate == 0 {
+	if config.SampleRate <= 0 {
 		config.SampleRate = 48000
 	}
 	if config.Channels == 0 {
 		config.Channels = 1
 	}
 
+	// Cannot fail for a positive sample rate
+	resampler, _ := audio.NewResampler(config.SampleRate, 16000, 1)
+
 	return &Client{
 		apiKey:      config.APIKey,
 		sampleRate:  config.SampleRate,
 		channels:    config.Channels,
 		done:        make(chan struct{}),
 		audioBuffer: make([]byte, 0, minAudioBytes*2),
+		resampler:   resampler,
 	}
 }
 
@@ -134,6 +142,7 @@ func (c *Client) Connect() error {
 	c.done = make(chan struct{})
 	c.lastTranscript = ""
 	c.audioBuffer = c.audioBuffer[:0] // Clear buffer
+	c.resampler.Reset()
 
 	// Start reading responses
 	go c.readResponses()
@@ -246,32 +255,13 @@ func (c *Client) SendAudio(pcmData []byte) error {
 		return fmt.Errorf("not connected")
 	}
 
-	// Convert bytes to int16 samples
-	samples := make([]int16, len(pcmData)/2)
-	for i := range samples {
-		samples[i] = int16(pcmData[i*2]) | int16(pcmData[i*2+1])<<8
-	}
-
 	// If stereo, convert to mono by averaging channels
 	if c.channels == 2 {
-		monoSamples := make([]int16, len(samples)/2)
-		for i := range monoSamples {
-			left := int32(samples[i*2])
-			right := int32(samples[i*2+1])
-			monoSamples[i] = int16((left + right) / 2)
-		}
-		samples = monoSamples
+		pcmData = audio.StereoToMono(pcmData)
 	}
 
 	// Resample from input rate to 16kHz
-	resampled := resample(samples, c.sampleRate, 16000)
-
-	// Convert back to bytes
-	resampledBytes := make([]byte, len(resampled)*2)
-	for i, s := range resampled {
-		resampledBytes[i*2] = byte(s)
-		resampledBytes[i*2+1] = byte(s >> 8)
-	}
+	resampledBytes := c.resampler.Process(pcmData)
 
 	// Buffer the audio
 	c.audioBuffer = append(c.audioBuffer, resampledBytes...)
@@ -286,32 +276,6 @@ func (c *Client) SendAudio(pcmData []byte) error {
 	return nil
 }
 
-// resample performs simple linear interpolation resampling
-func resample(samples []int16, fromRate, toRate int) []int16 {
-	if fromRate == toRate {
-		return samples
-	}
-
-	ratio := float64(fromRate) / float64(toRate)
-	outputLen := int(float64(len(samples)) / ratio)
-	output := make([]int16, outputLen)
-
-	for i := range output {
-		srcIndex := float64(i) * ratio
-		srcIndexInt := int(srcIndex)
-		frac := srcIndex - float64(srcIndexInt)
-
-		if srcIndexInt+1 < len(samples) {
-			// Linear interpolation
-			output[i] = int16(float64(samples[srcIndexInt])*(1-frac) + float64(samples[srcIndexInt+1])*frac)
-		} else if srcIndexInt < len(samples) {
-			output[i] = samples[srcIndexInt]
-		}
-	}
-
-	return output
-}
-
 // Close closes the connection
 func (c *Client) Close() error {
 	c.mu.Lock()
@@ -45,12 +45,16 @@ func (e *OpusEncoder) Encode(pcm []int16) ([]byte, error) {
 
 // EncodeBytes encodes PCM bytes (little-endian int16) to Opus
 func (e *OpusEncoder) EncodeBytes(pcmBytes []byte) ([]byte, error) {
-	numSamples := len(pcmBytes) / 2
-	pcm := make([]int16, numSamples)
-	for i := 0; i < numSamples; i++ {
+	return e.Encode(bytesToSamples(pcmBytes))
+}
+
+// bytesToSamples converts little-endian int16 PCM bytes to samples
+func bytesToSamples(pcmBytes []byte) []int16 {
+	pcm := make([]int16, len(pcmBytes)/2)
+	for i := range pcm {
 		pcm[i] = int16(binary.LittleEndian.Uint16(pcmBytes[i*2:]))
 	}
-	return e.Encode(pcm)
+	return pcm
 }
 
 // FrameSize returns the frame size in samples per channel
@@ -68,46 +72,18 @@ func (e *OpusEncoder) Channels() int {
 	return e.channels
 }
 
-// ResampleMono resamples mono PCM from one sample rate to another
-// Uses linear interpolation
+// ResampleMono resamples a complete mono PCM buffer from one sample rate to another
+// For streams, use a Resampler so filter state carries across chunks
 func ResampleMono(input []byte, inputRate, outputRate int) []byte {
 	if inputRate == outputRate {
 		return input
 	}
 
-	inputSamples := len(input) / 2
-	ratio := float64(outputRate) / float64(inputRate)
-	outputSamples := int(float64(inputSamples) * ratio)
-
-	output := make([]byte, outputSamples*2)
-
-	for i := 0; i < outputSamples; i++ {
-		// Calculate source position
-		srcPos := float64(i) / ratio
-		srcIdx := int(srcPos)
-		frac := srcPos - float64(srcIdx)
-
-		// Clamp indices
-		idx1 := srcIdx
-		idx2 := srcIdx + 1
-		if idx1 >= inputSamples {
-			idx1 = inputSamples - 1
-		}
-		if idx2 >= inputSamples {
-			idx2 = inputSamples - 1
-		}
-
-		// Get samples
-		s1 := int16(binary.LittleEndian.Uint16(input[idx1*2:]))
-		s2 := int16(binary.LittleEndian.Uint16(input[idx2*2:]))
-
-		// Linear interpolation
-		sample := int16(float64(s1)*(1-frac) + float64(s2)*frac)
-
-		binary.LittleEndian.PutUint16(output[i*2:], uint16(sample))
+	r, err := NewResampler(inputRate, outputRate, 1)
+	if err != nil {
+		return nil
 	}
-
-	return output
+	return append(r.Process(input), r.Flush()...)
 }
 
 // MonoToStereo converts mono PCM to stereo by duplicating each sample
@@ -128,6 +104,20 @@ func MonoToStereo(mono []byte) []byte {
 	return stereo
 }
 
+// StereoToMono converts interleaved stereo PCM to mono by averaging the channels
+func StereoToMono(stereo []byte) []byte {
+	numSamples := len(stereo) / 4
+	mono := make([]byte, numSamples*2)
+
+	for i := 0; i < numSamples; i++ {
+		left := int32(int16(binary.LittleEndian.Uint16(stereo[i*4:])))
+		right := int32(int16(binary.LittleEndian.Uint16(stereo[i*4+2:])))
+		binary.LittleEndian.PutUint16(mono[i*2:], uint16(int16((left+right)/2)))
+	}
+
+	return mono
+}
+
 // RTPPacketizer creates RTP packets from Opus frames
 type RTPPacketizer struct {
 	ssrc       uint32
@@ -174,86 +164,114 @@ func (p *RTPPacketizer) Packetize(opusData []byte) []byte {
 	return packet
 }
 
-// AudioPipeline processes ElevenLabs audio for WebRTC
+// EncodedFrame is a 20ms Opus frame and the PCM it was encoded from
+type EncodedFrame struct {
+	Opus []byte
+	PCM  []int16 // 48kHz stereo, interleaved; e.g. for the frame's audio level
+}
+
+// AudioPipeline processes TTS audio for WebRTC
 type AudioPipeline struct {
-	encoder *OpusEncoder
-	buffer  []byte // Buffer for accumulating PCM data
+	encoder       *OpusEncoder
+	resampler     *Resampler
+	inputRate     int
+	inputChannels int
+	buffer        []byte // Buffer for accumulating PCM data
 }
 
-// NewAudioPipeline creates a pipeline to convert ElevenLabs audio to Opus
-// ElevenLabs: 24kHz mono PCM -> WebRTC: 48kHz stereo Opus
-func NewAudioPipeline() (*AudioPipeline, error) {
+// NewAudioPipeline creates a pipeline to convert TTS audio to Opus
+// TTS: 16-bit PCM at inputRate (mono or stereo) -> WebRTC: 48kHz stereo Opus
+func NewAudioPipeline(inputRate, inputChannels int) (*AudioPipeline, error) {
+	if inputRate <= 0 {
+		return nil, fmt.Errorf("invalid input sample rate: %d", inputRate)
+	}
+	if inputChannels != 1 && inputChannels != 2 {
+		return nil, fmt.Errorf("unsupported input channel count: %d", inputChannels)
+	}
+
 	// Opus encoder: 48kHz stereo, 20ms frames (960 samples per channel)
 	encoder, err := NewOpusEncoder(48000, 2, 960)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create encoder: %w", err)
 	}
 
+	// Resample every channel to 48kHz; state carries across chunks of a stream
+	resampler, err := NewResampler(inputRate, 48000, inputChannels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resampler: %w", err)
+	}
+
 	return &AudioPipeline{
-		encoder: encoder,
-		buffer:  make([]byte, 0),
+		encoder:       encoder,
+		resampler:     resampler,
+		inputRate:     inputRate,
+		inputChannels: inputChannels,
+		buffer:        make([]byte, 0),
 	}, nil
 }
 
-// ProcessChunk converts ElevenLabs PCM (22050Hz mono) to Opus payloads (48kHz stereo)
-// Returns slice of Opus encoded frames ready to be sent via RTP
-func (p *AudioPipeline) ProcessChunk(pcm22kMono []byte) ([][]byte, error) {
-	if len(pcm22kMono) == 0 {
+// ProcessChunk converts TTS PCM (input format) to Opus payloads (48kHz stereo)
+// Returns the Opus encoded frames ready to be sent via RTP
+func (p *AudioPipeline) ProcessChunk(pcm []byte) ([]EncodedFrame, error) {
+	// Step 1: Resample to 48kHz (partial sample frames are carried to the next chunk)
+	pcm48k := p.resampler.Process(pcm)
+	if len(pcm48k) == 0 {
 		return nil, nil
 	}
 
-	// Step 1: Resample 22050Hz -> 48kHz (mono)
-	pcm48kMono := ResampleMono(pcm22kMono, 22050, 48000)
+	// Step 2: Convert mono to stereo and append to buffer
+	p.appendStereo(pcm48k)
 
-	// Step 2: Convert mono to stereo
-	pcm48kStereo := MonoToStereo(pcm48kMono)
-
-	// Step 3: Append to buffer
-	p.buffer = append(p.buffer, pcm48kStereo...)
+	// Step 3: Process complete frames
+	return p.encodeFrames(), nil
+}
 
-	// Step 4: Process complete frames
+// encodeFrames encodes every complete frame in the buffer
+func (p *AudioPipeline) encodeFrames() []EncodedFrame {
 	// Frame size: 960 samples * 2 channels * 2 bytes = 3840 bytes
 	frameBytes := 960 * 2 * 2
-	var opusFrames [][]byte
+	var frames []EncodedFrame
 
 	for len(p.buffer) >= frameBytes {
-		frame := p.buffer[:frameBytes]
+		pcm := bytesToSamples(p.buffer[:frameBytes])
 		p.buffer = p.buffer[frameBytes:]
 
 		// Encode to Opus
-		opusData, err := p.encoder.EncodeBytes(frame)
+		opusData, err := p.encoder.Encode(pcm)
 		if err != nil {
 			continue
 		}
 
-		opusFrames = append(opusFrames, opusData)
+		frames = append(frames, EncodedFrame{Opus: opusData, PCM: pcm})
 	}
 
-	return opusFrames, nil
+	return frames
+}
+
+// appendStereo adds 48kHz PCM in the input channel layout to the buffer as stereo
+func (p *AudioPipeline) appendStereo(pcm48k []byte) {
+	if p.inputChannels == 1 {
+		pcm48k = MonoToStereo(pcm48k)
+	}
+	p.buffer = append(p.buffer, pcm48k...)
 }
 
 // Flush processes any remaining buffered data (with padding if needed)
-func (p *AudioPipeline) Flush() ([][]byte, error) {
+func (p *AudioPipeline) Flush() ([]EncodedFrame, error) {
 	frameBytes := 960 * 2 * 2
 
+	// Drain the resampler's filter delay
+	if tail := p.resampler.Flush(); len(tail) > 0 {
+		p.appendStereo(tail)
+	}
+
 	// Pad the buffer to frame boundary if needed
 	if len(p.buffer) > 0 {
 		padding := make([]byte, frameBytes-len(p.buffer)%frameBytes)
 		p.buffer = append(p.buffer, padding...)
 
 		// Process the padded data
-		var opusFrames [][]byte
-		for len(p.buffer) >= frameBytes {
-			frame := p.buffer[:frameBytes]
-			p.buffer = p.buffer[frameBytes:]
-
-			opusData, err := p.encoder.EncodeBytes(frame)
-			if err != nil {
-				continue
-			}
-			opusFrames = append(opusFrames, opusData)
-		}
-		return opusFrames, nil
+		return p.encodeFrames(), nil
 	}
 
 	return nil, nil
@@ -261,5 +279,6 @@ func (p *AudioPipeline) Flush() ([][]byte, error) {
 
 // Reset clears the internal buffer
 func (p *AudioPipeline) Reset() {
+	p.resampler.Reset()
 	p.buffer = p.buffer[:0]
 }
@@ -0,0 +1,270 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// resamplerZeroCrossings is the number of sinc zero crossings on each side of the kernel
+	resamplerZeroCrossings = 16
+	// resamplerRolloff places the cutoff just below the lower Nyquist frequency
+	resamplerRolloff = 0.95
+	// resamplerKaiserBeta trades transition width for stopband attenuation (~80 dB)
+	resamplerKaiserBeta = 8.0
+	// resamplerMaxPhases caps the filter bank; finer positions interpolate between phases
+	resamplerMaxPhases = 1024
+)
+
+// Resampler is a streaming polyphase windowed-sinc resampler for interleaved PCM s16le
+// It keeps filter history across calls, so chunk boundaries are seamless, and
+// low-pass filters below the lower Nyquist frequency, so downsampling does not alias.
+// Not safe for concurrent use.
+type Resampler struct {
+	inRate, outRate int
+	channels        int
+	up, down        int // Rate ratio reduced to lowest terms: out/in = up/down
+
+	halfTaps int         // Kernel half length in input samples
+	phases   int         // Rows in the filter bank
+	bank     [][]float32 // bank[p] holds the taps for fractional position p/phases
+
+	history []float32 // Interleaved input frames, starting at input index base
+	base    int64
+	next    int64  // Input index of the next output sample
+	rem     int    // Fractional part of that position, in units of 1/up
+	partial []byte // Incomplete sample frame carried between chunks
+}
+
+// NewResampler creates a resampler between any two sample rates
+func NewResampler(inRate, outRate, channels int) (*Resampler, error) {
+	if inRate <= 0 || outRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rates: %d -> %d", inRate, outRate)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count: %d", channels)
+	}
+
+	g := gcd(inRate, outRate)
+	r := &Resampler{
+		inRate:   inRate,
+		outRate:  outRate,
+		channels: channels,
+		up:       outRate / g,
+		down:     inRate / g,
+	}
+	if r.passthrough() {
+		return r, nil
+	}
+
+	// Cutoff relative to the input Nyquist frequency; widen the kernel when downsampling
+	cutoff := resamplerRolloff * math.Min(1, float64(outRate)/float64(inRate))
+	r.halfTaps = int(math.Ceil(resamplerZeroCrossings / cutoff))
+	r.phases = r.up
+	if r.phases > resamplerMaxPhases {
+		r.phases = resamplerMaxPhases
+	}
+	r.bank = buildFilterBank(r.phases, r.halfTaps, cutoff)
+	r.Reset()
+	return r, nil
+}
+
+// buildFilterBank computes phases+1 rows of 2*halfTaps Kaiser-windowed sinc taps
+// Row p is the kernel for an output at fractional input position p/phases; the
+// extra last row (position 1) lets positions between rows be interpolated
+func buildFilterBank(phases, halfTaps int, cutoff float64) [][]float32 {
+	taps := 2 * halfTaps
+	norm := besselI0(resamplerKaiserBeta)
+	bank := make([][]float32, phases+1)
+
+	for p := range bank {
+		frac := float64(p) / float64(phases)
+		row := make([]float64, taps)
+		var sum float64
+		for j := range row {
+			// Distance from the output position to input sample i-halfTaps+1+j
+			x := float64(j-halfTaps+1) - frac
+			window := 0.0
+			if r := x / float64(halfTaps); r*r < 1 {
+				window = besselI0(resamplerKaiserBeta*math.Sqrt(1-r*r)) / norm
+			}
+			row[j] = cutoff * sinc(cutoff*x) * window
+			sum += row[j]
+		}
+
+		// Unity gain at DC for every phase
+		bank[p] = make([]float32, taps)
+		for j := range row {
+			bank[p][j] = float32(row[j] / sum)
+		}
+	}
+	return bank
+}
+
+func (r *Resampler) passthrough() bool {
+	return r.up == r.down
+}
+
+// InputRate returns the input sample rate
+func (r *Resampler) InputRate() int {
+	return r.inRate
+}
+
+// OutputRate returns the output sample rate
+func (r *Resampler) OutputRate() int {
+	return r.outRate
+}
+
+// Channels returns the number of interleaved channels
+func (r *Resampler) Channels() int {
+	return r.channels
+}
+
+// Process resamples a chunk of interleaved PCM s16le
+// Output lags the input by about halfTaps input samples; Flush drains it at end of stream
+func (r *Resampler) Process(pcm []byte) []byte {
+	frameBytes := 2 * r.channels
+	if len(r.partial) > 0 {
+		pcm = append(r.partial, pcm...)
+		r.partial = nil
+	}
+	whole := len(pcm) - len(pcm)%frameBytes
+	if whole < len(pcm) {
+		r.partial = append([]byte(nil), pcm[whole:]...)
+	}
+	pcm = pcm[:whole]
+
+	if r.passthrough() {
+		return append([]byte(nil), pcm...)
+	}
+
+	for i := 0; i < len(pcm); i += 2 {
+		r.history = append(r.history, float32(int16(binary.LittleEndian.Uint16(pcm[i:]))))
+	}
+	return r.drain()
+}
+
+// Flush returns the output still held back by the filter, as if the stream ended in silence
+func (r *Resampler) Flush() []byte {
+	r.partial = nil
+	if r.passthrough() {
+		return nil
+	}
+
+	// Zero-pad so the last input samples reach the center of the kernel
+	end := r.base + int64(len(r.history)/r.channels)
+	r.history = append(r.history, make([]float32, r.halfTaps*r.channels)...)
+	out := r.drainUntil(end)
+	r.Reset()
+	return out
+}
+
+// Reset starts a new stream, clearing filter history
+func (r *Resampler) Reset() {
+	r.partial = nil
+	if r.passthrough() {
+		return
+	}
+
+	// Prime with silence so the first output is centered on input sample 0
+	r.history = make([]float32, (r.halfTaps-1)*r.channels, (r.halfTaps-1+4096)*r.channels)
+	r.base = -int64(r.halfTaps - 1)
+	r.next = 0
+	r.rem = 0
+}
+
+// drain produces every output whose kernel is fully covered by history
+func (r *Resampler) drain() []byte {
+	return r.drainUntil(math.MaxInt64)
+}
+
+// drainUntil is drain, stopping at outputs positioned at or after input index end
+func (r *Resampler) drainUntil(end int64) []byte {
+	taps := 2 * r.halfTaps
+	available := r.base + int64(len(r.history)/r.channels)
+
+	var out []byte
+	for r.next+int64(r.halfTaps) < available && r.next < end {
+		// Pick the kernel for the fractional position, interpolating between bank rows
+		pos := r.rem * r.phases
+		row := r.bank[pos/r.up]
+		var next []float32
+		weight := float32(pos%r.up) / float32(r.up)
+		if weight != 0 {
+			next = r.bank[pos/r.up+1]
+		}
+
+		start := int(r.next-r.base-int64(r.halfTaps)+1) * r.channels
+		for ch := 0; ch < r.channels; ch++ {
+			var acc float32
+			idx := start + ch
+			if next == nil {
+				for j := 0; j < taps; j++ {
+					acc += r.history[idx] * row[j]
+					idx += r.channels
+				}
+			} else {
+				for j := 0; j < taps; j++ {
+					acc += r.history[idx] * (row[j] + weight*(next[j]-row[j]))
+					idx += r.channels
+				}
+			}
+			out = binary.LittleEndian.AppendUint16(out, uint16(clampInt16(acc)))
+		}
+
+		// Advance by down/up input samples
+		r.rem += r.down
+		r.next += int64(r.rem / r.up)
+		r.rem %= r.up
+	}
+
+	// Drop input no longer needed by any future kernel
+	if keep := r.next - int64(r.halfTaps) + 1; keep > r.base {
+		drop := int(keep-r.base) * r.channels
+		if drop > len(r.history) {
+			drop = len(r.history)
+		}
+		r.history = append(r.history[:0], r.history[drop:]...)
+		r.base += int64(drop / r.channels)
+	}
+	return out
+}
+
+func clampInt16(v float32) int16 {
+	v = float32(math.Round(float64(v)))
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
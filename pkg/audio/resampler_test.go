@@ -0,0 +1,85 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// sineFrame returns a 20 ms interleaved PCM s16le tone
+func sineFrame(rate, channels int, freq float64) []byte {
+	n := rate / 50
+	pcm := make([]byte, 0, n*channels*2)
+	for i := 0; i < n; i++ {
+		s := int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+		for ch := 0; ch < channels; ch++ {
+			pcm = binary.LittleEndian.AppendUint16(pcm, uint16(s))
+		}
+	}
+	return pcm
+}
+
+func TestResamplerChunkingIsSeamless(t *testing.T) {
+	input := bytes.Repeat(sineFrame(44100, 2, 440), 10)
+
+	whole, err := NewResampler(44100, 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(whole.Process(input), whole.Flush()...)
+
+	chunked, _ := NewResampler(44100, 48000, 2)
+	var got []byte
+	for off := 0; off < len(input); off += 1001 { // Odd size splits sample frames
+		end := min(off+1001, len(input))
+		got = append(got, chunked.Process(input[off:end])...)
+	}
+	got = append(got, chunked.Flush()...)
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("chunked output differs from one-shot output (%d vs %d bytes)", len(got), len(want))
+	}
+	if wantLen := len(input) / 4 * 48000 / 44100 * 4; len(want) < wantLen-4 || len(want) > wantLen+4 {
+		t.Fatalf("output length %d, want about %d", len(want), wantLen)
+	}
+}
+
+func benchmarkResampler(b *testing.B, inRate, outRate, channels int) {
+	r, err := NewResampler(inRate, outRate, channels)
+	if err != nil {
+		b.Fatal(err)
+	}
+	frame := sineFrame(inRate, channels, 440)
+
+	b.SetBytes(int64(len(frame)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Process(frame)
+	}
+}
+
+// Each iteration resamples one 20 ms frame
+
+func BenchmarkResampler48kTo16kMono(b *testing.B)     { benchmarkResampler(b, 48000, 16000, 1) }
+func BenchmarkResampler22050To48kMono(b *testing.B)   { benchmarkResampler(b, 22050, 48000, 1) }
+func BenchmarkResampler24kTo48kMono(b *testing.B)     { benchmarkResampler(b, 24000, 48000, 1) }
+func BenchmarkResampler44100To48kStereo(b *testing.B) { benchmarkResampler(b, 44100, 48000, 2) }
+
+func BenchmarkAudioPipeline22050Mono(b *testing.B) {
+	p, err := NewAudioPipeline(22050, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	frame := sineFrame(22050, 1, 440)
+
+	b.SetBytes(int64(len(frame)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ProcessChunk(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
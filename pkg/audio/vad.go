@@ -0,0 +1,247 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// VADEventType identifies a voice activity transition
+type VADEventType int
+
+const (
+	// SpeechStart is emitted once voiced audio lasted at least the minimum speech duration
+	SpeechStart VADEventType = iota
+	// SpeechEnd is emitted once silence lasted the full hangover
+	SpeechEnd
+)
+
+// String returns the event type name
+func (t VADEventType) String() string {
+	switch t {
+	case SpeechStart:
+		return "speech_start"
+	case SpeechEnd:
+		return "speech_end"
+	}
+	return "unknown"
+}
+
+// VADEvent is a voice activity transition
+// Offset is the stream position where the speech began or the trailing silence began
+type VADEvent struct {
+	Type   VADEventType
+	Offset time.Duration
+}
+
+// VADCallback is called for every voice activity transition
+type VADCallback func(event VADEvent)
+
+// VADConfig holds voice activity detector settings
+type VADConfig struct {
+	SampleRate     int // Input sample rate (default: 48000)
+	Channels       int // Input channels, mixed to mono (default: 1)
+	Aggressiveness int // 0 (least) to 3 (most) aggressive in filtering out non-speech (default: 0)
+	FrameMs        int // Analysis frame length: 10, 20 or 30 (default: 20)
+	MinSpeechMs    int // Voiced audio needed before speech starts (default: 100)
+	HangoverMs     int // Silence needed before speech ends (default: 400)
+}
+
+// vadLevel holds the thresholds of one aggressiveness level
+type vadLevel struct {
+	marginDB float64 // Frame energy above the noise floor
+	minDB    float64 // Absolute frame energy, in dBFS
+	maxZCR   float64 // Zero-crossing rate above which a frame is treated as noise
+}
+
+var vadLevels = [...]vadLevel{
+	{marginDB: 6, minDB: -60, maxZCR: 1},
+	{marginDB: 9, minDB: -55, maxZCR: 1},
+	{marginDB: 12, minDB: -50, maxZCR: 0.45},
+	{marginDB: 15, minDB: -45, maxZCR: 0.35},
+}
+
+// Noise floor adaptation per unvoiced frame: falls quickly, rises slowly so
+// breaths and onsets do not raise it
+const (
+	noiseFloorFall = 0.2
+	noiseFloorRise = 0.005
+)
+
+// VAD is a streaming energy-based voice activity detector
+// It compares each frame with an adaptive noise floor, so it works across
+// microphones and gain settings without calibration. Not safe for concurrent use.
+type VAD struct {
+	level      vadLevel
+	channels   int
+	frameLen   int // Mono samples per analysis frame
+	frameDur   time.Duration
+	minSpeech  int // Frames
+	hangover   int // Frames
+	callback   VADCallback
+	frame      []float64
+	partial    []byte // Incomplete sample left over from the last call
+	noiseFloor float64
+	primed     bool
+
+	speaking bool
+	voiced   int // Consecutive voiced frames
+	silent   int // Consecutive unvoiced frames while speaking
+	frames   int // Frames processed since the last reset
+}
+
+// NewVAD creates a voice activity detector
+func NewVAD(config VADConfig) *VAD {
+	if config.SampleRate == 0 {
+		config.SampleRate = 48000
+	}
+	if config.Channels == 0 {
+		config.Channels = 1
+	}
+	if config.Aggressiveness < 0 {
+		config.Aggressiveness = 0
+	}
+	if config.Aggressiveness >= len(vadLevels) {
+		config.Aggressiveness = len(vadLevels) - 1
+	}
+	if config.FrameMs == 0 {
+		config.FrameMs = 20
+	}
+	if config.MinSpeechMs == 0 {
+		config.MinSpeechMs = 100
+	}
+	if config.HangoverMs == 0 {
+		config.HangoverMs = 400
+	}
+
+	frameLen := config.SampleRate * config.FrameMs / 1000
+	return &VAD{
+		level:     vadLevels[config.Aggressiveness],
+		channels:  config.Channels,
+		frameLen:  frameLen,
+		frameDur:  time.Duration(config.FrameMs) * time.Millisecond,
+		minSpeech: framesFor(config.MinSpeechMs, config.FrameMs),
+		hangover:  framesFor(config.HangoverMs, config.FrameMs),
+		frame:     make([]float64, 0, frameLen),
+	}
+}
+
+// framesFor converts a duration in milliseconds to whole frames, at least one
+func framesFor(ms, frameMs int) int {
+	n := (ms + frameMs - 1) / frameMs
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// OnEvent sets the callback for speech start and end
+func (v *VAD) OnEvent(callback VADCallback) {
+	v.callback = callback
+}
+
+// Process analyzes PCM s16le audio; events are emitted synchronously
+// Chunks may have any length; incomplete frames are carried over to the next call
+func (v *VAD) Process(pcmData []byte) {
+	if len(v.partial) > 0 {
+		pcmData = append(v.partial, pcmData...)
+		v.partial = nil
+	}
+
+	sampleBytes := 2 * v.channels
+	whole := len(pcmData) / sampleBytes * sampleBytes
+	if whole < len(pcmData) {
+		v.partial = append([]byte(nil), pcmData[whole:]...)
+	}
+
+	for i := 0; i < whole; i += sampleBytes {
+		// Mix to mono
+		var sum float64
+		for ch := 0; ch < v.channels; ch++ {
+			sum += float64(int16(binary.LittleEndian.Uint16(pcmData[i+ch*2:])))
+		}
+		v.frame = append(v.frame, sum/float64(v.channels)/32768)
+
+		if len(v.frame) == v.frameLen {
+			v.processFrame(v.frame)
+			v.frame = v.frame[:0]
+		}
+	}
+}
+
+// Speaking reports whether speech is in progress
+func (v *VAD) Speaking() bool {
+	return v.speaking
+}
+
+// Reset forgets the stream position, speech state and noise floor
+func (v *VAD) Reset() {
+	v.frame = v.frame[:0]
+	v.partial = nil
+	v.primed = false
+	v.speaking = false
+	v.voiced = 0
+	v.silent = 0
+	v.frames = 0
+}
+
+func (v *VAD) processFrame(frame []float64) {
+	var energy float64
+	crossings := 0
+	for i, s := range frame {
+		energy += s * s
+		if i > 0 && (s >= 0) != (frame[i-1] >= 0) {
+			crossings++
+		}
+	}
+	db := 10 * math.Log10(energy/float64(len(frame))+1e-10)
+	zcr := float64(crossings) / float64(len(frame)-1)
+
+	if !v.primed {
+		v.noiseFloor = db
+		v.primed = true
+	}
+
+	voiced := db > v.noiseFloor+v.level.marginDB && db > v.level.minDB && zcr < v.level.maxZCR
+
+	// Track the noise floor on non-speech frames only, so long speech does not raise it
+	if !voiced {
+		if db < v.noiseFloor {
+			v.noiseFloor += (db - v.noiseFloor) * noiseFloorFall
+		} else {
+			v.noiseFloor += (db - v.noiseFloor) * noiseFloorRise
+		}
+	}
+
+	v.frames++
+	if !v.speaking {
+		if !voiced {
+			v.voiced = 0
+			return
+		}
+		v.voiced++
+		if v.voiced >= v.minSpeech {
+			v.speaking = true
+			v.silent = 0
+			v.emit(SpeechStart, v.frames-v.voiced)
+		}
+		return
+	}
+
+	if voiced {
+		v.silent = 0
+		return
+	}
+	v.silent++
+	if v.silent >= v.hangover {
+		v.speaking = false
+		v.voiced = 0
+		v.emit(SpeechEnd, v.frames-v.silent)
+	}
+}
+
+func (v *VAD) emit(eventType VADEventType, frame int) {
+	if v.callback != nil {
+		v.callback(VADEvent{Type: eventType, Offset: time.Duration(frame) * v.frameDur})
+	}
+}
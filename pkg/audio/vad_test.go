@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+const vadTestRate = 16000
+
+// segment is a stretch of synthetic audio at a level in dBFS (RMS)
+type segment struct {
+	ms    int
+	dbfs  float64
+	noise bool // White noise instead of a 200 Hz tone
+}
+
+func background(ms int) segment         { return segment{ms: ms, dbfs: -70, noise: true} }
+func tone(ms int, dbfs float64) segment { return segment{ms: ms, dbfs: dbfs} }
+
+// synthesize renders segments as mono PCM s16le at vadTestRate
+func synthesize(segments ...segment) []byte {
+	rng := rand.New(rand.NewPCG(1, 2))
+	var pcm []byte
+	n := 0
+	for _, seg := range segments {
+		rms := math.Pow(10, seg.dbfs/20)
+		for i := 0; i < seg.ms*vadTestRate/1000; i++ {
+			var s float64
+			if seg.noise {
+				s = rms * math.Sqrt(3) * (2*rng.Float64() - 1)
+			} else {
+				s = rms * math.Sqrt2 * math.Sin(2*math.Pi*200*float64(n)/vadTestRate)
+			}
+			pcm = binary.LittleEndian.AppendUint16(pcm, uint16(int16(s*32767)))
+			n++
+		}
+	}
+	return pcm
+}
+
+// vadResult is an event and the stream position at which it was emitted
+type vadResult struct {
+	event VADEvent
+	at    time.Duration
+}
+
+// runVAD feeds audio in 10 ms chunks and collects the events
+func runVAD(config VADConfig, pcm []byte) []vadResult {
+	config.SampleRate = vadTestRate
+	v := NewVAD(config)
+	var results []vadResult
+	fed := 0
+	v.OnEvent(func(event VADEvent) {
+		results = append(results, vadResult{event, time.Duration(fed) * time.Second / (2 * vadTestRate)})
+	})
+	const chunk = 2 * vadTestRate / 100
+	for off := 0; off < len(pcm); off += chunk {
+		end := min(off+chunk, len(pcm))
+		fed = end
+		v.Process(pcm[off:end])
+	}
+	return results
+}
+
+func started(offset, at int) vadResult {
+	return vadResult{VADEvent{SpeechStart, time.Duration(offset) * time.Millisecond}, time.Duration(at) * time.Millisecond}
+}
+
+func ended(offset, at int) vadResult {
+	return vadResult{VADEvent{SpeechEnd, time.Duration(offset) * time.Millisecond}, time.Duration(at) * time.Millisecond}
+}
+
+func TestVADTiming(t *testing.T) {
+	// Defaults: 20 ms frames, speech starts after 100 ms and ends after 400 ms of silence
+	for _, tc := range []struct {
+		name  string
+		audio []segment
+		want  []vadResult
+	}{
+		{"silence", []segment{background(2000)}, nil},
+		{"tone burst", []segment{background(300), tone(500, -20), background(1000)},
+			[]vadResult{started(300, 400), ended(800, 1200)}},
+		{"click", []segment{background(300), tone(40, -10), background(1000)}, nil},
+		{"pause within hangover", []segment{background(300), tone(400, -20), background(200), tone(400, -20), background(1000)},
+			[]vadResult{started(300, 400), ended(1300, 1700)}},
+		{"pause beyond hangover", []segment{background(300), tone(400, -20), background(600), tone(400, -20), background(1000)},
+			[]vadResult{started(300, 400), ended(700, 1100), started(1300, 1400), ended(1700, 2100)}},
+		// Long speech must not raise the noise floor and cut itself short
+		{"long speech", []segment{background(300), tone(10000, -20), background(1000)},
+			[]vadResult{started(300, 400), ended(10300, 10700)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := runVAD(VADConfig{}, synthesize(tc.audio...)); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVADMinSpeechAndHangover(t *testing.T) {
+	audio := synthesize(background(300), tone(200, -20), background(1000))
+
+	// 200 ms of tone is too short for 300 ms of minimum speech
+	if got := runVAD(VADConfig{MinSpeechMs: 300}, audio); len(got) != 0 {
+		t.Errorf("min speech 300 ms: got %v, want no events", got)
+	}
+	want := []vadResult{started(300, 360), ended(500, 700)}
+	if got := runVAD(VADConfig{MinSpeechMs: 60, HangoverMs: 200}, audio); !slices.Equal(got, want) {
+		t.Errorf("min speech 60 ms, hangover 200 ms: got %v, want %v", got, want)
+	}
+}
+
+func TestVADAggressiveness(t *testing.T) {
+	quiet := synthesize(background(300), tone(500, -52), background(1000))
+	hiss := synthesize(background(300), segment{ms: 500, dbfs: -20, noise: true}, background(1000))
+	for level, want := range []bool{true, true, false, false} {
+		config := VADConfig{Aggressiveness: level}
+		if got := len(runVAD(config, quiet)) > 0; got != want {
+			t.Errorf("level %d: quiet speech detected %v, want %v", level, got, want)
+		}
+		if got := len(runVAD(config, hiss)) > 0; got != want {
+			t.Errorf("level %d: loud hiss detected %v, want %v", level, got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// forge signs a token with the given header and claims, as a client might craft one
+func forge(t *testing.T, header tokenHeader, claims Claims) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := encoding.EncodeToString(h) + "." + encoding.EncodeToString(p)
+	return signed + "." + encoding.EncodeToString(signature(testSecret, signed))
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	token, err := NewToken(testSecret, "lobby", "alice", RoleAgent, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := Parse(testSecret, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Room != "lobby" || claims.Identity != "alice" || claims.Role != RoleAgent {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if until := time.Until(claims.Expiry()); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %v, want an hour", until)
+	}
+
+	if _, err := Parse([]byte("other-secret"), token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token verified with the wrong secret: %v", err)
+	}
+}
+
+func TestSignRejectsIncompleteClaims(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	for name, claims := range map[string]Claims{
+		"no room":     {Identity: "alice", Role: RoleAgent, ExpiresAt: expires},
+		"no identity": {Room: "lobby", Role: RoleAgent, ExpiresAt: expires},
+		"no expiry":   {Room: "lobby", Identity: "alice", Role: RoleAgent},
+		"bad role":    {Room: "lobby", Identity: "alice", Role: "root", ExpiresAt: expires},
+	} {
+		if _, err := Sign(testSecret, claims); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+	if _, err := Sign(nil, Claims{Room: "lobby", Identity: "alice", Role: RoleAgent, ExpiresAt: expires}); err == nil {
+		t.Error("signed with an empty secret")
+	}
+}
+
+func TestParseRejectsTampering(t *testing.T) {
+	token, err := NewToken(testSecret, "lobby", "alice", RoleParticipant, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	// Another signature
+	sig, _ := encoding.DecodeString(parts[2])
+	sig[0] ^= 0xff
+	badSig := parts[0] + "." + parts[1] + "." + encoding.EncodeToString(sig)
+
+	// The payload escalated to admin under the original signature
+	payload, _ := encoding.DecodeString(parts[1])
+	escalated := strings.Replace(string(payload), RoleParticipant, RoleAdmin, 1)
+	badPayload := parts[0] + "." + encoding.EncodeToString([]byte(escalated)) + "." + parts[2]
+
+	for name, tampered := range map[string]string{
+		"signature":          badSig,
+		"payload":            badPayload,
+		"signature encoding": parts[0] + "." + parts[1] + ".!!!",
+		"no signature":       parts[0] + "." + parts[1] + ".",
+	} {
+		if _, err := Parse(testSecret, tampered); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestParseRejectsOtherAlgorithms(t *testing.T) {
+	claims := Claims{Room: "lobby", Identity: "alice", Role: RoleAdmin, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	for _, alg := range []string{"none", "None", "HS512", "RS256", ""} {
+		if _, err := Parse(testSecret, forge(t, tokenHeader{Alg: alg, Typ: "JWT"}, claims)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("alg %q: got %v, want ErrInvalidToken", alg, err)
+		}
+	}
+
+	// An unsigned token, as "none" would have it
+	unsigned := strings.Split(forge(t, tokenHeader{Alg: "none", Typ: "JWT"}, claims), ".")
+	if _, err := Parse(testSecret, unsigned[0]+"."+unsigned[1]+"."); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("unsigned token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestParseExpired(t *testing.T) {
+	token, err := Sign(testSecret, Claims{Room: "lobby", Identity: "alice", Role: RoleAgent, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(testSecret, token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("got %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	token, err := NewToken(testSecret, "lobby", "alice", RoleAgent, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, malformed := range []string{
+		"",
+		"abc",
+		"a.b",
+		token + ".extra",
+		strings.Replace(token, ".", "..", 1),
+		"%%%." + strings.SplitN(token, ".", 2)[1],
+	} {
+		if _, err := Parse(testSecret, malformed); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%q: got %v, want ErrInvalidToken", malformed, err)
+		}
+	}
+}
@@ -189,6 +189,19 @@ func (c *Client) SendAudio(pcmData []byte) error {
 	return c.conn.WriteMessage(websocket.BinaryMessage, pcmData)
 }
 
+// KeepAlive keeps the stream open while no audio is sent
+// Deepgram closes streams that receive no data for about 10 seconds
+func (c *Client) KeepAlive() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected || c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	return c.conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "KeepAlive"}`))
+}
+
 // Close closes the connection
 func (c *Client) Close() error {
 	c.mu.Lock()
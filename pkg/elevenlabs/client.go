@@ -2,10 +2,15 @@ package elevenlabs
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/agent_bridge/pkg/tts"
 )
 
 const (
@@ -14,21 +19,24 @@ const (
 
 // Client is an ElevenLabs TTS client
 type Client struct {
-	apiKey  string
-	voiceID string
-	model   string
-	client  *http.Client
+	apiKey       string
+	voiceID      string
+	model        string
+	outputFormat string
+	sampleRate   int
+	client       *http.Client
 }
 
 // Config holds ElevenLabs client configuration
 type Config struct {
-	APIKey  string
-	VoiceID string // e.g., "21m00Tcm4TlvDq8ikWAM" (Rachel)
-	Model   string // e.g., "eleven_turbo_v2_5"
+	APIKey       string
+	VoiceID      string // e.g., "21m00Tcm4TlvDq8ikWAM" (Rachel)
+	Model        string // e.g., "eleven_turbo_v2_5"
+	OutputFormat string // PCM output format, e.g., "pcm_22050" (default) or "pcm_24000"
 }
 
 // AudioCallback is called with PCM audio chunks
-type AudioCallback func(pcmData []byte)
+type AudioCallback = tts.AudioCallback
 
 // NewClient creates a new ElevenLabs client
 func NewClient(config Config) *Client {
@@ -38,15 +46,32 @@ func NewClient(config Config) *Client {
 	if config.Model == "" {
 		config.Model = "eleven_turbo_v2_5" // Fast model
 	}
+	if config.OutputFormat == "" {
+		config.OutputFormat = "pcm_22050"
+	}
+
+	// pcm_<rate> formats are signed 16-bit little-endian mono
+	sampleRate, err := strconv.Atoi(strings.TrimPrefix(config.OutputFormat, "pcm_"))
+	if err != nil || !strings.HasPrefix(config.OutputFormat, "pcm_") {
+		config.OutputFormat = "pcm_22050"
+		sampleRate = 22050
+	}
 
 	return &Client{
-		apiKey:  config.APIKey,
-		voiceID: config.VoiceID,
-		model:   config.Model,
-		client:  &http.Client{},
+		apiKey:       config.APIKey,
+		voiceID:      config.VoiceID,
+		model:        config.Model,
+		outputFormat: config.OutputFormat,
+		sampleRate:   sampleRate,
+		client:       &http.Client{},
 	}
 }
 
+// Format returns the PCM format produced by the client
+func (c *Client) Format() tts.Format {
+	return tts.Format{SampleRate: c.sampleRate, Channels: 1}
+}
+
 // ttsRequest is the request body for text-to-speech
 type ttsRequest struct {
 	Text          string        `json:"text"`
@@ -60,11 +85,11 @@ type voiceSettings struct {
 	Speed           float64 `json:"speed"`
 }
 
-// Synthesize converts text to speech and returns PCM audio (signed 16-bit LE, 22050Hz mono)
+// Synthesize converts text to speech and returns PCM audio (signed 16-bit LE mono, see Format)
 func (c *Client) Synthesize(text string) ([]byte, error) {
 	// output_format must be a query parameter, not in the body
 	// pcm_22050 = 22050Hz, 16-bit signed little-endian mono PCM
-	url := fmt.Sprintf("%s/text-to-speech/%s?output_format=pcm_22050", apiURL, c.voiceID)
+	url := fmt.Sprintf("%s/text-to-speech/%s?output_format=%s", apiURL, c.voiceID, c.outputFormat)
 
 	reqBody := ttsRequest{
 		Text:    text,
@@ -110,8 +135,30 @@ func (c *Client) Synthesize(text string) ([]byte, error) {
 }
 
 // SynthesizeStream converts text to speech and streams PCM audio chunks
+//
+// Deprecated: Use SynthesizeStreamWithContext, or TTS for a tts.Client.
 func (c *Client) SynthesizeStream(text string, callback AudioCallback) error {
-	url := fmt.Sprintf("%s/text-to-speech/%s/stream?output_format=pcm_22050", apiURL, c.voiceID)
+	return c.SynthesizeStreamWithContext(context.Background(), text, callback)
+}
+
+// TTS returns the client as a tts.Client
+func (c *Client) TTS() tts.Client {
+	return ttsClient{c}
+}
+
+// ttsClient adapts Client to tts.Client, whose SynthesizeStream takes a context
+type ttsClient struct {
+	*Client
+}
+
+func (t ttsClient) SynthesizeStream(ctx context.Context, text string, callback tts.AudioCallback) error {
+	return t.SynthesizeStreamWithContext(ctx, text, callback)
+}
+
+// SynthesizeStreamWithContext streams PCM audio chunks with cancellation support
+// Cancelling the context aborts the HTTP request and stops further callbacks
+func (c *Client) SynthesizeStreamWithContext(ctx context.Context, text string, callback AudioCallback) error {
+	url := fmt.Sprintf("%s/text-to-speech/%s/stream?output_format=%s", apiURL, c.voiceID, c.outputFormat)
 
 	reqBody := ttsRequest{
 		Text:    text,
@@ -128,7 +175,7 @@ func (c *Client) SynthesizeStream(text string, callback AudioCallback) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -138,6 +185,9 @@ func (c *Client) SynthesizeStream(text string, callback AudioCallback) error {
 
 	resp, err := c.client.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
@@ -161,6 +211,9 @@ func (c *Client) SynthesizeStream(text string, callback AudioCallback) error {
 			if err == io.EOF {
 				break
 			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			return fmt.Errorf("read error: %w", err)
 		}
 	}
@@ -0,0 +1,54 @@
+package llm
+
+import "context"
+
+// StreamCallback is called for each chunk of the streaming response
+// The final call has done=true and carries the complete response
+type StreamCallback func(chunk string, done bool)
+
+// Client defines the interface for streaming chat providers
+// Implementations keep the conversation history between calls
+type Client interface {
+	// ChatStreamAs sends a user turn on behalf of speaker and streams the response
+	// An empty speaker means the turn is not attributed to anyone
+	ChatStreamAs(ctx context.Context, speaker, userMessage string, callback StreamCallback) error
+
+	// ChatStreamWithImageAs is like ChatStreamAs but attaches a base64 JPEG image to the turn
+	ChatStreamWithImageAs(ctx context.Context, speaker, userMessage, imageBase64 string, callback StreamCallback) error
+
+	// MarkInterrupted rewrites the latest assistant turn to the text the user actually
+	// heard before barging in, and marks it as interrupted
+	MarkInterrupted(spoken string)
+
+	// DiscardLastTurn removes the latest user turn and the response to it, for
+	// responses cancelled before the user heard anything
+	DiscardLastTurn()
+
+	// SetTools sets the tools the model may call; handlers run while the response streams
+	SetTools(tools []Tool)
+
+	// ClearHistory clears the conversation history
+	ClearHistory()
+}
+
+// ImageDescriber is implemented by clients that can answer a one-off question about
+// an image without adding it to the conversation history
+type ImageDescriber interface {
+	DescribeImage(ctx context.Context, prompt, imageBase64 string) (string, error)
+}
+
+// Config holds common LLM backend settings
+type Config struct {
+	Provider     string            `json:"provider"`     // e.g., "openai", "ollama", "vllm"
+	BaseURL      string            `json:"base_url"`     // e.g., "http://localhost:11434/v1"
+	APIKey       string            `json:"api_key"`      // Optional for local servers
+	Model        string            `json:"model"`        // e.g., "gpt-4o-mini"
+	VisionModel  string            `json:"vision_model"` // Used when an image is attached
+	Headers      map[string]string `json:"headers"`      // Extra HTTP headers sent with every request
+	SystemPrompt string            `json:"-"`
+
+	// History budget; zero means unlimited
+	MaxHistoryMessages int  `json:"max_history_messages"`
+	MaxHistoryTokens   int  `json:"max_history_tokens"`
+	SummarizeHistory   bool `json:"summarize_history"` // Summarize trimmed turns instead of forgetting them
+}
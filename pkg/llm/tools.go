@@ -0,0 +1,183 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ToolHandler runs a tool with the model-provided JSON arguments
+// The returned string is sent back to the model as the tool result
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Tool describes a function the model may call
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // JSON Schema of the arguments object
+	Handler     ToolHandler
+}
+
+// NewTool creates a tool whose arguments are decoded into Args
+// The parameter schema is derived from Args' exported fields: the json tag names
+// the property, a description tag documents it, and omitempty makes it optional
+func NewTool[Args any](name, description string, handler func(ctx context.Context, args Args) (string, error)) Tool {
+	var zero Args
+	schema := schemaFor(reflect.TypeOf(zero))
+	if schema["type"] != "object" {
+		// Function parameters must always be an object
+		schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	params, _ := json.Marshal(schema)
+
+	return Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  params,
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args Args
+			if len(arguments) > 0 && string(arguments) != "null" {
+				if err := json.Unmarshal(arguments, &args); err != nil {
+					return "", fmt.Errorf("invalid arguments for %s: %w", name, err)
+				}
+			}
+			return handler(ctx, args)
+		},
+	}
+}
+
+// schemaFor builds a JSON Schema for a Go type
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name := field.Name
+			optional := false
+			if tag := field.Tag.Get("json"); tag != "" {
+				parts := strings.Split(tag, ",")
+				if parts[0] == "-" {
+					continue
+				}
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				for _, opt := range parts[1:] {
+					if opt == "omitempty" {
+						optional = true
+					}
+				}
+			}
+
+			prop := schemaFor(field.Type)
+			if desc := field.Tag.Get("description"); desc != "" {
+				prop["description"] = desc
+			}
+			properties[name] = prop
+			if !optional {
+				required = append(required, name)
+			}
+		}
+
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+
+	return map[string]interface{}{}
+}
+
+// Registry holds the tools an agent can offer to the model
+type Registry struct {
+	tools map[string]Tool
+	mu    sync.RWMutex
+}
+
+// NewRegistry creates an empty tool registry
+func NewRegistry() *Registry {
+	return &Registry{
+		tools: make(map[string]Tool),
+	}
+}
+
+// Register adds a tool, replacing any existing tool with the same name
+func (r *Registry) Register(tool Tool) error {
+	if tool.Name == "" {
+		return fmt.Errorf("tool name is required")
+	}
+	if tool.Handler == nil {
+		return fmt.Errorf("tool %s has no handler", tool.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[tool.Name] = tool
+	return nil
+}
+
+// Get returns the tool with the given name
+func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Names returns the names of all registered tools in sorted order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Subset returns the registered tools with the given names, skipping unknown ones
+func (r *Registry) Subset(names []string) []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(names))
+	for _, name := range names {
+		if tool, ok := r.tools[name]; ok {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
@@ -0,0 +1,184 @@
+package localtts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"example.com/agent_bridge/pkg/tts"
+)
+
+// Client is a TTS client that runs a local command (e.g. piper or espeak-ng) per utterance
+// The text is written to the command's stdin and PCM audio is read from its stdout
+type Client struct {
+	command string
+	args    []string
+	format  tts.Format
+}
+
+// Config holds local TTS command settings
+type Config struct {
+	Command    string   // Executable, e.g., "piper" or "espeak-ng"
+	Args       []string // Command arguments
+	SampleRate int      // Sample rate of the command's output (default 22050)
+	Channels   int      // Channels of the command's output (default 1)
+}
+
+// NewClient creates a new local command TTS client
+func NewClient(config Config) *Client {
+	if config.SampleRate == 0 {
+		config.SampleRate = 22050
+	}
+	if config.Channels == 0 {
+		config.Channels = 1
+	}
+
+	return &Client{
+		command: config.Command,
+		args:    config.Args,
+		format:  tts.Format{SampleRate: config.SampleRate, Channels: config.Channels},
+	}
+}
+
+// NewPiper creates a client for the piper neural TTS engine
+// Medium and high quality piper voices output 22050Hz, low quality voices 16000Hz
+func NewPiper(modelPath string, sampleRate int) *Client {
+	return NewClient(Config{
+		Command:    "piper",
+		Args:       []string{"--model", modelPath, "--output-raw"},
+		SampleRate: sampleRate,
+		Channels:   1,
+	})
+}
+
+// NewEspeak creates a client for espeak-ng, which writes 22050Hz mono WAV to stdout
+func NewEspeak(voice string) *Client {
+	args := []string{"--stdout", "--stdin"}
+	if voice != "" {
+		args = append(args, "-v", voice)
+	}
+	return NewClient(Config{
+		Command:    "espeak-ng",
+		Args:       args,
+		SampleRate: 22050,
+		Channels:   1,
+	})
+}
+
+// Format returns the PCM format produced by the command
+func (c *Client) Format() tts.Format {
+	return c.format
+}
+
+// SynthesizeStream runs the command for the text and streams its PCM output
+// A WAV header at the start of the output is validated and skipped
+func (c *Client) SynthesizeStream(ctx context.Context, text string, callback tts.AudioCallback) error {
+	cmd := exec.CommandContext(ctx, c.command, c.args...)
+	cmd.Stdin = strings.NewReader(text)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", c.command, err)
+	}
+
+	readErr := c.streamOutput(bufio.NewReader(stdout), callback)
+	if readErr != nil {
+		// Make sure the process does not linger if we stopped reading early
+		cmd.Process.Kill()
+	}
+
+	waitErr := cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if waitErr != nil {
+		return fmt.Errorf("%s failed: %w: %s", c.command, waitErr, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// streamOutput reads PCM from the command output, skipping a WAV header if present
+func (c *Client) streamOutput(r *bufio.Reader, callback tts.AudioCallback) error {
+	if magic, err := r.Peek(4); err == nil && string(magic) == "RIFF" {
+		if err := c.skipWAVHeader(r); err != nil {
+			return err
+		}
+	}
+
+	// Stream PCM chunks
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// Make a copy of the data for the callback
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			callback(chunk)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("read error: %w", err)
+		}
+	}
+}
+
+// skipWAVHeader consumes RIFF chunks up to the start of the "data" chunk
+func (c *Client) skipWAVHeader(r *bufio.Reader) error {
+	riff := make([]byte, 12) // "RIFF" <size> "WAVE"
+	if _, err := io.ReadFull(r, riff); err != nil {
+		return fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(riff[8:12]) != "WAVE" {
+		return fmt.Errorf("unsupported RIFF output: %q", riff[8:12])
+	}
+
+	for {
+		header := make([]byte, 8) // <id> <size>
+		if _, err := io.ReadFull(r, header); err != nil {
+			return fmt.Errorf("failed to read WAV chunk: %w", err)
+		}
+		id := string(header[:4])
+		size := binary.LittleEndian.Uint32(header[4:])
+
+		if id == "data" {
+			return nil
+		}
+
+		chunk := make([]byte, size+size%2) // chunks are word aligned
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return fmt.Errorf("failed to read WAV %s chunk: %w", id, err)
+		}
+
+		if id == "fmt " && len(chunk) >= 16 {
+			audioFormat := binary.LittleEndian.Uint16(chunk[0:])
+			channels := int(binary.LittleEndian.Uint16(chunk[2:]))
+			sampleRate := int(binary.LittleEndian.Uint32(chunk[4:]))
+			bitsPerSample := binary.LittleEndian.Uint16(chunk[14:])
+			if audioFormat != 1 || bitsPerSample != 16 {
+				return fmt.Errorf("unsupported WAV encoding (format %d, %d bits)", audioFormat, bitsPerSample)
+			}
+			if sampleRate != c.format.SampleRate || channels != c.format.Channels {
+				return fmt.Errorf("WAV output is %dHz/%dch, configured for %dHz/%dch",
+					sampleRate, channels, c.format.SampleRate, c.format.Channels)
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package oggopus writes Opus audio received over RTP to Ogg Opus files (RFC 7845)
+package oggopus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// SampleRate is the Opus RTP clock rate, and the rate granule positions count in
+	SampleRate = 48000
+
+	// PreSkip is the number of samples a decoder discards at the start of the stream
+	// RFC 7845 recommends 80 ms for streams cut from the middle of an encoding, to let
+	// the decoder converge; decoded audio therefore starts 80 ms after the first packet
+	PreSkip = 3840
+
+	// maxGapFill is the longest timestamp gap filled with silence; a longer jump is
+	// taken as a sender timestamp reset and written without a gap
+	maxGapFill = 10 * 60 * SampleRate
+
+	// Pages are flushed once they hold this many payload bytes, which bounds the
+	// audio lost if the process dies and keeps seeking granular
+	maxPageBytes = 4096
+	maxSegments  = 255
+
+	headerTypeBOS = 0x02
+	headerTypeEOS = 0x04
+)
+
+// silenceFrames are single-frame CELT packets that decode to silence, longest first
+var silenceFrames = []struct {
+	samples int
+	packet  []byte
+}{
+	{960, []byte{0xf8, 0xff, 0xfe}}, // 20 ms
+	{480, []byte{0xf0, 0xff, 0xfe}}, // 10 ms
+	{240, []byte{0xe8, 0xff, 0xfe}}, // 5 ms
+	{120, []byte{0xe0, 0xff, 0xfe}}, // 2.5 ms
+}
+
+// ErrInvalidPacket is returned for RTP payloads that are not valid Opus packets
+var ErrInvalidPacket = errors.New("oggopus: invalid Opus packet")
+
+// Writer writes one logical Ogg Opus stream
+//
+// Granule positions count the samples actually written, as RFC 7845 requires.
+// Gaps in the RTP timestamps (packet loss, DTX, a muted sender) are filled with
+// silence, so a position in the file maps to the same offset in the RTP timeline.
+// Late and duplicate packets are dropped. Writer is not safe for concurrent use.
+type Writer struct {
+	out     io.Writer
+	serial  uint32
+	pageSeq uint32
+
+	granule       uint64 // Samples in the packets written so far
+	nextTimestamp uint32 // RTP timestamp expected after the last packet
+	started       bool
+
+	// The page being filled
+	segments []byte
+	payload  []byte
+}
+
+// NewWriter writes the Opus identification and comment headers and returns a Writer
+// channels is the output channel count, 1 or 2
+func NewWriter(out io.Writer, channels uint8) (*Writer, error) {
+	if channels != 1 && channels != 2 {
+		return nil, errors.New("oggopus: channels must be 1 or 2")
+	}
+	w := &Writer{out: out, serial: rand.Uint32()}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // Version
+	head[9] = channels
+	binary.LittleEndian.PutUint16(head[10:], PreSkip)
+	binary.LittleEndian.PutUint32(head[12:], SampleRate) // Original input rate, informational
+	// Output gain and mapping family stay 0
+	if err := w.writePage([][]byte{head}, 0, headerTypeBOS); err != nil {
+		return nil, err
+	}
+
+	const vendor = "agent_bridge"
+	tags := make([]byte, 0, 8+4+len(vendor)+4)
+	tags = append(tags, "OpusTags"...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(vendor)))
+	tags = append(tags, vendor...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0) // No user comments
+	if err := w.writePage([][]byte{tags}, 0, 0); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteRTP appends the Opus packet carried by an RTP packet
+func (w *Writer) WriteRTP(packet *rtp.Packet) error {
+	if len(packet.Payload) == 0 {
+		return nil // Padding only
+	}
+	samples := PacketSamples(packet.Payload)
+	if samples == 0 {
+		return ErrInvalidPacket
+	}
+
+	if w.started {
+		gap := int32(packet.Timestamp - w.nextTimestamp)
+		if gap < 0 {
+			return nil // Late or duplicate; its time was already written
+		}
+		if gap <= maxGapFill {
+			if err := w.fillSilence(int(gap)); err != nil {
+				return err
+			}
+		}
+	}
+	w.started = true
+	w.nextTimestamp = packet.Timestamp + uint32(samples)
+
+	return w.addPacket(packet.Payload, samples)
+}
+
+// Samples returns the number of 48 kHz samples written, including the pre-skip
+func (w *Writer) Samples() uint64 {
+	return w.granule
+}
+
+// Close writes the final page, marked end of stream
+// It does not close the underlying writer
+func (w *Writer) Close() error {
+	if len(w.segments) == 0 {
+		// Nothing buffered; end the stream with an empty page
+		return w.writePage(nil, w.granule, headerTypeEOS)
+	}
+	return w.flush(headerTypeEOS)
+}
+
+// fillSilence writes silence frames covering samples, rounded down to 2.5 ms
+func (w *Writer) fillSilence(samples int) error {
+	for _, frame := range silenceFrames {
+		for samples >= frame.samples {
+			if err := w.addPacket(frame.packet, frame.samples); err != nil {
+				return err
+			}
+			samples -= frame.samples
+		}
+	}
+	return nil
+}
+
+// addPacket adds a packet to the current page, flushing the page first if it is full
+func (w *Writer) addPacket(packet []byte, samples int) error {
+	lacing := len(packet)/255 + 1
+	if len(w.segments)+lacing > maxSegments || len(w.payload)+len(packet) > maxPageBytes {
+		if err := w.flush(0); err != nil {
+			return err
+		}
+	}
+
+	for n := len(packet); n >= 255; n -= 255 {
+		w.segments = append(w.segments, 255)
+	}
+	w.segments = append(w.segments, byte(len(packet)%255))
+	w.payload = append(w.payload, packet...)
+	w.granule += uint64(samples)
+	return nil
+}
+
+// flush writes the current page; its granule position is that of its last packet
+func (w *Writer) flush(headerType byte) error {
+	if len(w.segments) == 0 {
+		return nil
+	}
+	err := w.emit(w.segments, w.payload, w.granule, headerType)
+	w.segments = w.segments[:0]
+	w.payload = w.payload[:0]
+	return err
+}
+
+// writePage writes packets as a page of their own
+func (w *Writer) writePage(packets [][]byte, granule uint64, headerType byte) error {
+	var segments, payload []byte
+	for _, packet := range packets {
+		for n := len(packet); n >= 255; n -= 255 {
+			segments = append(segments, 255)
+		}
+		segments = append(segments, byte(len(packet)%255))
+		payload = append(payload, packet...)
+	}
+	return w.emit(segments, payload, granule, headerType)
+}
+
+// emit serializes and writes one Ogg page (RFC 3533)
+func (w *Writer) emit(segments, payload []byte, granule uint64, headerType byte) error {
+	page := make([]byte, 27+len(segments)+len(payload))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], w.serial)
+	binary.LittleEndian.PutUint32(page[18:], w.pageSeq)
+	page[26] = byte(len(segments))
+	copy(page[27:], segments)
+	copy(page[27+len(segments):], payload)
+	binary.LittleEndian.PutUint32(page[22:], Checksum(page))
+
+	w.pageSeq++
+	_, err := w.out.Write(page)
+	return err
+}
+
+// PacketSamples returns the duration of an Opus packet in 48 kHz samples, from its
+// TOC byte (RFC 6716 section 3.1), or 0 if the packet is malformed
+func PacketSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+
+	var frameSamples int
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60 ms
+		frameSamples = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid: 10, 20 ms
+		frameSamples = []int{480, 960}[config%2]
+	default: // CELT: 2.5, 5, 10, 20 ms
+		frameSamples = []int{120, 240, 480, 960}[config%4]
+	}
+
+	var frames int
+	switch toc & 0x03 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	default:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+
+	samples := frames * frameSamples
+	if frames == 0 || samples > 5760 { // At most 120 ms per packet
+		return 0
+	}
+	return samples
+}
+
+var crcTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// Checksum computes the Ogg CRC of a page whose checksum field is zero
+func Checksum(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
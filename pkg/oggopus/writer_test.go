@@ -0,0 +1,178 @@
+package oggopus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// page is a parsed Ogg page
+type page struct {
+	headerType byte
+	granule    uint64
+	seq        uint32
+	packets    [][]byte
+}
+
+// readPages parses an Ogg stream, checking each page's checksum
+func readPages(t *testing.T, data []byte) []page {
+	t.Helper()
+	var pages []page
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("bad page header at %d bytes from the end", len(data))
+		}
+		nsegs := int(data[26])
+		segments := data[27 : 27+nsegs]
+		size := 27 + nsegs
+		for _, s := range segments {
+			size += int(s)
+		}
+		raw := append([]byte(nil), data[:size]...)
+
+		want := binary.LittleEndian.Uint32(raw[22:])
+		binary.LittleEndian.PutUint32(raw[22:], 0)
+		if got := Checksum(raw); got != want {
+			t.Fatalf("page %d: checksum %08x, want %08x", len(pages), got, want)
+		}
+
+		p := page{
+			headerType: raw[5],
+			granule:    binary.LittleEndian.Uint64(raw[6:]),
+			seq:        binary.LittleEndian.Uint32(raw[18:]),
+		}
+		body := raw[27+nsegs:]
+		var packet []byte
+		for _, s := range segments {
+			packet = append(packet, body[:s]...)
+			body = body[s:]
+			if s < 255 {
+				p.packets = append(p.packets, packet)
+				packet = nil
+			}
+		}
+		pages = append(pages, p)
+		data = data[size:]
+	}
+	return pages
+}
+
+func opusPacket(timestamp uint32) *rtp.Packet {
+	// TOC 0x78: SILK wideband 20 ms, one frame
+	return &rtp.Packet{Header: rtp.Header{Timestamp: timestamp}, Payload: []byte{0x78, 1, 2, 3}}
+}
+
+func TestWriterGranulePositions(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := uint32(0xffffff00) // Wraps during the stream
+	for _, offset := range []uint32{
+		0, 960,
+		960,  // Duplicate
+		3840, // 40 ms gap, filled with silence
+		2880, // Late
+		4800,
+	} {
+		if err := w.WriteRTP(opusPacket(start + offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteRTP(&rtp.Packet{Payload: []byte{0x03}}); err != ErrInvalidPacket {
+		t.Errorf("code 3 packet without a frame count: got %v, want ErrInvalidPacket", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Audio spans the first packet to the end of the last one
+	if got := w.Samples(); got != 4800+960 {
+		t.Errorf("Samples() = %d, want %d", got, 4800+960)
+	}
+
+	pages := readPages(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want headers and one audio page", len(pages))
+	}
+	head, tags, audio := pages[0], pages[1], pages[2]
+	if head.headerType != headerTypeBOS || string(head.packets[0][:8]) != "OpusHead" || head.granule != 0 {
+		t.Errorf("bad identification page %+v", head)
+	}
+	if ch, skip := head.packets[0][9], binary.LittleEndian.Uint16(head.packets[0][10:]); ch != 1 || skip != PreSkip {
+		t.Errorf("OpusHead channels %d pre-skip %d", ch, skip)
+	}
+	if string(tags.packets[0][:8]) != "OpusTags" || tags.granule != 0 {
+		t.Errorf("bad comment page %+v", tags)
+	}
+	if audio.headerType != headerTypeEOS || audio.seq != 2 {
+		t.Errorf("audio page type %d seq %d, want end of stream at 2", audio.headerType, audio.seq)
+	}
+	if want := uint64(4800 + 960); audio.granule != want {
+		t.Errorf("granule %d, want %d", audio.granule, want)
+	}
+
+	// Two packets, two 20 ms silence frames, two packets
+	var total int
+	for _, packet := range audio.packets {
+		total += PacketSamples(packet)
+	}
+	if len(audio.packets) != 6 || !bytes.Equal(audio.packets[2], silenceFrames[0].packet) || total != 4800+960 {
+		t.Errorf("audio packets %x (%d samples)", audio.packets, total)
+	}
+}
+
+func TestWriterSplitsPages(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, 2)
+	payload := append([]byte{0xfc}, make([]byte, 299)...) // CELT 20 ms stereo, spans two segments
+	for i := uint32(0); i < 50; i++ {
+		if err := w.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: i * 960}, Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	pages := readPages(t, buf.Bytes())[2:]
+	if len(pages) < 2 {
+		t.Fatalf("50 packets of 300 bytes fit in %d page(s)", len(pages))
+	}
+	var packets int
+	for i, p := range pages {
+		packets += len(p.packets)
+		if want := uint64(packets * 960); p.granule != want {
+			t.Errorf("page %d granule %d, want %d", i, p.granule, want)
+		}
+		if last := i == len(pages)-1; (p.headerType == headerTypeEOS) != last {
+			t.Errorf("page %d header type %d", i, p.headerType)
+		}
+	}
+	if packets != 50 {
+		t.Errorf("read back %d packets, want 50", packets)
+	}
+}
+
+func TestPacketSamples(t *testing.T) {
+	for _, tc := range []struct {
+		packet []byte
+		want   int
+	}{
+		{[]byte{0x08}, 960},        // SILK NB 20 ms
+		{[]byte{0x18}, 2880},       // SILK NB 60 ms
+		{[]byte{0x60}, 480},        // Hybrid SWB 10 ms
+		{[]byte{0xe0}, 120},        // CELT 2.5 ms
+		{[]byte{0xf9}, 1920},       // CELT 20 ms, two frames
+		{[]byte{0x1b, 0x02}, 5760}, // SILK 60 ms, code 3 with two frames
+		{[]byte{0x1b, 0x03}, 0},    // 180 ms exceeds the maximum
+		{[]byte{0xfb, 0x00}, 0},    // Zero frames
+		{nil, 0},
+	} {
+		if got := PacketSamples(tc.packet); got != tc.want {
+			t.Errorf("PacketSamples(%x) = %d, want %d", tc.packet, got, tc.want)
+		}
+	}
+}
@@ -7,16 +7,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
+
+	"example.com/agent_bridge/pkg/llm"
 )
 
-const apiURL = "https://api.openai.com/v1/chat/completions"
+// defaultBaseURL is used when Config.BaseURL is empty
+const defaultBaseURL = "https://api.openai.com/v1"
 
 // Message represents a chat message
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`         // Speaker of a user turn in multi-party rooms
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Tools requested by an assistant turn
+	ToolCallID string     `json:"tool_call_id,omitempty"` // Call answered by a "tool" turn
+}
+
+// ToolCall represents a function call requested by the model
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"` // Always "function"
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall holds the function name and JSON-encoded arguments of a tool call
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // ContentPart represents a part of a vision message content
@@ -36,31 +58,50 @@ type ImageURL struct {
 type VisionMessage struct {
 	Role    string        `json:"role"`
 	Content []ContentPart `json:"content"`
+	Name    string        `json:"name,omitempty"`
 }
 
-// Client is an OpenAI API client
+// Client is a chat client for OpenAI and OpenAI-compatible APIs (Ollama, vLLM, ...)
 type Client struct {
 	apiKey       string
+	baseURL      string
+	headers      map[string]string
 	model        string
 	visionModel  string
 	systemPrompt string
 	httpClient   *http.Client
-	messages     []Message // Conversation history
+	history      *History // Conversation history
+	summarize    bool     // Replace trimmed turns with an LLM-generated summary
+	tools        map[string]llm.Tool
+	toolsMu      sync.RWMutex
+	turnMu       sync.Mutex // Serializes turns so history stays in order
+
+	// Trimmed turns waiting to be folded into the summary
+	summaryMu      sync.Mutex
+	pendingSummary []Message
+	summarizing    bool
 }
 
 // Config holds OpenAI client configuration
 type Config struct {
-	APIKey       string
-	Model        string // e.g., "gpt-4o-mini" for text (default)
-	VisionModel  string // e.g., "gpt-4o" for vision (default)
+	APIKey       string            // Optional for local servers
+	BaseURL      string            // e.g., "https://api.openai.com/v1" (default) or "http://localhost:11434/v1"
+	Headers      map[string]string // Extra HTTP headers sent with every request
+	Model        string            // e.g., "gpt-4o-mini" for text (default)
+	VisionModel  string            // e.g., "gpt-4o" for vision (default)
 	SystemPrompt string
-}
 
-// StreamCallback is called for each chunk of the streaming response
-type StreamCallback func(chunk string, done bool)
+	// History budget; zero means unlimited
+	MaxHistoryMessages int
+	MaxHistoryTokens   int
+	SummarizeHistory   bool // Summarize trimmed turns instead of forgetting them
+}
 
 // NewClient creates a new OpenAI client
 func NewClient(config Config) *Client {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
 	if config.Model == "" {
 		config.Model = "gpt-4o-mini" // Cost-optimized for text
 	}
@@ -73,136 +114,107 @@ func NewClient(config Config) *Client {
 
 	return &Client{
 		apiKey:       config.APIKey,
+		baseURL:      strings.TrimSuffix(config.BaseURL, "/"),
+		headers:      config.Headers,
 		model:        config.Model,
 		visionModel:  config.VisionModel,
 		systemPrompt: config.SystemPrompt,
 		httpClient:   &http.Client{},
+		history:      NewHistory(config.MaxHistoryMessages, config.MaxHistoryTokens),
+		summarize:    config.SummarizeHistory,
 	}
 }
 
+// maxToolRounds limits how many consecutive tool-call rounds a single turn may take
+const maxToolRounds = 5
+
 // chatRequest is the request body for chat completions
 type chatRequest struct {
 	Model    string        `json:"model"`
 	Messages []interface{} `json:"messages"`
 	Stream   bool          `json:"stream"`
+	Tools    []toolSpec    `json:"tools,omitempty"`
+}
+
+// toolSpec describes a callable function in a chat request
+type toolSpec struct {
+	Type     string       `json:"type"`
+	Function functionSpec `json:"function"`
+}
+
+type functionSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
 }
 
 // streamResponse represents a streaming response chunk
 type streamResponse struct {
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason"`
 	} `json:"choices"`
 }
 
+// SetTools sets the tools the model may call during a response
+func (c *Client) SetTools(tools []llm.Tool) {
+	c.toolsMu.Lock()
+	defer c.toolsMu.Unlock()
+	c.tools = make(map[string]llm.Tool, len(tools))
+	for _, tool := range tools {
+		c.tools[tool.Name] = tool
+	}
+}
+
 // ChatStream sends a message and streams the response
-func (c *Client) ChatStream(userMessage string, callback StreamCallback) error {
+func (c *Client) ChatStream(userMessage string, callback llm.StreamCallback) error {
 	return c.ChatStreamWithContext(context.Background(), userMessage, callback)
 }
 
 // ChatStreamWithContext sends a message and streams the response with context support
 // Maintains conversation history for multi-turn conversations
-func (c *Client) ChatStreamWithContext(ctx context.Context, userMessage string, callback StreamCallback) error {
+func (c *Client) ChatStreamWithContext(ctx context.Context, userMessage string, callback llm.StreamCallback) error {
+	return c.ChatStreamAs(ctx, "", userMessage, callback)
+}
+
+// ChatStreamAs sends a message on behalf of a named speaker and streams the response
+// The speaker is recorded in the conversation history so the model knows who said what
+func (c *Client) ChatStreamAs(ctx context.Context, speaker, userMessage string, callback llm.StreamCallback) error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+
 	// Add user message to history
-	c.messages = append(c.messages, Message{Role: "user", Content: userMessage})
+	c.history.Append(Message{Role: "user", Content: userMessage, Name: speakerName(speaker)})
 
 	// Build messages array with system prompt + conversation history
-	messages := make([]interface{}, 0, len(c.messages)+1)
-	messages = append(messages, Message{Role: "system", Content: c.systemPrompt})
-	for _, msg := range c.messages {
+	history := c.history.Messages()
+	messages := c.contextMessages(len(history) + 1)
+	for _, msg := range history {
 		messages = append(messages, msg)
 	}
 
-	reqBody := chatRequest{
-		Model:    c.model,
-		Messages: messages,
-		Stream:   true,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(jsonBody))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		if ctx.Err() != nil {
-			return ctx.Err()
-		}
-		return fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Read SSE stream
-	reader := bufio.NewReader(resp.Body)
-	var fullResponse strings.Builder
-
-	for {
-		// Check for cancellation
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("read error: %w", err)
-		}
-
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			callback(fullResponse.String(), true)
-			break
-		}
-
-		var streamResp streamResponse
-		if err := json.Unmarshal([]byte(data), &streamResp); err != nil {
-			continue
-		}
-
-		if len(streamResp.Choices) > 0 {
-			content := streamResp.Choices[0].Delta.Content
-			if content != "" {
-				fullResponse.WriteString(content)
-				callback(content, false)
-			}
-		}
-	}
+	return c.streamCompletion(ctx, c.model, messages, callback)
+}
 
-	// Add assistant response to history
-	if fullResponse.Len() > 0 {
-		c.messages = append(c.messages, Message{Role: "assistant", Content: fullResponse.String()})
+// contextMessages starts a request with the system prompt and the summary of trimmed turns
+func (c *Client) contextMessages(capacity int) []interface{} {
+	messages := make([]interface{}, 0, capacity+2)
+	messages = append(messages, Message{Role: "system", Content: c.systemPrompt})
+	if summary := c.history.Summary(); summary != "" {
+		messages = append(messages, Message{Role: "system", Content: "Summary of the earlier conversation: " + summary})
 	}
-
-	return nil
+	return messages
 }
 
 // Chat sends a message and returns the complete response (non-streaming)
@@ -223,28 +235,63 @@ func (c *Client) Chat(userMessage string) (string, error) {
 	return response.String(), nil
 }
 
+// interruptedMarker tells the model that the user cut its previous response short
+const interruptedMarker = "[interrupted by the user]"
+
+// MarkInterrupted rewrites the latest assistant turn to the spoken prefix and marks it as interrupted
+// Without this the model would believe the user heard the whole response
+func (c *Client) MarkInterrupted(spoken string) {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+
+	content := interruptedMarker
+	if spoken = strings.TrimSpace(spoken); spoken != "" {
+		content = spoken + "... " + interruptedMarker
+	}
+	c.history.ReplaceLastResponse(content)
+}
+
+// DiscardLastTurn removes the latest user turn and any response to it
+func (c *Client) DiscardLastTurn() {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+	c.history.DropLastTurn()
+}
+
 // ClearHistory clears the conversation history
 func (c *Client) ClearHistory() {
-	c.messages = nil
+	c.history.Clear()
+
+	c.summaryMu.Lock()
+	c.pendingSummary = nil
+	c.summaryMu.Unlock()
 }
 
 // GetMessages returns a copy of the conversation history
 func (c *Client) GetMessages() []Message {
-	result := make([]Message, len(c.messages))
-	copy(result, c.messages)
-	return result
+	return c.history.Messages()
 }
 
 // MessageCount returns the number of messages in history
 func (c *Client) MessageCount() int {
-	return len(c.messages)
+	return c.history.Len()
 }
 
 // ChatStreamWithImage sends a message with an image and streams the response
 // Maintains conversation history for multi-turn conversations
-func (c *Client) ChatStreamWithImage(ctx context.Context, userMessage, imageBase64 string, callback StreamCallback) error {
+func (c *Client) ChatStreamWithImage(ctx context.Context, userMessage, imageBase64 string, callback llm.StreamCallback) error {
+	return c.ChatStreamWithImageAs(ctx, "", userMessage, imageBase64, callback)
+}
+
+// ChatStreamWithImageAs sends a message with an image on behalf of a named speaker
+func (c *Client) ChatStreamWithImageAs(ctx context.Context, speaker, userMessage, imageBase64 string, callback llm.StreamCallback) error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+
+	name := speakerName(speaker)
+
 	// Add user message to history (text only, we don't store images in history)
-	c.messages = append(c.messages, Message{Role: "user", Content: userMessage + " [with screenshot]"})
+	c.history.Append(Message{Role: "user", Content: userMessage + " [with screenshot]", Name: name})
 
 	// Build vision message with both text and image
 	userContent := []ContentPart{
@@ -259,58 +306,148 @@ func (c *Client) ChatStreamWithImage(ctx context.Context, userMessage, imageBase
 	}
 
 	// Build messages array with system prompt + history + current vision message
-	messages := make([]interface{}, 0, len(c.messages)+1)
-	messages = append(messages, Message{Role: "system", Content: c.systemPrompt})
+	history := c.history.Messages()
+	messages := c.contextMessages(len(history) + 1)
 	// Add previous messages (excluding the one we just added)
-	for i := 0; i < len(c.messages)-1; i++ {
-		messages = append(messages, c.messages[i])
+	for i := 0; i < len(history)-1; i++ {
+		messages = append(messages, history[i])
 	}
 	// Add current vision message with image
-	messages = append(messages, VisionMessage{Role: "user", Content: userContent})
+	messages = append(messages, VisionMessage{Role: "user", Content: userContent, Name: name})
 
 	// Use vision model for image requests (gpt-4o by default)
+	return c.streamCompletion(ctx, c.visionModel, messages, callback)
+}
+
+// streamCompletion runs a streaming chat completion, executing any tool calls the model makes
+// and continuing the completion with their results. The assistant response (and tool turns)
+// are appended to the conversation history as they complete
+func (c *Client) streamCompletion(ctx context.Context, model string, messages []interface{}, callback llm.StreamCallback) error {
+	var fullResponse strings.Builder
+
+	for round := 0; ; round++ {
+		// Stop offering tools once the round limit is reached so the model has to answer
+		offerTools := round < maxToolRounds
+
+		content, toolCalls, err := c.streamRound(ctx, model, messages, offerTools, func(chunk string) {
+			fullResponse.WriteString(chunk)
+			callback(chunk, false)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(toolCalls) == 0 {
+			// Add assistant response to history
+			if content != "" {
+				c.history.Append(Message{Role: "assistant", Content: content})
+			}
+			c.enforceBudget()
+			callback(fullResponse.String(), true)
+			return nil
+		}
+
+		// Record the tool request, run the handlers and continue with their results
+		historyLen := c.history.Len()
+		assistant := Message{Role: "assistant", Content: content, ToolCalls: toolCalls}
+		c.history.Append(assistant)
+		messages = append(messages, assistant)
+
+		for _, call := range toolCalls {
+			result := c.runTool(ctx, call)
+			if ctx.Err() != nil {
+				// A tool request without all of its results is invalid history
+				c.history.TruncateTo(historyLen)
+				return ctx.Err()
+			}
+			toolMsg := Message{Role: "tool", Content: result, ToolCallID: call.ID}
+			c.history.Append(toolMsg)
+			messages = append(messages, toolMsg)
+		}
+	}
+}
+
+// runTool executes a tool call and returns the result to send back to the model
+func (c *Client) runTool(ctx context.Context, call ToolCall) string {
+	c.toolsMu.RLock()
+	tool, ok := c.tools[call.Function.Name]
+	c.toolsMu.RUnlock()
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %q", call.Function.Name)
+	}
+
+	result, err := tool.Handler(ctx, json.RawMessage(call.Function.Arguments))
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+// streamRound posts one streaming chat completion request and relays the SSE content deltas
+// Tool-call deltas are accumulated and returned once the stream ends
+func (c *Client) streamRound(ctx context.Context, model string, messages []interface{}, offerTools bool, onContent func(chunk string)) (string, []ToolCall, error) {
 	reqBody := chatRequest{
-		Model:    c.visionModel,
+		Model:    model,
 		Messages: messages,
 		Stream:   true,
 	}
+	if offerTools {
+		c.toolsMu.RLock()
+		for _, tool := range c.tools {
+			reqBody.Tools = append(reqBody.Tools, toolSpec{
+				Type: "function",
+				Function: functionSpec{
+					Name:        tool.Name,
+					Description: tool.Description,
+					Parameters:  tool.Parameters,
+				},
+			})
+		}
+		c.toolsMu.RUnlock()
+	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return "", nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewReader(jsonBody))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return "", nil, ctx.Err()
 		}
-		return fmt.Errorf("request failed: %w", err)
+		return "", nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return "", nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Read SSE stream
 	reader := bufio.NewReader(resp.Body)
-	var fullResponse strings.Builder
+	var content strings.Builder
+	var toolCalls []ToolCall // Indexed by the delta's tool call index
 
 	for {
 		// Check for cancellation
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return "", nil, ctx.Err()
 		default:
 		}
 
@@ -319,7 +456,7 @@ func (c *Client) ChatStreamWithImage(ctx context.Context, userMessage, imageBase
 			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("read error: %w", err)
+			return "", nil, fmt.Errorf("read error: %w", err)
 		}
 
 		line = strings.TrimSpace(line)
@@ -333,7 +470,6 @@ func (c *Client) ChatStreamWithImage(ctx context.Context, userMessage, imageBase
 
 		data := strings.TrimPrefix(line, "data: ")
 		if data == "[DONE]" {
-			callback(fullResponse.String(), true)
 			break
 		}
 
@@ -342,19 +478,146 @@ func (c *Client) ChatStreamWithImage(ctx context.Context, userMessage, imageBase
 			continue
 		}
 
-		if len(streamResp.Choices) > 0 {
-			content := streamResp.Choices[0].Delta.Content
-			if content != "" {
-				fullResponse.WriteString(content)
-				callback(content, false)
+		if len(streamResp.Choices) == 0 {
+			continue
+		}
+		delta := streamResp.Choices[0].Delta
+
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onContent(delta.Content)
+		}
+
+		// Tool calls arrive in fragments: the first carries id and name, later ones append arguments
+		for _, tc := range delta.ToolCalls {
+			for len(toolCalls) <= tc.Index {
+				toolCalls = append(toolCalls, ToolCall{Type: "function"})
+			}
+			call := &toolCalls[tc.Index]
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Function.Name != "" {
+				call.Function.Name += tc.Function.Name
+			}
+			call.Function.Arguments += tc.Function.Arguments
+		}
+	}
+
+	return content.String(), toolCalls, nil
+}
+
+// enforceBudget trims the history to its budget and queues trimmed turns for summarization
+func (c *Client) enforceBudget() {
+	dropped := c.history.Trim()
+	if len(dropped) == 0 || !c.summarize {
+		return
+	}
+
+	c.summaryMu.Lock()
+	defer c.summaryMu.Unlock()
+	c.pendingSummary = append(c.pendingSummary, dropped...)
+	if !c.summarizing {
+		c.summarizing = true
+		go c.summarizeLoop()
+	}
+}
+
+// summarizeLoop folds trimmed turns into the running summary until none are pending
+// It runs in the background so summarization never delays a response. Turns that
+// fail to summarize stay pending for the next trim, and a summary of turns from
+// before ClearHistory is discarded.
+func (c *Client) summarizeLoop() {
+	for {
+		c.summaryMu.Lock()
+		dropped := c.pendingSummary
+		c.pendingSummary = nil
+		if len(dropped) == 0 {
+			c.summarizing = false
+			c.summaryMu.Unlock()
+			return
+		}
+		generation := c.history.Generation()
+		c.summaryMu.Unlock()
+
+		summary, err := c.summarizeTurns(c.history.Summary(), dropped)
+		if err != nil {
+			log.Printf("[OpenAI] History summarization failed, retrying on the next trim: %v", err)
+			c.summaryMu.Lock()
+			if c.history.Generation() == generation {
+				c.pendingSummary = append(dropped, c.pendingSummary...)
 			}
+			c.summarizing = false
+			c.summaryMu.Unlock()
+			return
+		}
+		if !c.history.SetSummaryAt(generation, summary) {
+			log.Printf("[OpenAI] History was cleared during summarization, discarding the summary")
 		}
 	}
+}
+
+// summarizeTurns asks the model to merge trimmed turns into an existing summary
+func (c *Client) summarizeTurns(previous string, turns []Message) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range turns {
+		if msg.Content == "" {
+			continue
+		}
+		speaker := msg.Role
+		if msg.Name != "" {
+			speaker += " (" + msg.Name + ")"
+		}
+		transcript.WriteString(speaker + ": " + msg.Content + "\n")
+	}
+
+	prompt := "Summarize this voice conversation in a few sentences. Keep names, facts, decisions and open questions; drop small talk."
+	if previous != "" {
+		prompt += "\n\nSummary so far: " + previous
+	}
+	prompt += "\n\nConversation:\n" + transcript.String()
 
-	// Add assistant response to history
-	if fullResponse.Len() > 0 {
-		c.messages = append(c.messages, Message{Role: "assistant", Content: fullResponse.String()})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	summary, _, err := c.streamRound(ctx, c.model, []interface{}{Message{Role: "user", Content: prompt}}, false, func(string) {})
+	return strings.TrimSpace(summary), err
+}
+
+// DescribeImage asks the vision model a one-off question about an image
+// The exchange is not added to the conversation history
+func (c *Client) DescribeImage(ctx context.Context, prompt, imageBase64 string) (string, error) {
+	messages := []interface{}{
+		VisionMessage{Role: "user", Content: []ContentPart{
+			{Type: "text", Text: prompt},
+			{
+				Type: "image_url",
+				ImageURL: &ImageURL{
+					URL:    "data:image/jpeg;base64," + imageBase64,
+					Detail: "low",
+				},
+			},
+		}},
 	}
 
-	return nil
+	description, _, err := c.streamRound(ctx, c.visionModel, messages, false, func(string) {})
+	return description, err
+}
+
+// speakerName converts a peer ID into a valid message name
+// OpenAI only accepts letters, digits, underscores and hyphens (max 64 chars)
+func speakerName(speaker string) string {
+	var b strings.Builder
+	for _, r := range speaker {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+		if b.Len() >= 64 {
+			break
+		}
+	}
+	return b.String()
 }
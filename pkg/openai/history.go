@@ -0,0 +1,175 @@
+package openai
+
+import (
+	"sync"
+)
+
+// History is a conversation store with an optional message and token budget
+// It is safe for concurrent use
+type History struct {
+	messages    []Message
+	summary     string // Summary of turns that were trimmed from the history
+	generation  uint64 // Incremented by Clear
+	maxMessages int    // 0 = unlimited
+	maxTokens   int    // 0 = unlimited
+	mu          sync.Mutex
+}
+
+// NewHistory creates a conversation store; zero limits mean unlimited
+func NewHistory(maxMessages, maxTokens int) *History {
+	return &History{
+		maxMessages: maxMessages,
+		maxTokens:   maxTokens,
+	}
+}
+
+// Append adds messages to the end of the history
+func (h *History) Append(msgs ...Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, msgs...)
+}
+
+// Messages returns a copy of the history
+func (h *History) Messages() []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := make([]Message, len(h.messages))
+	copy(result, h.messages)
+	return result
+}
+
+// Len returns the number of messages in the history
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.messages)
+}
+
+// TruncateTo drops every message after the first n
+func (h *History) TruncateTo(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n < len(h.messages) {
+		h.messages = h.messages[:n]
+	}
+}
+
+// ReplaceLastResponse sets the content of the trailing assistant response
+// If the history does not end with one (the response was cut off), a new one is appended
+func (h *History) ReplaceLastResponse(content string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := len(h.messages)
+	if n > 0 && h.messages[n-1].Role == "assistant" && len(h.messages[n-1].ToolCalls) == 0 {
+		h.messages[n-1].Content = content
+		return
+	}
+	h.messages = append(h.messages, Message{Role: "assistant", Content: content})
+}
+
+// DropLastTurn removes the last user message and everything after it
+func (h *History) DropLastTurn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		if h.messages[i].Role == "user" {
+			h.messages = h.messages[:i]
+			return
+		}
+	}
+}
+
+// Clear removes all messages and the summary
+func (h *History) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = nil
+	h.summary = ""
+	h.generation++
+}
+
+// Generation identifies the conversation since the last Clear
+func (h *History) Generation() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.generation
+}
+
+// Summary returns the summary of trimmed turns
+func (h *History) Summary() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.summary
+}
+
+// SetSummary replaces the summary of trimmed turns
+func (h *History) SetSummary(summary string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.summary = summary
+}
+
+// SetSummaryAt replaces the summary unless the history was cleared since generation
+// It reports whether the summary was set
+func (h *History) SetSummaryAt(generation uint64, summary string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.generation != generation {
+		return false
+	}
+	h.summary = summary
+	return true
+}
+
+// Trim drops the oldest turns until the history fits its budget and returns them
+// Whole turns are dropped (a user message up to the next one) so tool calls are
+// never separated from their results, and the latest turn is always kept
+func (h *History) Trim() []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var dropped []Message
+	for h.overBudget() {
+		// Find the start of the second turn; everything before it is the oldest turn
+		next := -1
+		for i := 1; i < len(h.messages); i++ {
+			if h.messages[i].Role == "user" {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		dropped = append(dropped, h.messages[:next]...)
+		h.messages = append([]Message(nil), h.messages[next:]...)
+	}
+	return dropped
+}
+
+// overBudget reports whether the history exceeds its limits; caller must hold mu
+func (h *History) overBudget() bool {
+	if h.maxMessages > 0 && len(h.messages) > h.maxMessages {
+		return true
+	}
+	if h.maxTokens > 0 && EstimateTokens(h.messages) > h.maxTokens {
+		return true
+	}
+	return false
+}
+
+// EstimateTokens approximates the token count of messages (about 4 characters per token)
+func EstimateTokens(msgs []Message) int {
+	tokens := 0
+	for _, msg := range msgs {
+		chars := len(msg.Content)
+		for _, call := range msg.ToolCalls {
+			chars += len(call.Function.Name) + len(call.Function.Arguments)
+		}
+		tokens += chars/4 + 4 // per-message overhead for role and separators
+	}
+	return tokens
+}
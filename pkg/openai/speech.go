@@ -0,0 +1,146 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"example.com/agent_bridge/pkg/tts"
+)
+
+// SpeechClient is a TTS client for OpenAI-compatible /audio/speech endpoints
+type SpeechClient struct {
+	apiKey     string
+	baseURL    string
+	model      string
+	voice      string
+	headers    map[string]string
+	speed      float64
+	sampleRate int
+	httpClient *http.Client
+}
+
+// SpeechConfig holds SpeechClient configuration
+type SpeechConfig struct {
+	APIKey     string            // Optional for local servers
+	BaseURL    string            // e.g., "https://api.openai.com/v1" (default) or "http://localhost:8880/v1"
+	Headers    map[string]string // Extra HTTP headers sent with every request
+	Model      string            // e.g., "gpt-4o-mini-tts" (default) or "tts-1"
+	Voice      string            // e.g., "alloy" (default)
+	Speed      float64           // 0.25 - 4.0 (default 1.0)
+	SampleRate int               // Sample rate of the server's "pcm" output (default 24000)
+}
+
+// NewSpeechClient creates a new OpenAI-compatible TTS client
+func NewSpeechClient(config SpeechConfig) *SpeechClient {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	if config.Model == "" {
+		config.Model = "gpt-4o-mini-tts"
+	}
+	if config.Voice == "" {
+		config.Voice = "alloy"
+	}
+	if config.Speed == 0 {
+		config.Speed = 1.0
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = 24000 // OpenAI "pcm" is 24kHz 16-bit signed little-endian mono
+	}
+
+	return &SpeechClient{
+		apiKey:     config.APIKey,
+		baseURL:    strings.TrimSuffix(config.BaseURL, "/"),
+		model:      config.Model,
+		voice:      config.Voice,
+		headers:    config.Headers,
+		speed:      config.Speed,
+		sampleRate: config.SampleRate,
+		httpClient: &http.Client{},
+	}
+}
+
+// speechRequest is the request body for /audio/speech
+type speechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed"`
+}
+
+// Format returns the PCM format produced by the client
+func (c *SpeechClient) Format() tts.Format {
+	return tts.Format{SampleRate: c.sampleRate, Channels: 1}
+}
+
+// SynthesizeStream converts text to speech and streams PCM audio chunks
+func (c *SpeechClient) SynthesizeStream(ctx context.Context, text string, callback tts.AudioCallback) error {
+	reqBody := speechRequest{
+		Model:          c.model,
+		Input:          text,
+		Voice:          c.voice,
+		ResponseFormat: "pcm",
+		Speed:          c.speed,
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/audio/speech", bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Stream PCM chunks
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			// Make a copy of the data for the callback
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			callback(chunk)
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("read error: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package relay frames application data for the DataChannel each peer shares with the SFU
+//
+// A message is split into frames of at most ChunkSize payload bytes, since peers
+// cannot be assumed to read larger DataChannel messages. Every frame carries a
+// small JSON header naming the message, so frames of messages from different
+// senders may interleave.
+package relay
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	// Label and ChannelID identify the channel; both sides create it pre-negotiated
+	Label     = "relay"
+	ChannelID = 0
+
+	// ChunkSize is the most payload bytes per frame
+	ChunkSize = 16 << 10
+
+	// maxPending bounds the messages an Assembler holds partially
+	maxPending = 64
+)
+
+var (
+	ErrMalformed = errors.New("relay: malformed frame")
+	ErrTooLarge  = errors.New("relay: message too large")
+	ErrTooMany   = errors.New("relay: too many messages in flight")
+)
+
+// Header describes the message a frame belongs to
+type Header struct {
+	ID       uint32 `json:"id"`                  // Chosen by the sender, unique among its messages in flight
+	Topic    string `json:"topic,omitempty"`     // Application-defined
+	TargetID string `json:"target_id,omitempty"` // Recipient peer; empty for every other peer
+	From     string `json:"from,omitempty"`      // Sender, set by the server
+	JSON     bool   `json:"json,omitempty"`      // The payload is JSON rather than binary
+	Size     int    `json:"size"`                // Payload bytes in the whole message
+	Offset   int    `json:"offset"`              // Position of this frame's payload
+}
+
+// Message is a reassembled message
+type Message struct {
+	Header
+	Payload []byte
+}
+
+// Frames splits a message into frames ready to send
+func Frames(h Header, payload []byte) ([][]byte, error) {
+	h.Size = len(payload)
+	var frames [][]byte
+	for offset := 0; offset == 0 || offset < len(payload); offset += ChunkSize {
+		h.Offset = offset
+		head, err := json.Marshal(h)
+		if err != nil {
+			return nil, err
+		}
+		chunk := payload[offset:min(offset+ChunkSize, len(payload))]
+
+		frame := make([]byte, 2, 2+len(head)+len(chunk))
+		binary.BigEndian.PutUint16(frame, uint16(len(head)))
+		frame = append(frame, head...)
+		frames = append(frames, append(frame, chunk...))
+	}
+	return frames, nil
+}
+
+// Parse splits a frame into its header and payload chunk
+func Parse(frame []byte) (Header, []byte, error) {
+	var h Header
+	if len(frame) < 2 {
+		return h, nil, ErrMalformed
+	}
+	n := int(binary.BigEndian.Uint16(frame))
+	if len(frame) < 2+n {
+		return h, nil, ErrMalformed
+	}
+	if err := json.Unmarshal(frame[2:2+n], &h); err != nil {
+		return h, nil, ErrMalformed
+	}
+	chunk := frame[2+n:]
+	if h.Size < 0 || h.Offset < 0 || len(chunk) > ChunkSize || h.Offset+len(chunk) > h.Size {
+		return h, nil, ErrMalformed
+	}
+	return h, chunk, nil
+}
+
+// Assembler reassembles messages from frames
+// Frames of one message must arrive in order, as on a reliable ordered channel.
+// Assembler is not safe for concurrent use.
+type Assembler struct {
+	maxSize int
+	pending map[key]*Message
+}
+
+type key struct {
+	from string
+	id   uint32
+}
+
+// NewAssembler returns an Assembler refusing messages over maxSize bytes; 0 for no limit
+func NewAssembler(maxSize int) *Assembler {
+	return &Assembler{maxSize: maxSize, pending: make(map[key]*Message)}
+}
+
+// Add adds a frame and returns the message once it is complete, or nil
+// A message over the limit, or beyond maxPending partial ones, is refused with
+// ErrTooLarge or ErrTooMany and its header on its first frame; its remaining frames
+// are dropped without error
+func (a *Assembler) Add(frame []byte) (*Message, error) {
+	h, chunk, err := Parse(frame)
+	if err != nil {
+		return nil, err
+	}
+	k := key{h.From, h.ID}
+	msg := a.pending[k]
+
+	if msg == nil {
+		if h.Offset != 0 {
+			return nil, nil // The rest of a refused message
+		}
+		if a.maxSize > 0 && h.Size > a.maxSize {
+			return &Message{Header: h}, ErrTooLarge
+		}
+		if len(a.pending) >= maxPending {
+			return &Message{Header: h}, ErrTooMany
+		}
+		msg = &Message{Header: h, Payload: make([]byte, 0, min(h.Size, ChunkSize))}
+	} else if h.Offset != len(msg.Payload) || h.Size != msg.Size {
+		delete(a.pending, k)
+		return nil, ErrMalformed
+	}
+
+	msg.Payload = append(msg.Payload, chunk...)
+	if len(msg.Payload) < msg.Size {
+		a.pending[k] = msg
+		return nil, nil
+	}
+	delete(a.pending, k)
+	return msg, nil
+}
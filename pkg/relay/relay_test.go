@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFramesReassemble(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 5000) // Several frames
+	a := NewAssembler(0)
+
+	bigFrames, err := Frames(Header{ID: 1, From: "alice", Topic: "file"}, big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bigFrames) != (len(big)+ChunkSize-1)/ChunkSize {
+		t.Errorf("%d frames for %d bytes", len(bigFrames), len(big))
+	}
+	small, _ := Frames(Header{ID: 1, From: "bob", JSON: true}, []byte(`{"ok":true}`))
+	empty, _ := Frames(Header{ID: 2, From: "alice", Topic: "ping"}, nil)
+
+	// Another sender's message and an empty one in between are complete on their own
+	var got []*Message
+	for i, frame := range append(append(append(bigFrames[:1:1], small...), empty...), bigFrames[1:]...) {
+		msg, err := a.Add(frame)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if msg != nil {
+			got = append(got, msg)
+		}
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d messages, want 3", len(got))
+	}
+	if got[0].From != "bob" || !got[0].JSON || string(got[0].Payload) != `{"ok":true}` {
+		t.Errorf("unexpected small message %+v", got[0])
+	}
+	if got[1].Topic != "ping" || len(got[1].Payload) != 0 {
+		t.Errorf("unexpected empty message %+v", got[1])
+	}
+	if got[2].Topic != "file" || !bytes.Equal(got[2].Payload, big) {
+		t.Errorf("big message corrupted: %d bytes", len(got[2].Payload))
+	}
+}
+
+func TestAssemblerRefusesOversizedMessages(t *testing.T) {
+	a := NewAssembler(ChunkSize)
+	frames, _ := Frames(Header{ID: 7, Topic: "file"}, make([]byte, 3*ChunkSize))
+
+	msg, err := a.Add(frames[0])
+	if !errors.Is(err, ErrTooLarge) || msg == nil || msg.Topic != "file" {
+		t.Fatalf("first frame: %v, %+v; want ErrTooLarge with the header", err, msg)
+	}
+	for _, frame := range frames[1:] {
+		if msg, err := a.Add(frame); msg != nil || err != nil {
+			t.Errorf("rest of the refused message: %v, %v", msg, err)
+		}
+	}
+
+	if _, err := a.Add([]byte{0, 200, '{'}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("truncated frame: %v, want ErrMalformed", err)
+	}
+}
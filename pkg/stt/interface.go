@@ -27,6 +27,13 @@ type Client interface {
 	IsConnected() bool
 }
 
+// KeepAliver is implemented by providers that can hold a session open while no
+// audio is sent, e.g. when silence is filtered out by local voice activity detection
+type KeepAliver interface {
+	// KeepAlive tells the service the stream is still active
+	KeepAlive() error
+}
+
 // Config holds common STT connection settings
 type Config struct {
 	APIKey         string
@@ -0,0 +1,128 @@
+package trickle
+
+import (
+	"log"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// CandidateInit returns the complete signaling form of a local candidate
+// Pion's ToJSON leaves sdpMid empty and omits the username fragment; both are
+// taken from the local description, where all candidates share the BUNDLE transport
+func CandidateInit(pc *webrtc.PeerConnection, candidate *webrtc.ICECandidate) webrtc.ICECandidateInit {
+	init := candidate.ToJSON()
+
+	mid, ufrag := descriptionTransport(pc.LocalDescription())
+	if mid != "" {
+		index := uint16(0)
+		init.SDPMid = &mid
+		init.SDPMLineIndex = &index
+	}
+	if ufrag != "" {
+		init.UsernameFragment = &ufrag
+	}
+	return init
+}
+
+// descriptionTransport returns the first media section's mid and the ICE username fragment
+func descriptionTransport(desc *webrtc.SessionDescription) (mid, ufrag string) {
+	if desc == nil {
+		return "", ""
+	}
+	// Parse a copy: Unmarshal caches its result on the description, which Pion shares
+	copied := webrtc.SessionDescription{Type: desc.Type, SDP: desc.SDP}
+	parsed, err := copied.Unmarshal()
+	if err != nil {
+		return "", ""
+	}
+
+	ufrag, _ = parsed.Attribute("ice-ufrag")
+	if len(parsed.MediaDescriptions) > 0 {
+		media := parsed.MediaDescriptions[0]
+		mid, _ = media.Attribute("mid")
+		if ufrag == "" {
+			ufrag, _ = media.Attribute("ice-ufrag")
+		}
+	}
+	return mid, ufrag
+}
+
+// maxPending caps buffered candidates; a description that never arrives, or a peer
+// sending garbage, must not grow the buffer without bound
+const maxPending = 64
+
+// Buffer holds remote candidates that arrive before the description they belong to
+// Not safe for concurrent use; callers serialize it with their signaling
+type Buffer struct {
+	pending []webrtc.ICECandidateInit
+}
+
+// Add applies a remote candidate, or buffers it until a matching remote description is set
+// An empty candidate string signals end-of-candidates
+func (b *Buffer) Add(pc *webrtc.PeerConnection, candidate webrtc.ICECandidateInit) error {
+	switch b.match(pc, candidate) {
+	case candidateApply:
+		return pc.AddICECandidate(candidate)
+	case candidateWait:
+		if len(b.pending) == maxPending {
+			log.Printf("[trickle] Too many buffered candidates - dropping the oldest")
+			b.pending = b.pending[1:]
+		}
+		b.pending = append(b.pending, candidate)
+	case candidateStale:
+		log.Printf("[trickle] Dropping candidate from a previous ICE generation")
+	}
+	return nil
+}
+
+// Flush applies buffered candidates that match the remote description just set
+func (b *Buffer) Flush(pc *webrtc.PeerConnection) {
+	pending := b.pending
+	b.pending = nil
+	for _, candidate := range pending {
+		if err := b.Add(pc, candidate); err != nil {
+			log.Printf("[trickle] Failed to add buffered candidate: %v", err)
+		}
+	}
+}
+
+// Len returns the number of buffered candidates
+func (b *Buffer) Len() int {
+	return len(b.pending)
+}
+
+// Clear drops buffered candidates, e.g. when the connection is replaced
+func (b *Buffer) Clear() {
+	b.pending = nil
+}
+
+type candidateAction int
+
+const (
+	candidateApply candidateAction = iota
+	candidateWait
+	candidateStale
+)
+
+// match decides what to do with a candidate given the current remote description
+func (b *Buffer) match(pc *webrtc.PeerConnection, candidate webrtc.ICECandidateInit) candidateAction {
+	remote := pc.RemoteDescription()
+	if remote == nil {
+		return candidateWait
+	}
+	if candidate.UsernameFragment == nil || *candidate.UsernameFragment == "" {
+		return candidateApply
+	}
+
+	_, ufrag := descriptionTransport(remote)
+	if ufrag == "" || ufrag == *candidate.UsernameFragment {
+		return candidateApply
+	}
+
+	// A different generation: from an ICE restart whose description is still in flight,
+	// or from before a restart that already completed
+	if pc.SignalingState() != webrtc.SignalingStateStable {
+		return candidateWait
+	}
+	return candidateStale
+}
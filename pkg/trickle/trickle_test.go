@@ -0,0 +1,175 @@
+package trickle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func newPeerConnection(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// setLocal sets a local description and waits for gathering, which an ICE restart
+// may not interrupt
+func setLocal(t *testing.T, pc *webrtc.PeerConnection, desc webrtc.SessionDescription) {
+	t.Helper()
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(desc); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+}
+
+// offer creates and sets a local audio offer on pc
+func offer(t *testing.T, pc *webrtc.PeerConnection, options *webrtc.OfferOptions) webrtc.SessionDescription {
+	t.Helper()
+	desc, err := pc.CreateOffer(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setLocal(t, pc, desc)
+	return desc
+}
+
+// answer sets the offer on pc and creates and sets its local answer
+func answer(t *testing.T, pc *webrtc.PeerConnection, offer webrtc.SessionDescription) webrtc.SessionDescription {
+	t.Helper()
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := pc.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setLocal(t, pc, desc)
+	return desc
+}
+
+// candidate returns a host candidate of the given ICE generation
+func candidate(n int, ufrag string) webrtc.ICECandidateInit {
+	mid, index := "0", uint16(0)
+	return webrtc.ICECandidateInit{
+		Candidate:        fmt.Sprintf("candidate:%d 1 udp 2130706431 192.0.2.1 %d typ host", n, 50000+n),
+		SDPMid:           &mid,
+		SDPMLineIndex:    &index,
+		UsernameFragment: &ufrag,
+	}
+}
+
+func ufragOf(t *testing.T, desc webrtc.SessionDescription) string {
+	t.Helper()
+	_, ufrag := descriptionTransport(&desc)
+	if ufrag == "" {
+		t.Fatal("description has no ice-ufrag")
+	}
+	return ufrag
+}
+
+// connectedPair negotiates an audio session from offerer to answerer and returns
+// the answer, so the offerer's remote ufrag is known
+func connectedPair(t *testing.T) (offerer, answerer *webrtc.PeerConnection, first webrtc.SessionDescription) {
+	t.Helper()
+	offerer, answerer = newPeerConnection(t), newPeerConnection(t)
+	if _, err := offerer.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	first = answer(t, answerer, offer(t, offerer, nil))
+	if err := offerer.SetRemoteDescription(first); err != nil {
+		t.Fatal(err)
+	}
+	return offerer, answerer, first
+}
+
+func TestCandidateBeforeRemoteDescriptionIsBuffered(t *testing.T) {
+	pc := newPeerConnection(t)
+	var b Buffer
+	if err := b.Add(pc, candidate(1, "early")); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 1 {
+		t.Fatalf("buffered %d candidates, want 1", b.Len())
+	}
+
+	// Flushing without a remote description keeps it
+	b.Flush(pc)
+	if b.Len() != 1 {
+		t.Errorf("buffered %d candidates after an early flush, want 1", b.Len())
+	}
+}
+
+func TestCandidateWithMatchingUfragIsApplied(t *testing.T) {
+	offerer, _, first := connectedPair(t)
+	var b Buffer
+	c := candidate(1, ufragOf(t, first))
+	if got := b.match(offerer, c); got != candidateApply {
+		t.Fatalf("match = %d, want candidateApply", got)
+	}
+	if err := b.Add(offerer, c); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("buffered %d candidates, want none", b.Len())
+	}
+}
+
+func TestICERestartCandidates(t *testing.T) {
+	offerer, answerer, first := connectedPair(t)
+	var b Buffer
+
+	// The restart offer is out; the answerer's new candidates may beat its answer
+	restart := offer(t, offerer, &webrtc.OfferOptions{ICERestart: true})
+	second := answer(t, answerer, restart)
+	if ufragOf(t, second) == ufragOf(t, first) {
+		t.Fatal("ICE restart kept the ufrag")
+	}
+	early := candidate(2, ufragOf(t, second))
+	if err := b.Add(offerer, early); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 1 {
+		t.Fatalf("new-generation candidate in have-local-offer: buffered %d, want 1", b.Len())
+	}
+
+	if err := offerer.SetRemoteDescription(second); err != nil {
+		t.Fatal(err)
+	}
+	b.Flush(offerer)
+	if b.Len() != 0 {
+		t.Errorf("buffered %d candidates after the answer, want none", b.Len())
+	}
+
+	// Stragglers from before the completed restart are dropped, not buffered
+	stale := candidate(1, ufragOf(t, first))
+	if got := b.match(offerer, stale); got != candidateStale {
+		t.Fatalf("old-generation candidate: match = %d, want candidateStale", got)
+	}
+	if err := b.Add(offerer, stale); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("buffered %d stale candidates, want none", b.Len())
+	}
+}
+
+func TestBufferIsCapped(t *testing.T) {
+	pc := newPeerConnection(t)
+	var b Buffer
+	for i := 0; i < maxPending+10; i++ {
+		b.Add(pc, candidate(i, "early"))
+	}
+	if b.Len() != maxPending {
+		t.Fatalf("buffered %d candidates, want %d", b.Len(), maxPending)
+	}
+	// The newest are kept
+	if b.pending[0].Candidate != candidate(10, "early").Candidate {
+		t.Errorf("oldest kept candidate is %q, want the 11th", b.pending[0].Candidate)
+	}
+}
@@ -0,0 +1,22 @@
+package tts
+
+import "context"
+
+// AudioCallback is called with PCM audio chunks (signed 16-bit little-endian)
+type AudioCallback func(pcmData []byte)
+
+// Format describes the PCM audio produced by a provider
+type Format struct {
+	SampleRate int // e.g., 22050
+	Channels   int // e.g., 1 for mono
+}
+
+// Client defines the interface for text-to-speech providers
+type Client interface {
+	// Format returns the PCM format of the chunks passed to the callback
+	Format() Format
+
+	// SynthesizeStream converts text to speech and streams PCM chunks as they arrive
+	// Cancelling the context aborts synthesis
+	SynthesizeStream(ctx context.Context, text string, callback AudioCallback) error
+}
@@ -0,0 +1,431 @@
+package turn
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is the conversational state of an agent
+type State int
+
+const (
+	// Listening waits for a complete user utterance
+	Listening State = iota
+	// Thinking waits for the response to produce its first audio
+	Thinking
+	// Speaking plays the response
+	Speaking
+)
+
+// String returns the state name
+func (s State) String() string {
+	switch s {
+	case Listening:
+		return "listening"
+	case Thinking:
+		return "thinking"
+	case Speaking:
+		return "speaking"
+	}
+	return "unknown"
+}
+
+// EventType identifies what happened
+type EventType int
+
+const (
+	// EventTranscript carries interim or final STT text from a peer
+	EventTranscript EventType = iota
+	// EventUtteranceEnd signals STT endpointing for a peer
+	EventUtteranceEnd
+	// EventSpeechStart signals that voice activity started for a peer
+	EventSpeechStart
+	// EventSpeechEnd signals that voice activity ended for a peer
+	EventSpeechEnd
+	// EventPlaybackStart signals that the first audio of a response was sent
+	EventPlaybackStart
+	// EventResponseDone signals that a response (LLM, TTS and playback) has fully exited
+	EventResponseDone
+	// EventPeerLeft drops any pending speech from a peer that left
+	EventPeerLeft
+
+	// eventReanswer fires ReanswerDelay after speech that cancelled a response ended
+	// without a transcript
+	eventReanswer
+)
+
+// DefaultReanswerDelay is the default Config.ReanswerDelay
+const DefaultReanswerDelay = time.Second
+
+// String returns the event type name
+func (t EventType) String() string {
+	switch t {
+	case EventTranscript:
+		return "transcript"
+	case EventUtteranceEnd:
+		return "utterance_end"
+	case EventSpeechStart:
+		return "speech_start"
+	case EventSpeechEnd:
+		return "speech_end"
+	case EventPlaybackStart:
+		return "playback_start"
+	case EventResponseDone:
+		return "response_done"
+	case EventPeerLeft:
+		return "peer_left"
+	case eventReanswer:
+		return "reanswer"
+	}
+	return "unknown"
+}
+
+// Event is an input to the state machine
+type Event struct {
+	Type       EventType
+	PeerID     string
+	Text       string // EventTranscript
+	Final      bool   // EventTranscript
+	ResponseID int    // EventPlaybackStart, EventResponseDone
+}
+
+// Utterance is a complete user turn to respond to
+type Utterance struct {
+	PeerID string
+	Text   string
+}
+
+// Response is a response started by the machine
+type Response struct {
+	ID        int
+	Utterance Utterance
+}
+
+// ResponseFunc produces a response; it must return once ctx is cancelled
+// It reports first audio with Machine.PlaybackStarted
+type ResponseFunc func(ctx context.Context, resp Response)
+
+// StateChangeCallback is called on every transition, with the event that caused it
+type StateChangeCallback func(from, to State, cause Event)
+
+// Config holds state machine settings
+type Config struct {
+	Respond       ResponseFunc
+	OnStateChange StateChangeCallback
+
+	// BargeInOnTranscript lets any non-empty transcript interrupt the agent.
+	// When false only EventSpeechStart (local VAD) interrupts.
+	BargeInOnTranscript bool
+
+	// ReanswerDelay is how long after speech that cancelled a response while Thinking
+	// has ended, without any transcript from the speaker (a cough, a noise), the
+	// cancelled utterance is answered again. Zero selects DefaultReanswerDelay.
+	ReanswerDelay time.Duration
+}
+
+// Machine is a single-goroutine, event-driven turn-taking state machine
+//
+// Listening: final transcripts accumulate per peer; an utterance end starts a response.
+// Thinking: user speech cancels the response and puts the utterance back, so it is
+// answered together with what the user adds, or again on its own if the speech
+// brings no transcript.
+// Speaking: user speech interrupts playback (barge-in) and starts a new utterance.
+// A new response never starts until the previous one has fully exited.
+type Machine struct {
+	config Config
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+
+	// Loop-owned state
+	state    State
+	pending  map[string]*strings.Builder // Final transcript text per peer
+	ready    []string                    // Peers whose utterance ended while a response was running
+	active   int                         // ID of the running response, 0 if none
+	cancel   context.CancelFunc
+	answered Utterance // Utterance the active response answers
+	nextID   int
+	reanswer reanswer // Utterance put back by speech that may have had no words
+	nextSeq  int
+
+	stateMu sync.RWMutex
+	current State
+}
+
+// reanswer is an utterance put back by speech while Thinking, answered again unless
+// the speaker turns out to have said something
+type reanswer struct {
+	speaker string // Peer whose speech cancelled the response
+	peerID  string // Peer whose utterance was put back; empty when none
+	timer   int    // Sequence number of the armed timer, 0 when none
+}
+
+// NewMachine creates a state machine; call Start to begin processing events
+func NewMachine(config Config) *Machine {
+	if config.ReanswerDelay <= 0 {
+		config.ReanswerDelay = DefaultReanswerDelay
+	}
+	return &Machine{
+		config:  config,
+		events:  make(chan Event, 256),
+		done:    make(chan struct{}),
+		pending: make(map[string]*strings.Builder),
+	}
+}
+
+// Start runs the event loop in the background
+func (m *Machine) Start() {
+	go m.run()
+}
+
+// Stop cancels any running response and stops the event loop
+func (m *Machine) Stop() {
+	m.once.Do(func() { close(m.done) })
+}
+
+// Dispatch queues an event for processing
+func (m *Machine) Dispatch(ev Event) {
+	select {
+	case m.events <- ev:
+	case <-m.done:
+	}
+}
+
+// PlaybackStarted reports that a response sent its first audio
+func (m *Machine) PlaybackStarted(responseID int) {
+	m.Dispatch(Event{Type: EventPlaybackStart, ResponseID: responseID})
+}
+
+// State returns the current state
+func (m *Machine) State() State {
+	m.stateMu.RLock()
+	defer m.stateMu.RUnlock()
+	return m.current
+}
+
+func (m *Machine) run() {
+	for {
+		select {
+		case <-m.done:
+			if m.cancel != nil {
+				m.cancel()
+			}
+			return
+		case ev := <-m.events:
+			m.handle(ev)
+		}
+	}
+}
+
+// handle applies one event; only called from the loop goroutine
+func (m *Machine) handle(ev Event) {
+	switch ev.Type {
+	case EventTranscript:
+		if strings.TrimSpace(ev.Text) == "" {
+			return
+		}
+		m.speakerHeard(ev.PeerID)
+		if m.config.BargeInOnTranscript {
+			m.userSpoke(ev)
+		}
+		if ev.Final {
+			m.appendPending(ev.PeerID, ev.Text)
+		}
+
+	case EventSpeechStart:
+		if ev.PeerID == m.reanswer.speaker {
+			m.reanswer.timer = 0 // Still speaking
+		}
+		m.userSpoke(ev)
+
+	case EventSpeechEnd:
+		// Endpointing is left to STT; VAD speech end alone does not close a turn,
+		// but speech with no words must not leave a cancelled utterance unanswered
+		if m.reanswer.peerID != "" && ev.PeerID == m.reanswer.speaker {
+			m.nextSeq++
+			seq := m.nextSeq
+			m.reanswer.timer = seq
+			time.AfterFunc(m.config.ReanswerDelay, func() {
+				m.Dispatch(Event{Type: eventReanswer, PeerID: ev.PeerID, ResponseID: seq})
+			})
+		}
+
+	case eventReanswer:
+		if m.reanswer.peerID == "" || ev.ResponseID != m.reanswer.timer {
+			return
+		}
+		log.Printf("[turn] %s said nothing - answering %s again", ev.PeerID, m.reanswer.peerID)
+		m.answerAgain(ev)
+
+	case EventUtteranceEnd:
+		if b, ok := m.pending[ev.PeerID]; !ok || b.Len() == 0 {
+			return
+		}
+		if m.active != 0 {
+			// Answer once the running response has exited
+			m.queueReady(ev.PeerID)
+			return
+		}
+		m.startResponse(ev.PeerID, ev)
+
+	case EventPlaybackStart:
+		if ev.ResponseID == m.active && m.state == Thinking {
+			m.setState(Speaking, ev)
+		}
+
+	case EventResponseDone:
+		if ev.ResponseID != m.active {
+			return
+		}
+		m.active = 0
+		m.cancel = nil
+		if m.state != Listening {
+			m.setState(Listening, ev)
+		}
+		// Utterances that completed meanwhile are answered in order
+		for len(m.ready) > 0 {
+			peerID := m.ready[0]
+			m.ready = m.ready[1:]
+			if b, ok := m.pending[peerID]; ok && b.Len() > 0 {
+				m.startResponse(peerID, ev)
+				return
+			}
+		}
+
+	case EventPeerLeft:
+		switch ev.PeerID {
+		case m.reanswer.peerID:
+			m.reanswer = reanswer{}
+		case m.reanswer.speaker:
+			m.answerAgain(ev)
+		}
+		delete(m.pending, ev.PeerID)
+		for i, peerID := range m.ready {
+			if peerID == ev.PeerID {
+				m.ready = append(m.ready[:i], m.ready[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// userSpoke applies the barge-in rules for user speech in the current state
+func (m *Machine) userSpoke(ev Event) {
+	switch m.state {
+	case Thinking:
+		// The user was not done: cancel and answer the combined utterance later
+		log.Printf("[turn] %s kept talking while thinking - cancelling response %d", ev.PeerID, m.active)
+		m.cancel()
+		m.restore(m.answered)
+		if ev.Type == EventSpeechStart {
+			m.reanswer = reanswer{speaker: ev.PeerID, peerID: m.answered.PeerID}
+		}
+		m.setState(Listening, ev)
+
+	case Speaking:
+		log.Printf("[turn] INTERRUPTION by %s - cancelling response %d", ev.PeerID, m.active)
+		m.cancel()
+		m.setState(Listening, ev)
+	}
+}
+
+// speakerHeard settles a put-back utterance once its speaker is transcribed: the
+// speaker's own utterance is answered with what they add, another peer's is
+// answered after the speaker's
+func (m *Machine) speakerHeard(peerID string) {
+	if m.reanswer.peerID == "" || peerID != m.reanswer.speaker {
+		return
+	}
+	if m.reanswer.peerID != peerID {
+		m.queueReady(m.reanswer.peerID)
+	}
+	m.reanswer = reanswer{}
+}
+
+// answerAgain answers the put-back utterance now, or once the running response exits
+func (m *Machine) answerAgain(cause Event) {
+	peerID := m.reanswer.peerID
+	m.reanswer = reanswer{}
+	if b, ok := m.pending[peerID]; !ok || b.Len() == 0 {
+		return
+	}
+	if m.active != 0 {
+		m.queueReady(peerID)
+		return
+	}
+	m.startResponse(peerID, cause)
+}
+
+// startResponse takes a peer's pending transcript and starts answering it
+func (m *Machine) startResponse(peerID string, cause Event) {
+	utterance := Utterance{PeerID: peerID, Text: m.pending[peerID].String()}
+	delete(m.pending, peerID)
+
+	m.nextID++
+	id := m.nextID
+	ctx, cancel := context.WithCancel(context.Background())
+	m.active = id
+	m.cancel = cancel
+	m.answered = utterance
+	m.setState(Thinking, cause)
+
+	go func() {
+		defer cancel()
+		m.config.Respond(ctx, Response{ID: id, Utterance: utterance})
+		m.Dispatch(Event{Type: EventResponseDone, ResponseID: id})
+	}()
+}
+
+// appendPending adds final transcript text to a peer's pending utterance
+func (m *Machine) appendPending(peerID, text string) {
+	b, ok := m.pending[peerID]
+	if !ok {
+		b = &strings.Builder{}
+		m.pending[peerID] = b
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	b.WriteString(text)
+}
+
+// restore puts a cancelled utterance back in front of the peer's pending text
+func (m *Machine) restore(u Utterance) {
+	rest := ""
+	if b, ok := m.pending[u.PeerID]; ok {
+		rest = b.String()
+	}
+	b := &strings.Builder{}
+	b.WriteString(u.Text)
+	if rest != "" {
+		b.WriteString(" ")
+		b.WriteString(rest)
+	}
+	m.pending[u.PeerID] = b
+}
+
+// queueReady remembers that a peer's utterance is complete
+func (m *Machine) queueReady(peerID string) {
+	for _, id := range m.ready {
+		if id == peerID {
+			return
+		}
+	}
+	m.ready = append(m.ready, peerID)
+}
+
+func (m *Machine) setState(to State, cause Event) {
+	from := m.state
+	m.state = to
+
+	m.stateMu.Lock()
+	m.current = to
+	m.stateMu.Unlock()
+
+	if m.config.OnStateChange != nil {
+		m.config.OnStateChange(from, to, cause)
+	}
+}
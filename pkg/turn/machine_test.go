@@ -0,0 +1,306 @@
+package turn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type transition struct {
+	from, to State
+	cause    EventType
+}
+
+// harness runs a machine whose responses last until cancelled or finished by the test
+// A cancelled response exits only once the test has seen it cancelled, so events
+// dispatched before then are handled while it is still running.
+type harness struct {
+	m           *Machine
+	transitions chan transition
+	started     chan Response
+	cancelled   chan int
+	finish      chan struct{}
+	stopped     chan struct{}
+}
+
+func newHarness(t *testing.T, bargeInOnTranscript bool) *harness {
+	h := &harness{
+		transitions: make(chan transition, 32),
+		started:     make(chan Response, 8),
+		cancelled:   make(chan int),
+		finish:      make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	h.m = NewMachine(Config{
+		BargeInOnTranscript: bargeInOnTranscript,
+		ReanswerDelay:       50 * time.Millisecond,
+		OnStateChange: func(from, to State, cause Event) {
+			h.transitions <- transition{from, to, cause.Type}
+		},
+		Respond: func(ctx context.Context, resp Response) {
+			h.started <- resp
+			select {
+			case <-ctx.Done():
+				select {
+				case h.cancelled <- resp.ID:
+				case <-h.stopped:
+				}
+			case <-h.finish:
+			}
+		},
+	})
+	h.m.Start()
+	t.Cleanup(func() {
+		h.m.Stop()
+		close(h.stopped)
+	})
+	return h
+}
+
+// say dispatches a final transcript followed by the end of the utterance
+func (h *harness) say(peerID, text string) {
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: peerID, Text: text, Final: true})
+	h.m.Dispatch(Event{Type: EventUtteranceEnd, PeerID: peerID})
+}
+
+func (h *harness) expect(t *testing.T, from, to State, cause EventType) {
+	t.Helper()
+	select {
+	case tr := <-h.transitions:
+		if tr != (transition{from, to, cause}) {
+			t.Fatalf("got %s -> %s on %s, want %s -> %s on %s", tr.from, tr.to, tr.cause, from, to, cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s -> %s on %s", from, to, cause)
+	}
+}
+
+func (h *harness) expectResponse(t *testing.T, peerID, text string) Response {
+	t.Helper()
+	select {
+	case resp := <-h.started:
+		if resp.Utterance != (Utterance{PeerID: peerID, Text: text}) {
+			t.Fatalf("response to %+v, want %s: %q", resp.Utterance, peerID, text)
+		}
+		return resp
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for a response to %s: %q", peerID, text)
+	}
+	return Response{}
+}
+
+func (h *harness) expectCancelled(t *testing.T, id int) {
+	t.Helper()
+	select {
+	case got := <-h.cancelled:
+		if got != id {
+			t.Fatalf("response %d cancelled, want %d", got, id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for response %d to be cancelled", id)
+	}
+}
+
+// expectQuiet checks that nothing else starts or changes state
+func (h *harness) expectQuiet(t *testing.T) {
+	t.Helper()
+	select {
+	case tr := <-h.transitions:
+		t.Fatalf("unexpected %s -> %s on %s", tr.from, tr.to, tr.cause)
+	case resp := <-h.started:
+		t.Fatalf("unexpected response to %+v", resp.Utterance)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// speaking brings the machine to Speaking on a response to peerID
+func (h *harness) speaking(t *testing.T, peerID, text string) Response {
+	t.Helper()
+	h.say(peerID, text)
+	h.expect(t, Listening, Thinking, EventUtteranceEnd)
+	resp := h.expectResponse(t, peerID, text)
+	h.m.PlaybackStarted(resp.ID)
+	h.expect(t, Thinking, Speaking, EventPlaybackStart)
+	return resp
+}
+
+func TestUtteranceEndStartsThinking(t *testing.T) {
+	h := newHarness(t, false)
+
+	// Interim text does not count, and nothing happens before the utterance ends
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "alice", Text: "what is"})
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "alice", Text: "what is", Final: true})
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "alice", Text: "the time", Final: true})
+	h.expectQuiet(t)
+
+	h.m.Dispatch(Event{Type: EventUtteranceEnd, PeerID: "alice"})
+	h.expect(t, Listening, Thinking, EventUtteranceEnd)
+	h.expectResponse(t, "alice", "what is the time")
+	if got := h.m.State(); got != Thinking {
+		t.Errorf("State() = %s, want thinking", got)
+	}
+
+	h.finish <- struct{}{}
+	h.expect(t, Thinking, Listening, EventResponseDone)
+}
+
+func TestUtteranceEndWithoutTextIsIgnored(t *testing.T) {
+	h := newHarness(t, false)
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "alice", Text: "  ", Final: true})
+	h.m.Dispatch(Event{Type: EventUtteranceEnd, PeerID: "alice"})
+	h.expectQuiet(t)
+}
+
+func TestSpeechStartInterruptsSpeaking(t *testing.T) {
+	h := newHarness(t, false)
+	resp := h.speaking(t, "alice", "tell me a story")
+
+	// Without BargeInOnTranscript a transcript alone does not interrupt
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "alice", Text: "hmm"})
+	h.expectQuiet(t)
+
+	h.m.Dispatch(Event{Type: EventSpeechStart, PeerID: "alice"})
+	h.expect(t, Speaking, Listening, EventSpeechStart)
+
+	// The interrupted utterance is not answered again, and the next one waits for
+	// the cancelled response to exit
+	h.say("alice", "stop")
+	h.expectCancelled(t, resp.ID)
+	h.expect(t, Listening, Thinking, EventResponseDone)
+	h.expectResponse(t, "alice", "stop")
+}
+
+func TestTranscriptInterruptsSpeaking(t *testing.T) {
+	h := newHarness(t, true)
+	resp := h.speaking(t, "alice", "tell me a story")
+
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "bob", Text: "wait"})
+	h.expect(t, Speaking, Listening, EventTranscript)
+	h.expectCancelled(t, resp.ID)
+}
+
+func TestUtteranceEndWhileThinkingIsAnsweredNext(t *testing.T) {
+	h := newHarness(t, false)
+	h.say("alice", "hello")
+	h.expect(t, Listening, Thinking, EventUtteranceEnd)
+	first := h.expectResponse(t, "alice", "hello")
+
+	// Bob finishes while alice's response is running; he is answered once it exits
+	h.say("bob", "and me")
+	h.expectQuiet(t)
+
+	h.m.PlaybackStarted(first.ID)
+	h.expect(t, Thinking, Speaking, EventPlaybackStart)
+	h.finish <- struct{}{}
+	h.expect(t, Speaking, Listening, EventResponseDone)
+	h.expect(t, Listening, Thinking, EventResponseDone)
+	h.expectResponse(t, "bob", "and me")
+}
+
+func TestSpeechWhileThinkingCombinesUtterance(t *testing.T) {
+	h := newHarness(t, false)
+	h.say("alice", "book a table")
+	h.expect(t, Listening, Thinking, EventUtteranceEnd)
+	first := h.expectResponse(t, "alice", "book a table")
+
+	h.m.Dispatch(Event{Type: EventSpeechStart, PeerID: "alice"})
+	h.expect(t, Thinking, Listening, EventSpeechStart)
+
+	// The cancelled response must exit before the combined utterance is answered
+	h.say("alice", "for two")
+	h.expectQuiet(t)
+	h.expectCancelled(t, first.ID)
+	h.expect(t, Listening, Thinking, EventResponseDone)
+	h.expectResponse(t, "alice", "book a table for two")
+}
+
+func TestSpeechWithoutTranscriptWhileThinkingAnswersAgain(t *testing.T) {
+	for _, speaker := range []string{"alice", "bob"} {
+		t.Run(speaker, func(t *testing.T) {
+			h := newHarness(t, false)
+			h.say("alice", "book a table")
+			h.expect(t, Listening, Thinking, EventUtteranceEnd)
+			first := h.expectResponse(t, "alice", "book a table")
+
+			// A cough: speech starts and ends, and STT hears nothing
+			h.m.Dispatch(Event{Type: EventSpeechStart, PeerID: speaker})
+			h.expect(t, Thinking, Listening, EventSpeechStart)
+			h.expectCancelled(t, first.ID)
+			h.m.Dispatch(Event{Type: EventSpeechEnd, PeerID: speaker})
+
+			h.expect(t, Listening, Thinking, eventReanswer)
+			h.expectResponse(t, "alice", "book a table")
+		})
+	}
+}
+
+func TestSpeechWithTranscriptWhileThinkingIsNotAnsweredAgain(t *testing.T) {
+	h := newHarness(t, false)
+	h.say("alice", "book a table")
+	h.expect(t, Listening, Thinking, EventUtteranceEnd)
+	first := h.expectResponse(t, "alice", "book a table")
+
+	h.m.Dispatch(Event{Type: EventSpeechStart, PeerID: "alice"})
+	h.expect(t, Thinking, Listening, EventSpeechStart)
+	h.expectCancelled(t, first.ID)
+	h.m.Dispatch(Event{Type: EventTranscript, PeerID: "alice", Text: "for"})
+	h.m.Dispatch(Event{Type: EventSpeechEnd, PeerID: "alice"})
+
+	// The utterance waits for the rest of what alice is saying
+	h.expectQuiet(t)
+	h.say("alice", "for two")
+	h.expect(t, Listening, Thinking, EventUtteranceEnd)
+	h.expectResponse(t, "alice", "book a table for two")
+}
+
+func TestPeerLeftDropsPendingSpeech(t *testing.T) {
+	t.Run("listening", func(t *testing.T) {
+		h := newHarness(t, false)
+		h.m.Dispatch(Event{Type: EventTranscript, PeerID: "bob", Text: "half a", Final: true})
+		h.m.Dispatch(Event{Type: EventPeerLeft, PeerID: "bob"})
+		h.m.Dispatch(Event{Type: EventUtteranceEnd, PeerID: "bob"})
+		h.expectQuiet(t)
+	})
+
+	t.Run("thinking", func(t *testing.T) {
+		h := newHarness(t, false)
+		h.say("alice", "hello")
+		h.expect(t, Listening, Thinking, EventUtteranceEnd)
+		h.expectResponse(t, "alice", "hello")
+
+		h.say("bob", "me next")
+		h.m.Dispatch(Event{Type: EventPeerLeft, PeerID: "bob"})
+		h.expectQuiet(t)
+
+		h.finish <- struct{}{}
+		h.expect(t, Thinking, Listening, EventResponseDone)
+		h.expectQuiet(t)
+	})
+
+	t.Run("speaking", func(t *testing.T) {
+		h := newHarness(t, false)
+		h.speaking(t, "alice", "hello")
+
+		h.say("bob", "me next")
+		h.m.Dispatch(Event{Type: EventPeerLeft, PeerID: "bob"})
+		h.expectQuiet(t)
+		if got := h.m.State(); got != Speaking {
+			t.Errorf("State() = %s, want speaking", got)
+		}
+
+		h.finish <- struct{}{}
+		h.expect(t, Speaking, Listening, EventResponseDone)
+		h.expectQuiet(t)
+	})
+
+	t.Run("speaker", func(t *testing.T) {
+		// The peer being answered leaving does not cut the response short
+		h := newHarness(t, false)
+		h.speaking(t, "alice", "hello")
+		h.m.Dispatch(Event{Type: EventPeerLeft, PeerID: "alice"})
+		h.expectQuiet(t)
+
+		h.finish <- struct{}{}
+		h.expect(t, Speaking, Listening, EventResponseDone)
+	})
+}
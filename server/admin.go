@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/agent_bridge/pkg/auth"
+)
+
+// adminAllRooms is the room claim of admin tokens valid for every room
+const adminAllRooms = "*"
+
+// registerAdminRoutes adds the operator API under /admin/
+//
+// Requests authenticate with "Authorization: Bearer <credential>", where the
+// credential is the -admin-key, or a join token with the admin role signed with
+// -token-secret. A token is limited to its room unless the room is "*".
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/rooms", adminHandler(handleAdminListRooms))
+	mux.HandleFunc("GET /admin/rooms/{room}", adminHandler(handleAdminGetRoom))
+	mux.HandleFunc("DELETE /admin/rooms/{room}", adminHandler(handleAdminCloseRoom))
+	mux.HandleFunc("POST /admin/rooms/{room}/broadcast", adminHandler(handleAdminBroadcast))
+	mux.HandleFunc("POST /admin/rooms/{room}/recording", adminHandler(handleAdminStartRecording))
+	mux.HandleFunc("DELETE /admin/rooms/{room}/recording", adminHandler(handleAdminStopRecording))
+	mux.HandleFunc("GET /admin/rooms/{room}/peers/{peer}", adminHandler(handleAdminGetPeer))
+	mux.HandleFunc("DELETE /admin/rooms/{room}/peers/{peer}", adminHandler(handleAdminKickPeer))
+	mux.HandleFunc("POST /admin/rooms/{room}/peers/{peer}/mute", adminHandler(handleAdminMutePeer))
+}
+
+// adminEnabled reports whether any admin credential is configured
+func adminEnabled() bool {
+	return len(authConfig.adminKey) > 0 || len(authConfig.secret) > 0
+}
+
+// adminHandler authenticates a request and checks that its credential covers the room in the path
+func adminHandler(handler func(w http.ResponseWriter, r *http.Request, scope string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, err := authorizeAdmin(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if room := r.PathValue("room"); room != "" && scope != adminAllRooms && scope != room {
+			writeAdminError(w, http.StatusForbidden, "credential is not valid for room "+room)
+			return
+		}
+		handler(w, r, scope)
+	}
+}
+
+// authorizeAdmin validates the bearer credential and returns the room it grants, or "*"
+func authorizeAdmin(r *http.Request) (string, error) {
+	credential, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || credential == "" {
+		return "", errors.New("admin credential required")
+	}
+
+	if len(authConfig.adminKey) > 0 && subtle.ConstantTimeCompare([]byte(credential), authConfig.adminKey) == 1 {
+		return adminAllRooms, nil
+	}
+	if len(authConfig.secret) > 0 {
+		claims, err := auth.Parse(authConfig.secret, credential)
+		if err == nil && claims.Role == auth.RoleAdmin {
+			return claims.Room, nil
+		}
+	}
+	return "", errors.New("invalid admin credential")
+}
+
+// roomSummary is a room in admin responses
+type roomSummary struct {
+	RoomInfo
+	PeerCount int            `json:"peer_count"`
+	Peers     []peerSummary  `json:"peers"`
+	Recording *RecordingInfo `json:"recording,omitempty"`
+}
+
+// peerSummary is a peer in admin responses
+type peerSummary struct {
+	ID              string    `json:"id"`
+	Role            string    `json:"role"`
+	JoinedAt        time.Time `json:"joined_at"`
+	ConnectionState string    `json:"connection_state"`
+	ForceMuted      bool      `json:"force_muted"`
+}
+
+// peerDetails adds WebRTC state and tracks to a peer summary
+type peerDetails struct {
+	peerSummary
+	ICEConnectionState string   `json:"ice_connection_state"`
+	ICEGatheringState  string   `json:"ice_gathering_state"`
+	SignalingState     string   `json:"signaling_state"`
+	PublishedTracks    []string `json:"published_tracks"` // IDs of the peer's own tracks
+	Subscriptions      []string `json:"subscriptions"`    // Publishers forwarded to the peer
+}
+
+func summarizeRoom(room *Room) roomSummary {
+	peers := room.ListPeers()
+	summary := roomSummary{
+		RoomInfo:  room.Info(),
+		PeerCount: len(peers),
+		Peers:     make([]peerSummary, 0, len(peers)),
+	}
+	for _, peer := range peers {
+		summary.Peers = append(summary.Peers, summarizePeer(peer))
+	}
+	if rec := room.recording.Load(); rec != nil {
+		summary.Recording = rec.info()
+	}
+	return summary
+}
+
+func summarizePeer(peer *Peer) peerSummary {
+	return peerSummary{
+		ID:              peer.ID,
+		Role:            peer.Role,
+		JoinedAt:        peer.JoinedAt,
+		ConnectionState: peer.PeerConnection.ConnectionState().String(),
+		ForceMuted:      peer.forceMuted.Load(),
+	}
+}
+
+func describePeer(peer *Peer) peerDetails {
+	pc := peer.PeerConnection
+	details := peerDetails{
+		peerSummary:        summarizePeer(peer),
+		ICEConnectionState: pc.ICEConnectionState().String(),
+		ICEGatheringState:  pc.ICEGatheringState().String(),
+		SignalingState:     pc.SignalingState().String(),
+		PublishedTracks:    []string{},
+		Subscriptions:      []string{},
+	}
+
+	peer.mu.Lock()
+	for id := range peer.LocalTracks {
+		details.PublishedTracks = append(details.PublishedTracks, id)
+	}
+	peer.mu.Unlock()
+
+	peer.tracksMu.Lock()
+	for publisherID := range peer.Senders {
+		details.Subscriptions = append(details.Subscriptions, publisherID)
+	}
+	peer.tracksMu.Unlock()
+
+	sort.Strings(details.PublishedTracks)
+	sort.Strings(details.Subscriptions)
+	return details
+}
+
+// handleAdminListRooms lists the rooms the credential covers, with their peers
+func handleAdminListRooms(w http.ResponseWriter, r *http.Request, scope string) {
+	rooms := make([]roomSummary, 0)
+	for _, room := range roomManager.ListRooms() {
+		if scope == adminAllRooms || scope == room.ID {
+			rooms = append(rooms, summarizeRoom(room))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rooms": rooms})
+}
+
+func handleAdminGetRoom(w http.ResponseWriter, r *http.Request, scope string) {
+	room := adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, summarizeRoom(room))
+}
+
+// handleAdminCloseRoom closes a room, disconnecting everyone in it
+func handleAdminCloseRoom(w http.ResponseWriter, r *http.Request, scope string) {
+	room := adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	log.Printf("Admin closing room %s", room.ID)
+	roomManager.CloseRoom(room, CloseReasonAdmin)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "closed"})
+}
+
+// handleAdminBroadcast sends a system message to every peer in a room
+func handleAdminBroadcast(w http.ResponseWriter, r *http.Request, scope string) {
+	room := adminRoom(w, r)
+	if room == nil {
+		return
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Message == "" {
+		writeAdminError(w, http.StatusBadRequest, `body must be {"message": "..."}`)
+		return
+	}
+
+	peers := room.ListPeers()
+	room.BroadcastExcept("", SignalMessage{Type: "system", Text: body.Message})
+	writeJSON(w, http.StatusOK, map[string]int{"delivered": len(peers)})
+}
+
+// recordingStatus is a recording in admin responses
+type recordingStatus struct {
+	RecordingInfo
+	Dir      string             `json:"dir"`                // Holds the track files and the manifest
+	Manifest *recordingManifest `json:"manifest,omitempty"` // Once stopped
+}
+
+// handleAdminStartRecording starts recording a room
+func handleAdminStartRecording(w http.ResponseWriter, r *http.Request, scope string) {
+	room := adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	rec, err := room.StartRecording("admin")
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, recordingStatus{RecordingInfo: *rec.info(), Dir: rec.dir})
+}
+
+// handleAdminStopRecording stops recording a room and returns the manifest
+func handleAdminStopRecording(w http.ResponseWriter, r *http.Request, scope string) {
+	room := adminRoom(w, r)
+	if room == nil {
+		return
+	}
+	rec, manifest, err := room.StopRecording()
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recordingStatus{Dir: rec.dir, Manifest: manifest})
+}
+
+// writeRecordingError maps a recording error to a status code
+func writeRecordingError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errRecordingDisabled):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, errAlreadyRecording), errors.Is(err, errNotRecording):
+		status = http.StatusConflict
+	case errors.Is(err, errRoomClosed):
+		status = http.StatusNotFound
+	}
+	writeAdminError(w, status, err.Error())
+}
+
+func handleAdminGetPeer(w http.ResponseWriter, r *http.Request, scope string) {
+	peer := adminPeer(w, r)
+	if peer == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, describePeer(peer))
+}
+
+// handleAdminKickPeer removes a peer from its room and closes its connection
+func handleAdminKickPeer(w http.ResponseWriter, r *http.Request, scope string) {
+	peer := adminPeer(w, r)
+	if peer == nil {
+		return
+	}
+
+	log.Printf("Admin kicking %s from room %s", peer.ID, peer.Room.ID)
+	peer.SendMessage(SignalMessage{
+		Type:  "error",
+		Code:  ErrCodeKicked,
+		Error: "removed from the room by an operator",
+	})
+	handlePeerDisconnect(peer)
+	peer.Conn.Close()
+	writeJSON(w, http.StatusOK, map[string]string{"status": "kicked"})
+}
+
+// handleAdminMutePeer stops or resumes forwarding a peer's audio to the room
+func handleAdminMutePeer(w http.ResponseWriter, r *http.Request, scope string) {
+	peer := adminPeer(w, r)
+	if peer == nil {
+		return
+	}
+
+	body := struct {
+		Muted *bool `json:"muted"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Muted == nil {
+		writeAdminError(w, http.StatusBadRequest, `body must be {"muted": true|false}`)
+		return
+	}
+
+	log.Printf("Admin setting force mute of %s in room %s to %v", peer.ID, peer.Room.ID, *body.Muted)
+	setForceMuted(peer, *body.Muted)
+	writeJSON(w, http.StatusOK, describePeer(peer))
+}
+
+// setForceMuted stops or resumes forwarding a peer's audio and tells the room
+func setForceMuted(peer *Peer, muted bool) {
+	if peer.forceMuted.Swap(muted) == muted {
+		return
+	}
+	peer.Room.BroadcastExcept("", SignalMessage{
+		Type:     "force_mute",
+		ClientID: peer.ID,
+		Muted:    muted,
+	})
+}
+
+// adminRoom looks up the room in the path, writing a 404 if it does not exist
+func adminRoom(w http.ResponseWriter, r *http.Request) *Room {
+	room := roomManager.GetRoom(r.PathValue("room"))
+	if room == nil {
+		writeAdminError(w, http.StatusNotFound, "room not found")
+	}
+	return room
+}
+
+// adminPeer looks up the room and peer in the path, writing a 404 if either does not exist
+func adminPeer(w http.ResponseWriter, r *http.Request) *Peer {
+	room := adminRoom(w, r)
+	if room == nil {
+		return nil
+	}
+	peer := room.GetPeer(r.PathValue("peer"))
+	if peer == nil {
+		writeAdminError(w, http.StatusNotFound, "peer not found")
+	}
+	return peer
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"example.com/agent_bridge/pkg/auth"
+	"github.com/pion/webrtc/v4"
+)
+
+const testAdminKey = "test-admin-key"
+
+// adminTestServer serves the signaling endpoint and the admin API with an admin key
+// It returns the HTTP base URL and the WebSocket URL
+func adminTestServer(t *testing.T) (string, string) {
+	t.Helper()
+	saved := authConfig.adminKey
+	authConfig.adminKey = []byte(testAdminKey)
+	t.Cleanup(func() { authConfig.adminKey = saved })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", handleWebSocket)
+	registerAdminRoutes(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL, "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+// adminRequest calls the admin API and decodes the JSON response into out, if given
+func adminRequest(t *testing.T, method, url, credential, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type %q, want application/json", method, url, ct)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: bad JSON %q: %v", method, url, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// clientEvents records the operator messages a client receives
+type clientEvents struct {
+	mu        sync.Mutex
+	system    []string
+	muted     map[string]bool
+	errCodes  []string
+	errors    []string // Message of each error in errCodes
+	recording []bool
+	speakers  []string
+	data      []client.DataMessage
+	shots     []string // Sender and image of each screenshot
+	peers     []string // "+id" for each peer_joined, "-id" for each peer_left
+	tracks    []string // Publisher of each received track
+	states    []client.ConnectionState
+}
+
+func (e *clientEvents) has(check func() bool) func() bool {
+	return func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return check()
+	}
+}
+
+// joinWatched connects a client that records operator messages
+func joinWatched(t *testing.T, url, room, id string, opts ...client.Option) (*client.Client, *clientEvents) {
+	t.Helper()
+	events := &clientEvents{muted: make(map[string]bool)}
+	c := client.NewClient(id, url, opts...)
+	c.OnSystemMessage(func(text string) {
+		events.mu.Lock()
+		events.system = append(events.system, text)
+		events.mu.Unlock()
+	})
+	c.OnForceMute(func(peerID string, muted bool) {
+		events.mu.Lock()
+		events.muted[peerID] = muted
+		events.mu.Unlock()
+	})
+	c.OnRecording(func(info client.RecordingInfo) {
+		events.mu.Lock()
+		events.recording = append(events.recording, info.Active)
+		events.mu.Unlock()
+	})
+	c.OnData(func(msg client.DataMessage) {
+		events.mu.Lock()
+		events.data = append(events.data, msg)
+		events.mu.Unlock()
+	})
+	c.OnScreenshotReceived(func(peerID, imageData string) {
+		events.mu.Lock()
+		events.shots = append(events.shots, peerID+":"+imageData)
+		events.mu.Unlock()
+	})
+	c.OnActiveSpeaker(func(peerID string) {
+		events.mu.Lock()
+		events.speakers = append(events.speakers, peerID)
+		events.mu.Unlock()
+	})
+	c.OnError(func(code, message string) {
+		events.mu.Lock()
+		events.errCodes = append(events.errCodes, code)
+		events.errors = append(events.errors, message)
+		events.mu.Unlock()
+	})
+	c.OnPeerEvent(func(peerID string, joined bool) {
+		event := "-" + peerID
+		if joined {
+			event = "+" + peerID
+		}
+		events.mu.Lock()
+		events.peers = append(events.peers, event)
+		events.mu.Unlock()
+	})
+	c.OnAudioReceived(func(peerID string, track *webrtc.TrackRemote) {
+		events.mu.Lock()
+		events.tracks = append(events.tracks, peerID)
+		events.mu.Unlock()
+	})
+	c.OnConnectionStateChange(func(state client.ConnectionState) {
+		events.mu.Lock()
+		events.states = append(events.states, state)
+		events.mu.Unlock()
+	})
+	if err := c.Connect(room); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Disconnect() })
+
+	waitFor(t, 5*time.Second, id+" to join", func() bool {
+		r := roomManager.GetRoom(room)
+		return r != nil && r.GetPeer(id) != nil
+	})
+	return c, events
+}
+
+func TestAdminRequiresCredential(t *testing.T) {
+	base, _ := adminTestServer(t)
+	roomManager.GetOrCreateRoom("admin-auth")
+	roomManager.GetOrCreateRoom("admin-other")
+
+	savedSecret := authConfig.secret
+	authConfig.secret = []byte("test-secret")
+	t.Cleanup(func() { authConfig.secret = savedSecret })
+
+	participant, err := auth.NewToken(authConfig.secret, "admin-auth", "someone", auth.RoleParticipant, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := auth.NewToken(authConfig.secret, "admin-auth", "operator", auth.RoleAdmin, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		credential string
+		path       string
+		want       int
+	}{
+		{"no credential", "", "/admin/rooms", http.StatusUnauthorized},
+		{"wrong key", "not-the-key", "/admin/rooms", http.StatusUnauthorized},
+		{"participant token", participant, "/admin/rooms", http.StatusUnauthorized},
+		{"admin key", testAdminKey, "/admin/rooms/admin-other", http.StatusOK},
+		{"scoped token, own room", scoped, "/admin/rooms/admin-auth", http.StatusOK},
+		{"scoped token, other room", scoped, "/admin/rooms/admin-other", http.StatusForbidden},
+		{"unknown room", testAdminKey, "/admin/rooms/no-such-room", http.StatusNotFound},
+	} {
+		var body map[string]any
+		if got := adminRequest(t, "GET", base+tc.path, tc.credential, "", &body); got != tc.want {
+			t.Errorf("%s: status %d, want %d (%v)", tc.name, got, tc.want, body)
+		}
+		if tc.want != http.StatusOK && body["error"] == nil {
+			t.Errorf("%s: error response without an error message: %v", tc.name, body)
+		}
+	}
+
+	// A scoped token lists only its own room
+	var list struct {
+		Rooms []roomSummary `json:"rooms"`
+	}
+	if got := adminRequest(t, "GET", base+"/admin/rooms", scoped, "", &list); got != http.StatusOK {
+		t.Fatalf("list rooms: status %d", got)
+	}
+	if len(list.Rooms) != 1 || list.Rooms[0].ID != "admin-auth" {
+		t.Errorf("scoped token listed %+v, want only admin-auth", list.Rooms)
+	}
+}
+
+func TestAdminListsRoomsAndPeers(t *testing.T) {
+	base, url := adminTestServer(t)
+	joinWatched(t, url, "admin-list", "alice")
+	joinWatched(t, url, "admin-list", "bob")
+
+	var list struct {
+		Rooms []roomSummary `json:"rooms"`
+	}
+	if got := adminRequest(t, "GET", base+"/admin/rooms", testAdminKey, "", &list); got != http.StatusOK {
+		t.Fatalf("list rooms: status %d", got)
+	}
+	var room *roomSummary
+	for i := range list.Rooms {
+		if list.Rooms[i].ID == "admin-list" {
+			room = &list.Rooms[i]
+		}
+	}
+	if room == nil {
+		t.Fatalf("admin-list missing from %+v", list.Rooms)
+	}
+	if room.PeerCount != 2 || room.Peers[0].ID != "alice" || room.Peers[1].ID != "bob" {
+		t.Errorf("room peers %+v, want alice and bob", room.Peers)
+	}
+
+	// Details include the WebRTC state
+	var details peerDetails
+	waitFor(t, 10*time.Second, "alice to be connected", func() bool {
+		adminRequest(t, "GET", base+"/admin/rooms/admin-list/peers/alice", testAdminKey, "", &details)
+		return details.ConnectionState == "connected"
+	})
+	if details.ID != "alice" || details.Role != auth.RoleParticipant || details.JoinedAt.IsZero() {
+		t.Errorf("unexpected peer details %+v", details)
+	}
+	if details.SignalingState == "" || details.ICEConnectionState == "" {
+		t.Errorf("peer details missing WebRTC state: %+v", details)
+	}
+
+	if got := adminRequest(t, "GET", base+"/admin/rooms/admin-list/peers/nobody", testAdminKey, "", nil); got != http.StatusNotFound {
+		t.Errorf("unknown peer: status %d, want 404", got)
+	}
+}
+
+func TestAdminModerationActions(t *testing.T) {
+	base, url := adminTestServer(t)
+	_, aliceEvents := joinWatched(t, url, "admin-mod", "alice")
+	_, bobEvents := joinWatched(t, url, "admin-mod", "bob")
+	roomURL := base + "/admin/rooms/admin-mod"
+
+	// Broadcast reaches everyone
+	var delivered map[string]int
+	if got := adminRequest(t, "POST", roomURL+"/broadcast", testAdminKey, `{"message": "maintenance at noon"}`, &delivered); got != http.StatusOK {
+		t.Fatalf("broadcast: status %d", got)
+	}
+	if delivered["delivered"] != 2 {
+		t.Errorf("broadcast delivered to %d peers, want 2", delivered["delivered"])
+	}
+	for name, events := range map[string]*clientEvents{"alice": aliceEvents, "bob": bobEvents} {
+		waitFor(t, 5*time.Second, name+" to get the broadcast", events.has(func() bool {
+			return len(events.system) == 1 && events.system[0] == "maintenance at noon"
+		}))
+	}
+	if got := adminRequest(t, "POST", roomURL+"/broadcast", testAdminKey, `{}`, nil); got != http.StatusBadRequest {
+		t.Errorf("empty broadcast: status %d, want 400", got)
+	}
+
+	// Force mute stops forwarding and is announced
+	var details peerDetails
+	if got := adminRequest(t, "POST", roomURL+"/peers/alice/mute", testAdminKey, `{"muted": true}`, &details); got != http.StatusOK {
+		t.Fatalf("mute: status %d", got)
+	}
+	if !details.ForceMuted {
+		t.Error("mute response does not report force_muted")
+	}
+	waitFor(t, 5*time.Second, "bob to see alice muted", bobEvents.has(func() bool { return bobEvents.muted["alice"] }))
+	adminRequest(t, "POST", roomURL+"/peers/alice/mute", testAdminKey, `{"muted": false}`, &details)
+	if details.ForceMuted {
+		t.Error("unmute response still reports force_muted")
+	}
+	if got := adminRequest(t, "POST", roomURL+"/peers/alice/mute", testAdminKey, `{}`, nil); got != http.StatusBadRequest {
+		t.Errorf("mute without a value: status %d, want 400", got)
+	}
+
+	// Kick removes the peer and tells it why
+	if got := adminRequest(t, "DELETE", roomURL+"/peers/bob", testAdminKey, "", nil); got != http.StatusOK {
+		t.Fatalf("kick: status %d", got)
+	}
+	waitFor(t, 5*time.Second, "bob to get the kicked error", bobEvents.has(func() bool {
+		return len(bobEvents.errCodes) > 0 && bobEvents.errCodes[0] == ErrCodeKicked
+	}))
+	if roomManager.GetRoom("admin-mod").GetPeer("bob") != nil {
+		t.Error("kicked peer is still in the room")
+	}
+
+	// Closing the room disconnects the rest and removes it
+	if got := adminRequest(t, "DELETE", roomURL, testAdminKey, "", nil); got != http.StatusOK {
+		t.Fatalf("close room: status %d", got)
+	}
+	waitFor(t, 5*time.Second, "alice to be told the room closed", aliceEvents.has(func() bool {
+		return len(aliceEvents.errCodes) > 0 && aliceEvents.errCodes[0] == ErrCodeRoomClosed
+	}))
+	if got := adminRequest(t, "GET", roomURL, testAdminKey, "", nil); got != http.StatusNotFound {
+		t.Errorf("closed room: status %d, want 404", got)
+	}
+	if got := adminRequest(t, "DELETE", roomURL, testAdminKey, "", nil); got != http.StatusNotFound {
+		t.Errorf("closing again: status %d, want 404", got)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/agent_bridge/pkg/auth"
+	"github.com/gorilla/websocket"
+)
+
+// authConfig holds join authorization settings, set from flags in main
+var authConfig struct {
+	secret         []byte          // Join token signing secret; empty disables tokens
+	openJoin       bool            // Without a secret, accept any join instead of none
+	adminKey       []byte          // Static admin API key; admin tokens also work
+	allowedOrigins map[string]bool // Browser origins allowed to open /ws
+	anyOrigin      bool
+}
+
+// setAllowedOrigins parses a comma-separated origin allowlist; "*" allows any origin
+func setAllowedOrigins(list string) {
+	authConfig.allowedOrigins = make(map[string]bool)
+	authConfig.anyOrigin = false
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			authConfig.anyOrigin = true
+		} else if origin != "" {
+			authConfig.allowedOrigins[origin] = true
+		}
+	}
+}
+
+// checkOrigin allows non-browser clients (no Origin header) and allowlisted origins
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || authConfig.anyOrigin {
+		return true
+	}
+	if authConfig.allowedOrigins[origin] {
+		return true
+	}
+	log.Printf("Rejected WebSocket connection from origin %s", origin)
+	return false
+}
+
+// upgradeError writes failed upgrades (e.g. a rejected origin) as a JSON error
+func upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	code := ErrCodeBadRequest
+	if status == http.StatusForbidden {
+		code = ErrCodeForbidden
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SignalMessage{Type: "error", Code: code, Error: reason.Error()})
+}
+
+// joinError is a join rejection to report to the client
+type joinError struct {
+	code    string
+	message string
+}
+
+func (e *joinError) Error() string {
+	return e.message
+}
+
+// authorizeJoin validates a join message and returns the identity and role to join with
+// Without a signing secret any join is accepted as a participant, if open joins are allowed
+func authorizeJoin(msg SignalMessage) (*auth.Claims, error) {
+	if len(authConfig.secret) == 0 {
+		if !authConfig.openJoin {
+			return nil, &joinError{ErrCodeUnauthorized, "join tokens are not configured on this server"}
+		}
+		if msg.ClientID == "" || msg.Room == "" {
+			return nil, &joinError{ErrCodeBadRequest, "join requires client_id and room"}
+		}
+		return &auth.Claims{Room: msg.Room, Identity: msg.ClientID, Role: auth.RoleParticipant}, nil
+	}
+
+	if msg.Token == "" {
+		return nil, &joinError{ErrCodeUnauthorized, "join token required"}
+	}
+	claims, err := auth.Parse(authConfig.secret, msg.Token)
+	if errors.Is(err, auth.ErrTokenExpired) {
+		return nil, &joinError{ErrCodeUnauthorized, "join token expired"}
+	}
+	if err != nil {
+		return nil, &joinError{ErrCodeUnauthorized, "invalid join token"}
+	}
+
+	// The token decides the room and identity; the message may only repeat them
+	if msg.Room != "" && msg.Room != claims.Room {
+		return nil, &joinError{ErrCodeForbidden, fmt.Sprintf("token is not valid for room %s", msg.Room)}
+	}
+	if msg.ClientID != "" && msg.ClientID != claims.Identity {
+		return nil, &joinError{ErrCodeForbidden, fmt.Sprintf("token is not valid for client %s", msg.ClientID)}
+	}
+	return claims, nil
+}
+
+// joinRoomOptions returns the room options a join may set if it creates the room
+// With join tokens enabled, only agents and admins may set them
+func joinRoomOptions(msg SignalMessage, claims *auth.Claims) *RoomOptions {
+	if msg.RoomOptions == nil {
+		return nil
+	}
+	if len(authConfig.secret) > 0 && claims.Role == auth.RoleParticipant {
+		log.Printf("Ignoring room options from participant %s", claims.Identity)
+		return nil
+	}
+	return msg.RoomOptions
+}
+
+// rejectJoin sends the reason a join was refused and closes the connection
+func rejectJoin(conn *websocket.Conn, err error) {
+	code, message := ErrCodeBadRequest, err.Error()
+	var je *joinError
+	if errors.As(err, &je) {
+		code = je.code
+	}
+
+	conn.WriteJSON(SignalMessage{Type: "error", Code: code, Error: message})
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, message),
+		time.Now().Add(time.Second))
+}
+
+// runTokenCommand implements "server token": it prints a signed join token
+func runTokenCommand(args []string) {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	secret := fs.String("secret", os.Getenv("SFU_TOKEN_SECRET"), "Signing secret (or SFU_TOKEN_SECRET env)")
+	room := fs.String("room", "", "Room the token grants access to (required)")
+	identity := fs.String("identity", "", "Client ID the token is issued to (required)")
+	role := fs.String("role", auth.RoleParticipant, "Role: participant, agent or admin")
+	ttl := fs.Duration("ttl", time.Hour, "Token lifetime")
+	fs.Parse(args)
+
+	if *secret == "" || *room == "" || *identity == "" {
+		fmt.Fprintln(os.Stderr, "Usage: server token -secret <secret> -room <room> -identity <id> [-role participant] [-ttl 1h]")
+		os.Exit(2)
+	}
+
+	token, err := auth.NewToken([]byte(*secret), *room, *identity, *role, *ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create token: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
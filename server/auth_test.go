@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/pkg/auth"
+	"github.com/gorilla/websocket"
+)
+
+// Most tests join without tokens
+func TestMain(m *testing.M) {
+	authConfig.openJoin = true
+	os.Exit(m.Run())
+}
+
+// closingTestServer is newTestServer for clients that close their connections
+// before the test ends; cleanup waits for their handlers to return, which
+// httptest does not do for WebSocket connections
+func closingTestServer(t *testing.T) string {
+	t.Helper()
+	var handlers sync.WaitGroup
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		handleWebSocket(w, r)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		handlers.Wait()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// withJoinSecret enables join tokens for the duration of the test
+func withJoinSecret(t *testing.T) []byte {
+	t.Helper()
+	saved := authConfig.secret
+	authConfig.secret = []byte("test-secret")
+	t.Cleanup(func() { authConfig.secret = saved })
+	return authConfig.secret
+}
+
+// joinToken mints a participant token for a client in a room
+func joinToken(t *testing.T, secret []byte, room, id string) string {
+	t.Helper()
+	token, err := auth.NewToken(secret, room, id, auth.RoleParticipant, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// joinReply sends a join and returns the first message the server answers with
+func joinReply(t *testing.T, url string, join SignalMessage) SignalMessage {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(join); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply SignalMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("waiting for a reply to the join: %v", err)
+	}
+	return reply
+}
+
+func TestJoinRequiresValidToken(t *testing.T) {
+	secret := withJoinSecret(t)
+	url := closingTestServer(t)
+
+	token, err := auth.NewToken(secret, "auth-room", "alice", auth.RoleParticipant, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := auth.Sign(secret, auth.Claims{Room: "auth-room", Identity: "alice", Role: auth.RoleParticipant, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := auth.NewToken([]byte("other-secret"), "auth-room", "alice", auth.RoleParticipant, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		join SignalMessage
+		want string
+	}{
+		{"missing token", SignalMessage{Room: "auth-room", ClientID: "alice"}, ErrCodeUnauthorized},
+		{"expired token", SignalMessage{Room: "auth-room", ClientID: "alice", Token: expired}, ErrCodeUnauthorized},
+		{"forged token", SignalMessage{Room: "auth-room", ClientID: "alice", Token: forged}, ErrCodeUnauthorized},
+		{"other room", SignalMessage{Room: "elsewhere", ClientID: "alice", Token: token}, ErrCodeForbidden},
+		{"other client", SignalMessage{Room: "auth-room", ClientID: "mallory", Token: token}, ErrCodeForbidden},
+	} {
+		tc.join.Type = "join"
+		reply := joinReply(t, url, tc.join)
+		if reply.Type != "error" || reply.Code != tc.want {
+			t.Errorf("%s: got %s %q (%s), want error %q", tc.name, reply.Type, reply.Code, reply.Error, tc.want)
+		}
+	}
+	if room := roomManager.GetRoom("auth-room"); room != nil && room.GetPeer("alice") != nil {
+		t.Error("a rejected join entered the room")
+	}
+
+	// The token alone decides the room and identity
+	reply := joinReply(t, url, SignalMessage{Type: "join", Token: token})
+	if reply.Type != "room_info" || reply.Room != "auth-room" {
+		t.Errorf("join with a valid token: got %s %q (%s), want room_info for auth-room", reply.Type, reply.Code, reply.Error)
+	}
+}
+
+func TestJoinWithoutTokensNeedsOpenJoin(t *testing.T) {
+	url := closingTestServer(t)
+	authConfig.openJoin = false
+	t.Cleanup(func() { authConfig.openJoin = true })
+
+	reply := joinReply(t, url, SignalMessage{Type: "join", Room: "closed-room", ClientID: "alice"})
+	if reply.Type != "error" || reply.Code != ErrCodeUnauthorized {
+		t.Errorf("got %s %q (%s), want error %q", reply.Type, reply.Code, reply.Error, ErrCodeUnauthorized)
+	}
+}
+
+func TestOpenJoinCannotTakeOverClientID(t *testing.T) {
+	url := closingTestServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(SignalMessage{Type: "join", Room: "open-room", ClientID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var info SignalMessage
+	if err := conn.ReadJSON(&info); err != nil || info.Type != "room_info" {
+		t.Fatalf("alice's join: got %+v (%v), want room_info", info, err)
+	}
+	alice := roomManager.GetRoom("open-room").GetPeer("alice")
+
+	// Anyone may claim the ID, so neither a resume nor a plain rejoin displaces alice
+	for _, resume := range []bool{true, false} {
+		reply := joinReply(t, url, SignalMessage{Type: "join", Room: "open-room", ClientID: "alice", Resume: resume})
+		if reply.Type != "error" || reply.Code != ErrCodeForbidden {
+			t.Errorf("resume %v: got %s %q (%s), want error %q", resume, reply.Type, reply.Code, reply.Error, ErrCodeForbidden)
+		}
+	}
+	if roomManager.GetRoom("open-room").GetPeer("alice") != alice {
+		t.Error("alice's connection was replaced")
+	}
+}
+
+func TestWebSocketChecksOrigin(t *testing.T) {
+	savedOrigins, savedAny := authConfig.allowedOrigins, authConfig.anyOrigin
+	t.Cleanup(func() { authConfig.allowedOrigins, authConfig.anyOrigin = savedOrigins, savedAny })
+	setAllowedOrigins("http://app.example.com")
+	url := closingTestServer(t)
+
+	dial := func(origin string) (*http.Response, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		return resp, err
+	}
+
+	resp, err := dial("http://evil.example.com")
+	if err == nil {
+		t.Fatal("connection from a disallowed origin was accepted")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("disallowed origin: got %v, want 403", resp)
+	}
+	var reply SignalMessage
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil || reply.Code != ErrCodeForbidden {
+		t.Errorf("disallowed origin: body %+v (%v), want code %q", reply, err, ErrCodeForbidden)
+	}
+
+	// Allowlisted browsers and non-browser clients without an Origin get through
+	for _, origin := range []string{"http://app.example.com", ""} {
+		if _, err := dial(origin); err != nil {
+			t.Errorf("origin %q: %v", origin, err)
+		}
+	}
+}
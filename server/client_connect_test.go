@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestConnectContextWaitsForMedia(t *testing.T) {
+	url := newTestServer(t)
+	c := client.NewClient("alice", url)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.ConnectContext(ctx, "connect-ctx"); err != nil {
+		t.Fatal(err)
+	}
+	if state := roomManager.GetRoom("connect-ctx").GetPeer("alice").PeerConnection.ConnectionState(); state != webrtc.PeerConnectionStateConnected {
+		t.Errorf("server side of the connection is %s after ConnectContext", state)
+	}
+	select {
+	case <-c.Done():
+		t.Fatalf("session ended: %v", c.Err())
+	default:
+	}
+
+	c.Disconnect()
+	<-c.Done()
+	if err := c.Err(); !errors.Is(err, client.ErrDisconnected) {
+		t.Errorf("Err() = %v after Disconnect, want ErrDisconnected", err)
+	}
+}
+
+func TestConnectContextErrors(t *testing.T) {
+	url := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A full room rejects the join
+	first := client.NewClient("first", url)
+	first.SetRoomOptions(client.RoomOptions{MaxPeers: 1})
+	if err := first.ConnectContext(ctx, "connect-full"); err != nil {
+		t.Fatal(err)
+	}
+	defer first.Disconnect()
+	second := client.NewClient("second", url)
+	err := second.ConnectContext(ctx, "connect-full")
+	var serverErr *client.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != ErrCodeRoomFull {
+		t.Errorf("joining a full room returned %v, want a room_full ServerError", err)
+	}
+	<-second.Done()
+	if second.Err() != err {
+		t.Errorf("Err() = %v, want the error ConnectContext returned", second.Err())
+	}
+
+	// A server that never answers the join
+	silent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer silent.Close()
+	c := client.NewClient("alice", "ws"+strings.TrimPrefix(silent.URL, "http"))
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer shortCancel()
+	err = c.ConnectContext(shortCtx, "silent")
+	if !errors.Is(err, client.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ConnectContext to a silent server returned %v, want ErrTimeout", err)
+	}
+	if c.IsConnected() || !errors.Is(c.Err(), client.ErrTimeout) {
+		t.Errorf("client still connected (%v) or Err() = %v after the timeout", c.IsConnected(), c.Err())
+	}
+}
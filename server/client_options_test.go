@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestClientOptions(t *testing.T) {
+	url := newTestServer(t)
+
+	var dials atomic.Int32
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	// Loopback only, without STUN
+	var se webrtc.SettingEngine
+	se.SetIncludeLoopbackCandidate(true)
+	se.SetIPFilter(func(ip net.IP) bool { return ip.IsLoopback() })
+	se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+
+	c := client.NewClient("alice", url,
+		client.WithICEServers(),
+		client.WithSettingEngine(se),
+		client.WithCodecs(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+			PayloadType:        109,
+		}),
+		client.WithDialer(&dialer),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.ConnectContext(ctx, "options"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	if dials.Load() != 1 {
+		t.Errorf("custom dialer used %d times, want 1", dials.Load())
+	}
+	pair, err := roomManager.GetRoom("options").GetPeer("alice").PeerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil || !net.ParseIP(pair.Remote.Address).IsLoopback() {
+		t.Errorf("selected candidate pair %v (%v), want the client's loopback candidate", pair, err)
+	}
+}
+
+func TestClientHeaderReachesHandshake(t *testing.T) {
+	var mu sync.Mutex
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		header = r.Header.Clone()
+		mu.Unlock()
+		handleWebSocket(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := client.NewClient("alice", "ws"+strings.TrimPrefix(srv.URL, "http"),
+		client.WithICEServers(),
+		client.WithHeader("Authorization", "Bearer proxy-secret"),
+		client.WithHeader("X-Forwarded-For", "10.0.0.1"),
+		client.WithHeader("X-Forwarded-For", "10.0.0.2"),
+	)
+	if err := c.Connect("options-header"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := header.Get("Authorization"); got != "Bearer proxy-secret" {
+		t.Errorf("server saw Authorization %q", got)
+	}
+	if got := header.Values("X-Forwarded-For"); !slices.Equal(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("server saw X-Forwarded-For %q, want both values", got)
+	}
+	// The WebSocket handshake headers are still the dialer's own
+	if header.Get("Sec-WebSocket-Key") == "" || header.Get("Upgrade") != "websocket" {
+		t.Errorf("handshake headers missing: %v", header)
+	}
+}
+
+func TestClientTLSConfigOverridesDialer(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(srv.Close)
+	url := "wss" + strings.TrimPrefix(srv.URL, "https")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	trusting := &tls.Config{RootCAs: roots}
+	untrusting := &tls.Config{RootCAs: x509.NewCertPool()}
+
+	// dialer returns a dialer with the given TLS config that counts its dials
+	dialer := func(config *tls.Config, dials *atomic.Int32) *websocket.Dialer {
+		return &websocket.Dialer{
+			HandshakeTimeout: 5 * time.Second,
+			TLSClientConfig:  config,
+			NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dials.Add(1)
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}
+	}
+
+	for i, tc := range []struct {
+		name       string
+		opts       func(dials *atomic.Int32) []client.Option
+		wantErr    bool
+		wantDialer bool
+	}{
+		{"no TLS config", func(*atomic.Int32) []client.Option { return nil }, true, false},
+		{"TLS config", func(*atomic.Int32) []client.Option {
+			return []client.Option{client.WithTLSConfig(trusting)}
+		}, false, false},
+		{"dialer's TLS config", func(dials *atomic.Int32) []client.Option {
+			return []client.Option{client.WithDialer(dialer(trusting, dials))}
+		}, false, true},
+		{"TLS config over the dialer's", func(dials *atomic.Int32) []client.Option {
+			return []client.Option{client.WithDialer(dialer(untrusting, dials)), client.WithTLSConfig(trusting)}
+		}, false, true},
+		{"TLS config over the dialer's, given first", func(dials *atomic.Int32) []client.Option {
+			return []client.Option{client.WithTLSConfig(trusting), client.WithDialer(dialer(untrusting, dials))}
+		}, false, true},
+		{"untrusting TLS config over a trusting dialer", func(dials *atomic.Int32) []client.Option {
+			return []client.Option{client.WithDialer(dialer(trusting, dials)), client.WithTLSConfig(untrusting)}
+		}, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var dials atomic.Int32
+			c := client.NewClient("alice", url, append(tc.opts(&dials), client.WithICEServers())...)
+			err := c.Connect(fmt.Sprintf("options-tls-%d", i))
+			if err == nil {
+				defer c.Disconnect()
+			}
+			if tc.wantErr != (err != nil) {
+				t.Errorf("Connect returned %v, want an error: %v", err, tc.wantErr)
+			}
+			if used := dials.Load() > 0; used != tc.wantDialer {
+				t.Errorf("custom dialer used %d times", dials.Load())
+			}
+		})
+	}
+}
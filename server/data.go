@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"example.com/agent_bridge/pkg/relay"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// defaultMaxDataBytes is the default payload limit of a data message over signaling
+	defaultMaxDataBytes = 1 << 20
+	// defaultMaxChannelBytes is the default payload limit of a message over the data channel
+	defaultMaxChannelBytes = 16 << 20
+	// defaultMaxBufferedBytes is the default amount of data queued for a peer's data
+	// channel beyond which messages to the peer are refused
+	defaultMaxBufferedBytes = 32 << 20
+)
+
+// dataConfig holds application data settings, set from flags in main
+var dataConfig = struct {
+	maxBytes        int // Largest payload of a data message, JSON or binary
+	maxChannelBytes int // Largest payload of a message over the data channel
+	maxBuffered     int // Most data queued for a peer's data channel before messages to it are refused
+}{maxBytes: defaultMaxDataBytes, maxChannelBytes: defaultMaxChannelBytes, maxBuffered: defaultMaxBufferedBytes}
+
+// maxSignalBytes bounds a single signaling message: the largest data message,
+// with its binary payload base64-encoded, plus room for the other fields
+func maxSignalBytes() int64 {
+	return int64(dataConfig.maxBytes)*4/3 + 64<<10
+}
+
+// handleData relays application data to one peer of the room, or to every other peer
+// The server only checks the envelope; topics and payloads are up to the clients
+func handleData(peer *Peer, msg SignalMessage) {
+	switch {
+	case msg.Topic == "":
+		rejectData(peer, ErrCodeBadRequest, "data message has no topic")
+		return
+	case len(msg.Payload) > 0 && len(msg.Binary) > 0:
+		rejectData(peer, ErrCodeBadRequest, "data message has both a JSON and a binary payload")
+		return
+	case len(msg.Payload)+len(msg.Binary) > dataConfig.maxBytes:
+		rejectData(peer, ErrCodeDataTooLarge, "data payload of %d bytes exceeds the limit of %d", len(msg.Payload)+len(msg.Binary), dataConfig.maxBytes)
+		return
+	}
+
+	h := relay.Header{Topic: msg.Topic, TargetID: msg.TargetID, JSON: len(msg.Payload) > 0}
+	payload := []byte(msg.Payload)
+	if !h.JSON {
+		payload = msg.Binary
+	}
+	routeData(peer, h, payload)
+}
+
+// rejectData tells a peer why its data was not relayed
+func rejectData(peer *Peer, code, format string, args ...any) {
+	log.Printf("Data from %s rejected: "+format, append([]any{peer.ID}, args...)...)
+	peer.SendMessage(SignalMessage{Type: "error", Code: code, Error: fmt.Sprintf(format, args...)})
+}
+
+// routeData delivers a message from a peer to its target, or to every other peer
+// The sender is told about every peer that did not get the message
+func routeData(from *Peer, h relay.Header, payload []byte) {
+	h.From = from.ID
+	if h.TargetID == "" {
+		for _, other := range from.Room.GetOtherPeers(from.ID) {
+			if err := deliverData(other, h, payload); err != nil {
+				rejectData(from, err.code, "peer %s %s", other.ID, err.reason)
+			}
+		}
+		return
+	}
+	target := from.Room.GetPeer(h.TargetID)
+	if target == nil {
+		rejectData(from, ErrCodePeerNotFound, "peer %s is not in the room", h.TargetID)
+		return
+	}
+	if err := deliverData(target, h, payload); err != nil {
+		rejectData(from, err.code, "peer %s %s", target.ID, err.reason)
+	}
+}
+
+// deliveryError is why a peer did not get a message
+type deliveryError struct {
+	code   string
+	reason string // Follows the peer ID, e.g. "has no data channel"
+}
+
+// deliverData sends a message over the peer's data channel, or over signaling if the
+// channel is not open and the message fits
+// A peer whose channel already has too much data queued is skipped rather than
+// buffered for without bound. Returns nil once the message is sent.
+func deliverData(peer *Peer, h relay.Header, payload []byte) *deliveryError {
+	peer.relayMu.Lock()
+	defer peer.relayMu.Unlock()
+	if peer.relay != nil && peer.relay.ReadyState() == webrtc.DataChannelStateOpen {
+		if buffered := peer.relay.BufferedAmount(); buffered+uint64(len(payload)) > peer.relayCap {
+			return &deliveryError{ErrCodeDataBacklogged, fmt.Sprintf("has %d bytes of data queued and cannot take %d more", buffered, len(payload))}
+		}
+		frames, err := relay.Frames(h, payload)
+		if err != nil {
+			log.Printf("Failed to frame data for %s: %v", peer.ID, err)
+			return &deliveryError{ErrCodeBadRequest, "cannot be sent this message"}
+		}
+		for _, frame := range frames {
+			if err := peer.relay.Send(frame); err != nil {
+				log.Printf("Failed to send data to %s: %v", peer.ID, err)
+				return &deliveryError{ErrCodeDataBacklogged, "could not be sent the message over its data channel"}
+			}
+		}
+		return nil
+	}
+
+	if len(payload) > dataConfig.maxBytes {
+		return &deliveryError{ErrCodeDataTooLarge, fmt.Sprintf("has no data channel and %d bytes exceed the signaling limit", len(payload))}
+	}
+	msg := SignalMessage{Type: "data", ClientID: h.From, TargetID: h.TargetID, Topic: h.Topic}
+	if h.JSON {
+		msg.Payload = payload
+	} else {
+		msg.Binary = payload
+	}
+	peer.SendMessage(msg)
+	return nil
+}
+
+// openRelay creates the peer's data channel; the client creates its end with the
+// same ID, so no in-band negotiation is needed
+func openRelay(peer *Peer) error {
+	negotiated := true
+	id := uint16(relay.ChannelID)
+	dc, err := peer.PeerConnection.CreateDataChannel(relay.Label, &webrtc.DataChannelInit{
+		Negotiated: &negotiated,
+		ID:         &id,
+	})
+	if err != nil {
+		return err
+	}
+	peer.relayMu.Lock()
+	peer.relay = dc
+	peer.relayCap = uint64(dataConfig.maxBuffered)
+	peer.relayMu.Unlock()
+	peer.relayRecv = relay.NewAssembler(dataConfig.maxChannelBytes)
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		m, err := peer.relayRecv.Add(msg.Data)
+		switch {
+		case errors.Is(err, relay.ErrTooLarge):
+			rejectData(peer, ErrCodeDataTooLarge, "data payload of %d bytes exceeds the limit of %d", m.Size, dataConfig.maxChannelBytes)
+		case errors.Is(err, relay.ErrTooMany):
+			rejectData(peer, ErrCodeBadRequest, "too many data messages in flight")
+		case err != nil:
+			rejectData(peer, ErrCodeBadRequest, "malformed data frame")
+		case m == nil:
+			// More frames to come
+		case m.Topic == "":
+			rejectData(peer, ErrCodeBadRequest, "data message has no topic")
+		case peer.Room != nil:
+			routeData(peer, m.Header, m.Payload)
+		}
+	})
+	return nil
+}
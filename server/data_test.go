@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestDataMessagesTargetedAndBroadcast(t *testing.T) {
+	// Limits are taken when peers join
+	dataConfig.maxBytes, dataConfig.maxChannelBytes = 16, 16
+	t.Cleanup(func() { dataConfig.maxBytes, dataConfig.maxChannelBytes = defaultMaxDataBytes, defaultMaxChannelBytes })
+	_, url := adminTestServer(t)
+	alice, aliceEvents := joinWatched(t, url, "data", "alice")
+	_, bobEvents := joinWatched(t, url, "data", "bob")
+	_, carolEvents := joinWatched(t, url, "data", "carol")
+
+	if err := alice.SendData("bob", "caption", map[string]string{"text": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendBinary("", client.ScreenshotTopic, []byte{0xff, 0xd8}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "bob to get both messages", bobEvents.has(func() bool { return len(bobEvents.data) == 2 }))
+	waitFor(t, 5*time.Second, "carol to get the broadcast", carolEvents.has(func() bool { return len(carolEvents.data) == 1 }))
+
+	bobEvents.mu.Lock()
+	caption, shots := bobEvents.data[0], bobEvents.shots
+	bobEvents.mu.Unlock()
+	var payload struct{ Text string }
+	if err := json.Unmarshal(caption.Payload, &payload); err != nil || caption.From != "alice" || caption.Topic != "caption" || caption.Broadcast || payload.Text != "hello" {
+		t.Errorf("unexpected targeted message %+v (%v)", caption, err)
+	}
+	if len(shots) != 1 || shots[0] != "alice:/9g=" {
+		t.Errorf("screenshot callback got %q, want alice's JPEG bytes as base64", shots)
+	}
+	carolEvents.mu.Lock()
+	if shot := carolEvents.data[0]; !shot.Broadcast || string(shot.Binary) != "\xff\xd8" {
+		t.Errorf("unexpected broadcast %+v", shot)
+	}
+	carolEvents.mu.Unlock()
+
+	// Oversized payloads and unknown targets are refused; the sender stays connected
+	alice.SendBinary("bob", "blob", make([]byte, 17))
+	alice.SendData("dave", "caption", "hi")
+	alice.SendData("bob", "", "no topic")
+	waitFor(t, 5*time.Second, "alice to get three errors", aliceEvents.has(func() bool { return len(aliceEvents.errCodes) == 3 }))
+	want := []string{ErrCodeDataTooLarge, ErrCodePeerNotFound, ErrCodeBadRequest}
+	aliceEvents.mu.Lock()
+	for i, code := range want {
+		if aliceEvents.errCodes[i] != code {
+			t.Errorf("error %d is %s, want %s", i, aliceEvents.errCodes[i], code)
+		}
+	}
+	aliceEvents.mu.Unlock()
+	bobEvents.mu.Lock()
+	if n := len(bobEvents.data); n != 2 {
+		t.Errorf("bob got %d messages, want only the first two", n)
+	}
+	bobEvents.mu.Unlock()
+	if !alice.IsConnected() || roomManager.GetRoom("data").GetPeer("alice") == nil {
+		t.Error("alice was disconnected")
+	}
+}
+
+func TestDataChannelRelaysLargePayloads(t *testing.T) {
+	// Far under the payload sent, so it can only come over the channels
+	dataConfig.maxBytes, dataConfig.maxChannelBytes = 1024, 1<<20
+	t.Cleanup(func() { dataConfig.maxBytes, dataConfig.maxChannelBytes = defaultMaxDataBytes, defaultMaxChannelBytes })
+	_, url := adminTestServer(t)
+	alice, aliceEvents := joinWatched(t, url, "relay", "alice")
+	bob, bobEvents := joinWatched(t, url, "relay", "bob")
+	for _, c := range []*client.Client{alice, bob} {
+		waitFor(t, 10*time.Second, c.ID+"'s data channel to open", c.DataChannelOpen)
+	}
+
+	file := make([]byte, 300<<10)
+	for i := range file {
+		file[i] = byte(i * 7)
+	}
+	if err := alice.SendBinary("bob", "file", file); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendData("", "caption", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10*time.Second, "bob to get both messages", bobEvents.has(func() bool { return len(bobEvents.data) == 2 }))
+
+	bobEvents.mu.Lock()
+	got, caption := bobEvents.data[0], bobEvents.data[1]
+	bobEvents.mu.Unlock()
+	if got.From != "alice" || got.Topic != "file" || got.Broadcast || !bytes.Equal(got.Binary, file) {
+		t.Errorf("file arrived as %q from %q (%d bytes, broadcast %v)", got.Topic, got.From, len(got.Binary), got.Broadcast)
+	}
+	if string(caption.Payload) != `"hi"` || !caption.Broadcast {
+		t.Errorf("unexpected caption %+v", caption)
+	}
+
+	// The channel limit applies before anything is relayed
+	if err := alice.SendBinary("bob", "file", make([]byte, 2<<20)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10*time.Second, "alice to be refused", aliceEvents.has(func() bool {
+		return len(aliceEvents.errCodes) == 1 && aliceEvents.errCodes[0] == ErrCodeDataTooLarge
+	}))
+	if err := alice.SendData("bob", "caption", "after"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "the channel to keep working", bobEvents.has(func() bool { return len(bobEvents.data) == 3 }))
+}
+
+func TestDataReportsSkippedPeers(t *testing.T) {
+	dataConfig.maxBytes, dataConfig.maxChannelBytes, dataConfig.maxBuffered = 64, 1<<20, 4<<10
+	t.Cleanup(func() {
+		dataConfig.maxBytes, dataConfig.maxChannelBytes, dataConfig.maxBuffered = defaultMaxDataBytes, defaultMaxChannelBytes, defaultMaxBufferedBytes
+	})
+	_, url := adminTestServer(t)
+	alice, aliceEvents := joinWatched(t, url, "skipped", "alice")
+	bob, bobEvents := joinWatched(t, url, "skipped", "bob")
+	for _, c := range []*client.Client{alice, bob} {
+		waitFor(t, 10*time.Second, c.ID+"'s data channel to open", c.DataChannelOpen)
+	}
+
+	// Carol never answers the server's offer, so she has no data channel
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(SignalMessage{Type: "join", Room: "skipped", ClientID: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "carol to join", func() bool { return roomManager.GetRoom("skipped").GetPeer("carol") != nil })
+
+	errorAt := func(i int) (string, string) {
+		aliceEvents.mu.Lock()
+		defer aliceEvents.mu.Unlock()
+		return aliceEvents.errCodes[i], aliceEvents.errors[i]
+	}
+
+	// A broadcast too large for signaling reaches bob, and alice learns carol missed it
+	if err := alice.SendBinary("", "file", make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "bob to get the broadcast", bobEvents.has(func() bool { return len(bobEvents.data) == 1 }))
+	waitFor(t, 5*time.Second, "alice to hear about carol", aliceEvents.has(func() bool { return len(aliceEvents.errCodes) == 1 }))
+	if code, message := errorAt(0); code != ErrCodeDataTooLarge || !strings.Contains(message, "carol") {
+		t.Errorf("got %s: %q, want %s for carol", code, message, ErrCodeDataTooLarge)
+	}
+
+	// More than bob's channel may have queued is refused rather than buffered
+	if err := alice.SendBinary("bob", "file", make([]byte, 8<<10)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "alice to be refused", aliceEvents.has(func() bool { return len(aliceEvents.errCodes) == 2 }))
+	if code, message := errorAt(1); code != ErrCodeDataBacklogged || !strings.Contains(message, "bob") {
+		t.Errorf("got %s: %q, want %s for bob", code, message, ErrCodeDataBacklogged)
+	}
+	if err := alice.SendData("bob", "caption", "after"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "bob to get later messages", bobEvents.has(func() bool { return len(bobEvents.data) == 2 }))
+}
+
+func TestSendDataBeforeConnect(t *testing.T) {
+	c := client.NewClient("early", newTestServer(t))
+	if err := c.SendData("", "caption", "hi"); err == nil {
+		t.Error("SendData before Connect succeeded")
+	}
+	if err := c.SendBinary("", "file", []byte{1}); err == nil {
+		t.Error("SendBinary before Connect succeeded")
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"time"
 
+	"example.com/agent_bridge/pkg/trickle"
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
 )
 
+// iceRecoveryTimeout is how long a failed connection gets to recover through an ICE restart
+const iceRecoveryTimeout = 15 * time.Second
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: checkOrigin,
+	Error:       upgradeError,
 }
 
 // handleWebSocket handles incoming WebSocket connections
@@ -21,6 +29,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
+	conn.SetReadLimit(maxSignalBytes())
 
 	var peer *Peer
 
@@ -29,15 +38,20 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		if err := conn.ReadJSON(&msg); err != nil {
 			log.Printf("WebSocket read error: %v", err)
 			if peer != nil {
-				handlePeerDisconnect(peer)
+				suspendPeer(peer)
 			}
 			return
 		}
 
 		log.Printf("Received message type: %s from %s", msg.Type, msg.ClientID)
+		countMessage(msg.Type)
 
 		switch msg.Type {
 		case "join":
+			if peer != nil {
+				log.Printf("Ignoring repeated join from %s", peer.ID)
+				continue
+			}
 			peer = handleJoin(conn, msg)
 			if peer == nil {
 				return
@@ -58,17 +72,64 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 				handleCandidate(peer, msg)
 			}
 
+		case "end_of_candidates":
+			if peer != nil {
+				handleCandidate(peer, SignalMessage{})
+			}
+
+		case "ice_restart":
+			if peer != nil {
+				log.Printf("Peer %s requested an ICE restart", peer.ID)
+				peer.negotiator.RestartICE()
+			}
+
 		case "screenshot":
 			if peer != nil {
 				handleScreenshot(peer, msg)
 			}
+
+		case "data":
+			if peer != nil {
+				handleData(peer, msg)
+			}
+
+		case "start_recording", "stop_recording":
+			if peer != nil {
+				handleRecording(peer, msg)
+			}
+
+		case "leave":
+			// Leaving on purpose skips the resume grace period
+			if peer != nil {
+				log.Printf("Peer %s left", peer.ID)
+				handlePeerDisconnect(peer)
+			}
+			return
 		}
 	}
 }
 
 // handleJoin handles a peer joining a room
 func handleJoin(conn *websocket.Conn, msg SignalMessage) *Peer {
-	log.Printf("Client %s joining room %s", msg.ClientID, msg.Room)
+	claims, err := authorizeJoin(msg)
+	if err != nil {
+		log.Printf("Rejected join from %s to room %s: %v", msg.ClientID, msg.Room, err)
+		rejectJoin(conn, err)
+		return nil
+	}
+	msg.ClientID = claims.Identity
+	msg.Room = claims.Room
+
+	opts := joinRoomOptions(msg, claims)
+	if opts != nil {
+		if err := checkRoomMode(opts.Mode); err != nil {
+			log.Printf("Rejected join from %s to room %s: %v", msg.ClientID, msg.Room, err)
+			rejectJoin(conn, err)
+			return nil
+		}
+	}
+
+	log.Printf("Client %s joining room %s as %s", msg.ClientID, msg.Room, claims.Role)
 
 	pc, err := createPeerConnection()
 	if err != nil {
@@ -78,35 +139,60 @@ func handleJoin(conn *websocket.Conn, msg SignalMessage) *Peer {
 
 	peer := &Peer{
 		ID:             msg.ClientID,
+		Role:           claims.Role,
+		JoinedAt:       time.Now(),
 		Conn:           conn,
 		PeerConnection: pc,
 		LocalTracks:    make(map[string]*webrtc.TrackLocalStaticRTP),
+		Senders:        make(map[string][]*webrtc.RTPSender),
 	}
+	peer.negotiator = newNegotiator(peer)
 
-	room := roomManager.GetOrCreateRoom(msg.Room)
-
-	// Notify existing peers about new peer
-	room.BroadcastExcept(peer.ID, SignalMessage{
-		Type:     "peer_joined",
-		ClientID: peer.ID,
-	})
+	room, resumed, err := joinRoom(peer, msg.Room, opts, msg.Resume)
+	if err != nil {
+		log.Printf("Rejected join from %s to room %s: %v", peer.ID, msg.Room, err)
+		pc.Close()
+		rejectJoin(conn, err)
+		return nil
+	}
 
-	room.AddPeer(peer)
+	// Tell the new peer about the room, and existing peers about the new peer
+	info := room.Info()
+	peer.SendMessage(SignalMessage{Type: "room_info", Room: room.ID, RoomInfo: &info})
+	if rec := room.recording.Load(); rec != nil {
+		rec.addParticipant(peer)
+		peer.SendMessage(SignalMessage{Type: "recording", Recording: rec.info()})
+	}
+	if speaker := room.speakers.activeSpeaker(); speaker != nil {
+		peer.SendMessage(SignalMessage{Type: "active_speaker", ClientID: speaker.ID})
+	}
+	room.speakers.addPeer(peer)
+	if !resumed {
+		room.BroadcastExcept(peer.ID, SignalMessage{
+			Type:     "peer_joined",
+			ClientID: peer.ID,
+		})
+	}
 
 	// Set up ICE candidate handling
 	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		if candidate == nil {
+			peer.SendMessage(SignalMessage{Type: "end_of_candidates"})
 			return
 		}
-		peer.SendMessage(SignalMessage{
-			Type:      "candidate",
-			Candidate: candidate.ToJSON().Candidate,
-		})
+		peer.SendMessage(candidateMessage(trickle.CandidateInit(pc, candidate)))
 	})
 
 	// Handle incoming tracks (audio from this peer)
 	pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		log.Printf("Received track from %s: %s", peer.ID, remoteTrack.Codec().MimeType)
+		levelID := audioLevelExtensionID(receiver.GetParameters())
+
+		// MCU rooms mix the track instead of forwarding it
+		if room.mixer != nil {
+			go mixTrack(room, peer, remoteTrack, levelID)
+			return
+		}
 
 		// Create a local track for forwarding to other peers
 		localTrack, err := webrtc.NewTrackLocalStaticRTP(
@@ -130,16 +216,30 @@ func handleJoin(conn *websocket.Conn, msg SignalMessage) *Peer {
 
 		// Forward RTP packets from remote track to local track
 		go func() {
+			counters := newForwardCounters(room.ID, peer.ID)
 			buf := make([]byte, 1500)
 			for {
 				n, _, err := remoteTrack.Read(buf)
 				if err != nil {
+					if err != io.EOF {
+						counters.readErrors.Inc()
+					}
 					log.Printf("Track read error for %s: %v", peer.ID, err)
 					return
 				}
+				if peer.forceMuted.Load() {
+					continue
+				}
+				observeAudioLevel(room, peer, levelID, buf[:n])
+				// Record what the room hears
+				if rec := room.recording.Load(); rec != nil {
+					rec.writeRTP(peer, remoteTrack, buf[:n])
+				}
 				if _, err := localTrack.Write(buf[:n]); err != nil {
+					counters.writeErrors.Inc()
 					return
 				}
+				counters.add(n)
 			}
 		}()
 	})
@@ -147,20 +247,51 @@ func handleJoin(conn *websocket.Conn, msg SignalMessage) *Peer {
 	// Handle connection state changes
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		log.Printf("Peer %s connection state: %s", peer.ID, state.String())
-		if state == webrtc.PeerConnectionStateFailed ||
-			state == webrtc.PeerConnectionStateClosed ||
-			state == webrtc.PeerConnectionStateDisconnected {
-			handlePeerDisconnect(peer)
+		metricPeerConnectionStates.WithLabelValues(state.String()).Inc()
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			if peer.suspended.Load() {
+				return // No signaling for a restart; the peer resumes or its grace period ends
+			}
+			// Try fresh ICE credentials before giving up on the peer
+			peer.negotiator.RestartICE()
+			time.AfterFunc(iceRecoveryTimeout, func() {
+				if pc.ConnectionState() != webrtc.PeerConnectionStateConnected && !peer.suspended.Load() {
+					log.Printf("Peer %s did not recover after ICE restart", peer.ID)
+					handlePeerDisconnect(peer)
+					peer.Conn.Close()
+				}
+			})
+		case webrtc.PeerConnectionStateClosed:
+			if !peer.suspended.Load() {
+				handlePeerDisconnect(peer)
+			}
 		}
+		// Disconnected is often transient; ICE recovers by itself or moves to failed
 	})
 
-	// Add tracks from existing peers to the new peer
-	for _, existingPeer := range room.GetOtherPeers(peer.ID) {
-		existingPeer.mu.Lock()
-		for _, track := range existingPeer.LocalTracks {
-			addTrackToPeer(peer, track, existingPeer.ID)
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		metricICEConnectionStates.WithLabelValues(state.String()).Inc()
+	})
+
+	if room.mixer != nil {
+		// Send the new peer the room's mix, without its own voice
+		track, sink, err := newMixedTrack(peer)
+		if err != nil {
+			log.Printf("Failed to create mixed track for %s: %v", peer.ID, err)
+		} else {
+			addTrackToPeer(peer, track, mixPublisherID)
+			room.mixer.addSink(peer, sink)
+		}
+	} else {
+		// Add tracks from existing peers to the new peer
+		for _, existingPeer := range room.GetOtherPeers(peer.ID) {
+			existingPeer.mu.Lock()
+			for _, track := range existingPeer.LocalTracks {
+				addTrackToPeer(peer, track, existingPeer.ID)
+			}
+			existingPeer.mu.Unlock()
 		}
-		existingPeer.mu.Unlock()
 	}
 
 	// Add a transceiver to receive audio from this peer
@@ -171,62 +302,74 @@ func handleJoin(conn *websocket.Conn, msg SignalMessage) *Peer {
 		log.Printf("Failed to add transceiver for %s: %v", peer.ID, err)
 	}
 
+	// Open the data relay before the first offer, so it is part of it
+	if err := openRelay(peer); err != nil {
+		log.Printf("Failed to open data channel for %s: %v", peer.ID, err)
+	}
+
 	// Send initial offer to establish connection
 	triggerNegotiation(peer)
 
 	return peer
 }
 
-// handleOffer handles an SDP offer from a peer
-func handleOffer(peer *Peer, msg SignalMessage) {
-	offer := webrtc.SessionDescription{
-		Type: webrtc.SDPTypeOffer,
-		SDP:  msg.SDP,
-	}
-
-	if err := peer.PeerConnection.SetRemoteDescription(offer); err != nil {
-		log.Printf("Failed to set remote description for %s: %v", peer.ID, err)
-		return
-	}
+// joinRoom adds a peer to a room, creating the room if needed
+// A rejoin with the same ID replaces the old connection, e.g. after a network change,
+// but only when join tokens vouch for the ID.
+// With resume, the new connection quietly takes the old one's place, and resumed is true.
+func joinRoom(peer *Peer, roomID string, opts *RoomOptions, resume bool) (*Room, bool, error) {
+	for {
+		room := roomManager.OpenRoom(roomID, opts)
 
-	answer, err := peer.PeerConnection.CreateAnswer(nil)
-	if err != nil {
-		log.Printf("Failed to create answer for %s: %v", peer.ID, err)
-		return
-	}
+		old := room.GetPeer(peer.ID)
+		if old != nil && len(authConfig.secret) == 0 {
+			// Without tokens anyone may claim the ID, so it cannot take over a connection
+			return nil, false, &joinError{ErrCodeForbidden, fmt.Sprintf("client ID %s is already in room %s", peer.ID, roomID)}
+		}
+		if old != nil && resume && room.ReplacePeer(old, peer) {
+			log.Printf("Client %s resumed in room %s", peer.ID, room.ID)
+			releasePeer(old)
+			old.PeerConnection.Close()
+			old.Conn.Close()
+			return room, true, nil
+		}
+		if old != nil {
+			log.Printf("Client %s rejoined room %s - replacing previous connection", peer.ID, room.ID)
+			old.SendMessage(SignalMessage{
+				Type:  "error",
+				Code:  ErrCodeReplaced,
+				Error: "another connection joined with the same client ID",
+			})
+			handlePeerDisconnect(old)
+			old.Conn.Close()
+		}
 
-	if err := peer.PeerConnection.SetLocalDescription(answer); err != nil {
-		log.Printf("Failed to set local description for %s: %v", peer.ID, err)
-		return
+		err := room.AddPeer(peer)
+		switch {
+		case errors.Is(err, errRoomClosed):
+			// Closed between lookup and join (e.g. it just went idle); open a fresh one
+			continue
+		case errors.Is(err, errRoomFull):
+			return nil, false, &joinError{ErrCodeRoomFull, fmt.Sprintf("room %s is full", roomID)}
+		}
+		return room, false, err
 	}
+}
 
-	peer.SendMessage(SignalMessage{
-		Type: "answer",
-		SDP:  answer.SDP,
-	})
+// handleOffer handles an SDP offer from a peer
+func handleOffer(peer *Peer, msg SignalMessage) {
+	peer.negotiator.HandleOffer(msg.SDP)
 }
 
 // handleAnswer handles an SDP answer from a peer
 func handleAnswer(peer *Peer, msg SignalMessage) {
-	answer := webrtc.SessionDescription{
-		Type: webrtc.SDPTypeAnswer,
-		SDP:  msg.SDP,
-	}
-
-	if err := peer.PeerConnection.SetRemoteDescription(answer); err != nil {
-		log.Printf("Failed to set remote description for %s: %v", peer.ID, err)
-	}
+	peer.negotiator.HandleAnswer(msg.SDP)
 }
 
 // handleCandidate handles an ICE candidate from a peer
+// Candidates that arrive before the matching remote description are buffered
 func handleCandidate(peer *Peer, msg SignalMessage) {
-	candidate := webrtc.ICECandidateInit{
-		Candidate: msg.Candidate,
-	}
-
-	if err := peer.PeerConnection.AddICECandidate(candidate); err != nil {
-		log.Printf("Failed to add ICE candidate for %s: %v", peer.ID, err)
-	}
+	peer.negotiator.HandleCandidate(msg.candidateInit())
 }
 
 // handleScreenshot handles forwarding a screenshot to a target peer
@@ -253,9 +396,10 @@ func handleScreenshot(peer *Peer, msg SignalMessage) {
 }
 
 // handlePeerDisconnect handles cleanup when a peer disconnects
+// Safe to call more than once, and after the peer was replaced by a rejoin
 func handlePeerDisconnect(peer *Peer) {
-	if peer.Room != nil {
-		peer.Room.RemovePeer(peer.ID)
+	if peer.Room != nil && peer.Room.RemovePeer(peer) {
+		releasePeer(peer)
 		peer.Room.BroadcastExcept(peer.ID, SignalMessage{
 			Type:     "peer_left",
 			ClientID: peer.ID,
@@ -268,3 +412,18 @@ func handlePeerDisconnect(peer *Peer) {
 
 	log.Printf("Peer %s disconnected", peer.ID)
 }
+
+// releasePeer detaches a peer that is no longer in its room from the room's media
+func releasePeer(peer *Peer) {
+	if rec := peer.Room.recording.Load(); rec != nil {
+		rec.removeParticipant(peer)
+	}
+	if peer.Room.mixer != nil {
+		peer.Room.mixer.removePeer(peer)
+	}
+	peer.Room.speakers.removePeer(peer)
+	// Stop forwarding this peer's audio to the others
+	for _, otherPeer := range peer.Room.GetOtherPeers(peer.ID) {
+		removeTracksFromPeer(otherPeer, peer.ID)
+	}
+}
@@ -2,12 +2,66 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	// "server token ..." mints a join token and exits
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		runTokenCommand(os.Args[2:])
+		return
+	}
+
+	tokenSecret := flag.String("token-secret", os.Getenv("SFU_TOKEN_SECRET"), "Join token signing secret; empty disables join tokens (or SFU_TOKEN_SECRET env)")
+	origins := flag.String("allowed-origins", envOr("SFU_ALLOWED_ORIGINS", "http://localhost:3000,http://127.0.0.1:3000"), "Comma-separated browser origins allowed to connect, or * for any (or SFU_ALLOWED_ORIGINS env)")
+	openJoin := flag.Bool("insecure-open-join", false, "Without -token-secret, let anyone join any room under any client ID (for local development only)")
+	adminKey := flag.String("admin-key", os.Getenv("SFU_ADMIN_KEY"), "Bearer key for the /admin/ API (or SFU_ADMIN_KEY env); admin-role join tokens also work")
+	idleTTL := flag.Duration("room-idle-ttl", defaultRoomIdleTTL, "How long an empty room is kept before it is closed; 0 keeps rooms forever")
+	metrics := flag.Bool("metrics", true, "Serve Prometheus metrics on /metrics")
+	perPeerMetrics := flag.Bool("metrics-per-peer", false, "Also export forwarding counters per peer (one series per peer)")
+	maxPeers := flag.Int("room-max-peers", 0, "Default max peers per room; 0 for no limit (rooms may set their own)")
+	maxDataBytes := flag.Int("max-data-bytes", defaultMaxDataBytes, "Largest payload of a data message relayed between peers over signaling")
+	maxChannelBytes := flag.Int("max-channel-bytes", defaultMaxChannelBytes, "Largest payload of a message relayed between peers over data channels")
+	maxChannelBuffered := flag.Int("max-channel-buffered", defaultMaxBufferedBytes, "Most data queued for a peer's data channel before messages to it are refused")
+	resumeGrace := flag.Duration("resume-grace", defaultResumeGrace, "How long a peer that lost its connection keeps its place in the room for a resuming join; 0 removes it at once")
+	recordingsDir := flag.String("recordings-dir", os.Getenv("SFU_RECORDINGS_DIR"), "Directory for room recordings; empty disables recording (or SFU_RECORDINGS_DIR env)")
+	flag.Parse()
+
+	authConfig.secret = []byte(*tokenSecret)
+	authConfig.openJoin = *openJoin
+	authConfig.adminKey = []byte(*adminKey)
+	setAllowedOrigins(*origins)
+	roomManager.IdleTTL = *idleTTL
+	roomManager.MaxPeers = *maxPeers
+	metricsConfig.perPeer = *perPeerMetrics
+	recordingConfig.dir = *recordingsDir
+	dataConfig.maxBytes = *maxDataBytes
+	dataConfig.maxChannelBytes = *maxChannelBytes
+	dataConfig.maxBuffered = *maxChannelBuffered
+	resumeConfig.grace = *resumeGrace
+	if len(authConfig.secret) == 0 {
+		if !authConfig.openJoin {
+			log.Fatal("No -token-secret set: set one to require join tokens, or pass -insecure-open-join to let anyone join")
+		}
+		log.Println("Warning: -insecure-open-join set; join tokens are disabled and anyone can join any room")
+	}
+
 	http.HandleFunc("/ws", handleWebSocket)
+	if adminEnabled() {
+		registerAdminRoutes(http.DefaultServeMux)
+	} else {
+		log.Println("Admin API disabled: set -admin-key or -token-secret to enable it")
+	}
+
+	if *metrics {
+		observeRooms(roomManager)
+		http.Handle("/metrics", promhttp.Handler())
+	}
 
 	// Health check endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -23,3 +77,11 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// envOr returns the environment variable, or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
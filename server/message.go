@@ -1,5 +1,11 @@
 package main
 
+import (
+	"encoding/json"
+
+	"github.com/pion/webrtc/v4"
+)
+
 // SignalMessage represents a signaling message between client and server
 type SignalMessage struct {
 	Type      string `json:"type"`
@@ -8,5 +14,81 @@ type SignalMessage struct {
 	SDP       string `json:"sdp,omitempty"`
 	Candidate string `json:"candidate,omitempty"`
 	Data      string `json:"data,omitempty"`      // For screenshot base64 data
-	TargetID  string `json:"target_id,omitempty"` // Target peer for screenshot
+	TargetID  string `json:"target_id,omitempty"` // Target peer for screenshot and data; empty broadcasts data
+	Token     string `json:"token,omitempty"`     // Signed join token
+	Resume    bool   `json:"resume,omitempty"`    // In "join": take over this client ID's place after a dropped connection
+	Code      string `json:"code,omitempty"`      // Machine-readable error code
+	Error     string `json:"error,omitempty"`     // Human-readable error message
+
+	// Remaining ICE candidate fields; the m-line index is a pointer since 0 is valid
+	SDPMid           *string `json:"sdp_mid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdp_mline_index,omitempty"`
+	UsernameFragment *string `json:"username_fragment,omitempty"`
+
+	RoomOptions *RoomOptions `json:"room_options,omitempty"` // Metadata for a room created by this join
+	RoomInfo    *RoomInfo    `json:"room_info,omitempty"`    // Sent in "room_info" after joining
+	Text        string       `json:"text,omitempty"`         // Operator message in "system"
+	Muted       bool         `json:"muted,omitempty"`        // In "force_mute": whether ClientID is muted
+
+	Recording *RecordingInfo `json:"recording,omitempty"` // Sent in "recording" when recording starts or stops
+
+	// Application data in "data"; the payload is either JSON or binary (base64 in JSON)
+	Topic   string          `json:"topic,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Binary  []byte          `json:"binary,omitempty"`
+}
+
+// RoomInfo describes a room to its peers
+type RoomInfo struct {
+	ID             string `json:"id"`
+	Name           string `json:"name,omitempty"`
+	MaxPeers       int    `json:"max_peers,omitempty"`
+	DefaultPersona string `json:"default_persona,omitempty"`
+	Mode           string `json:"mode"`                 // "sfu" or "mcu"
+	CreatedAt      int64  `json:"created_at"`           // Unix seconds
+	ExpiresAt      int64  `json:"expires_at,omitempty"` // Unix seconds; unset without a max duration
+}
+
+// RecordingInfo is a room's recording state
+type RecordingInfo struct {
+	Active    bool   `json:"active"`
+	StartedAt int64  `json:"started_at,omitempty"` // Unix seconds
+	StartedBy string `json:"started_by,omitempty"` // Peer ID, or "admin"
+}
+
+// Error codes sent in "error" messages
+const (
+	ErrCodeBadRequest   = "bad_request"
+	ErrCodeUnauthorized = "unauthorized"
+	ErrCodeForbidden    = "forbidden"
+	ErrCodeReplaced     = "replaced"    // Another connection joined with the same ID
+	ErrCodeRoomFull     = "room_full"   // The room is at its max peer count
+	ErrCodeRoomClosed   = "room_closed" // The room was closed while the peer was in it
+	ErrCodeKicked       = "kicked"      // An operator removed the peer
+
+	ErrCodeRecordingUnavailable = "recording_unavailable" // The server has no recordings directory
+	ErrCodeDataTooLarge         = "data_too_large"        // A data payload is over the server's limit
+	ErrCodePeerNotFound         = "peer_not_found"        // A data message targets a peer not in the room
+	ErrCodeDataBacklogged       = "data_backlogged"       // A peer has too much data queued to take more
+)
+
+// candidateMessage wraps a local ICE candidate in a "candidate" message
+func candidateMessage(init webrtc.ICECandidateInit) SignalMessage {
+	return SignalMessage{
+		Type:             "candidate",
+		Candidate:        init.Candidate,
+		SDPMid:           init.SDPMid,
+		SDPMLineIndex:    init.SDPMLineIndex,
+		UsernameFragment: init.UsernameFragment,
+	}
+}
+
+// candidateInit extracts the ICE candidate carried by a "candidate" message
+func (m SignalMessage) candidateInit() webrtc.ICECandidateInit {
+	return webrtc.ICECandidateInit{
+		Candidate:        m.Candidate,
+		SDPMid:           m.SDPMid,
+		SDPMLineIndex:    m.SDPMLineIndex,
+		UsernameFragment: m.UsernameFragment,
+	}
 }
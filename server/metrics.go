@@ -0,0 +1,194 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsConfig holds metrics settings, set from flags in main
+var metricsConfig struct {
+	perPeer bool // Also export forwarding counters per peer; one series per peer, so off by default
+}
+
+// Series labelled by room are deleted when the room closes, and per-peer series when the
+// peer leaves, so label cardinality follows the live rooms and peers
+var (
+	metricForwardedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_rtp_forwarded_packets_total",
+		Help: "RTP packets received from publishers and forwarded, by room.",
+	}, []string{"room"})
+
+	metricForwardedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_rtp_forwarded_bytes_total",
+		Help: "RTP bytes received from publishers and forwarded, by room.",
+	}, []string{"room"})
+
+	metricPeerForwardedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_peer_rtp_forwarded_packets_total",
+		Help: "RTP packets forwarded from each publisher (with -metrics-per-peer).",
+	}, []string{"room", "peer"})
+
+	metricPeerForwardedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_peer_rtp_forwarded_bytes_total",
+		Help: "RTP bytes forwarded from each publisher (with -metrics-per-peer).",
+	}, []string{"room", "peer"})
+
+	metricTrackReadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_track_read_errors_total",
+		Help: "Errors reading RTP from a publisher's track, other than the track ending.",
+	}, []string{"room"})
+
+	metricTrackWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_track_write_errors_total",
+		Help: "Errors writing RTP to a forwarded track.",
+	}, []string{"room"})
+
+	metricNegotiations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_negotiations_total",
+		Help: "Completed SDP exchanges: server offers answered by the client, and client offers answered by the server.",
+	}, []string{"initiator"})
+
+	metricNegotiationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_negotiation_failures_total",
+		Help: "Failed or abandoned negotiation steps, by step.",
+	}, []string{"step"})
+
+	metricICERestarts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sfu_ice_restarts_total",
+		Help: "Offers sent with ICE restart.",
+	})
+
+	metricMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_websocket_messages_total",
+		Help: "Signaling messages received, by type.",
+	}, []string{"type"})
+
+	metricPeerConnectionStates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_peer_connection_state_transitions_total",
+		Help: "PeerConnection state transitions, by new state.",
+	}, []string{"state"})
+
+	metricICEConnectionStates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sfu_ice_connection_state_transitions_total",
+		Help: "ICE connection state transitions, by new state.",
+	}, []string{"state"})
+)
+
+// roomCollector reports room, peer and track gauges from the RoomManager at scrape time
+type roomCollector struct {
+	rm                   *RoomManager
+	rooms, peers, tracks *prometheus.Desc
+}
+
+func newRoomCollector(rm *RoomManager) *roomCollector {
+	return &roomCollector{
+		rm:     rm,
+		rooms:  prometheus.NewDesc("sfu_rooms", "Open rooms.", nil, nil),
+		peers:  prometheus.NewDesc("sfu_peers", "Peers in each room.", []string{"room"}, nil),
+		tracks: prometheus.NewDesc("sfu_tracks", "Tracks published in each room.", []string{"room"}, nil),
+	}
+}
+
+func (c *roomCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.rooms
+	ch <- c.peers
+	ch <- c.tracks
+}
+
+func (c *roomCollector) Collect(ch chan<- prometheus.Metric) {
+	rooms := c.rm.ListRooms()
+	ch <- prometheus.MustNewConstMetric(c.rooms, prometheus.GaugeValue, float64(len(rooms)))
+	for _, room := range rooms {
+		peers := room.ListPeers()
+		tracks := 0
+		for _, peer := range peers {
+			peer.mu.Lock()
+			tracks += len(peer.LocalTracks)
+			peer.mu.Unlock()
+		}
+		ch <- prometheus.MustNewConstMetric(c.peers, prometheus.GaugeValue, float64(len(peers)), room.ID)
+		ch <- prometheus.MustNewConstMetric(c.tracks, prometheus.GaugeValue, float64(tracks), room.ID)
+	}
+}
+
+// Negotiation initiators and failure steps used as label values
+const (
+	negotiationServer = "server"
+	negotiationClient = "client"
+
+	stepCreateOffer  = "create_offer"
+	stepCreateAnswer = "create_answer"
+	stepSetLocal     = "set_local_description"
+	stepSetRemote    = "set_remote_description"
+	stepAddCandidate = "add_candidate"
+	stepGlare        = "glare"
+	stepUnexpected   = "unexpected_answer"
+)
+
+// knownMessageTypes bounds the type label of sfu_websocket_messages_total
+var knownMessageTypes = map[string]bool{
+	"join": true, "offer": true, "answer": true, "candidate": true,
+	"end_of_candidates": true, "ice_restart": true, "screenshot": true, "data": true,
+	"start_recording": true, "stop_recording": true, "leave": true,
+}
+
+// countMessage records a received signaling message; unknown types share one label
+func countMessage(msgType string) {
+	if !knownMessageTypes[msgType] {
+		msgType = "other"
+	}
+	metricMessages.WithLabelValues(msgType).Inc()
+}
+
+// forwardCounters counts one publisher's forwarded RTP
+// The series are resolved once, so counting after the room closed does not recreate them
+type forwardCounters struct {
+	packets, bytes          prometheus.Counter
+	readErrors, writeErrors prometheus.Counter
+	peerPackets, peerBytes  prometheus.Counter // nil unless per-peer metrics are on
+}
+
+func newForwardCounters(roomID, peerID string) *forwardCounters {
+	c := &forwardCounters{
+		packets:     metricForwardedPackets.WithLabelValues(roomID),
+		bytes:       metricForwardedBytes.WithLabelValues(roomID),
+		readErrors:  metricTrackReadErrors.WithLabelValues(roomID),
+		writeErrors: metricTrackWriteErrors.WithLabelValues(roomID),
+	}
+	if metricsConfig.perPeer {
+		c.peerPackets = metricPeerForwardedPackets.WithLabelValues(roomID, peerID)
+		c.peerBytes = metricPeerForwardedBytes.WithLabelValues(roomID, peerID)
+	}
+	return c
+}
+
+func (c *forwardCounters) add(n int) {
+	c.packets.Inc()
+	c.bytes.Add(float64(n))
+	if c.peerPackets != nil {
+		c.peerPackets.Inc()
+		c.peerBytes.Add(float64(n))
+	}
+}
+
+// observeRooms exports the room gauges and drops series of closed rooms and departed peers
+func observeRooms(rm *RoomManager) {
+	prometheus.MustRegister(newRoomCollector(rm))
+	rm.Subscribe(func(event RoomEvent) {
+		switch event.Type {
+		case PeerLeft:
+			labels := prometheus.Labels{"room": event.Room.ID, "peer": event.PeerID}
+			metricPeerForwardedPackets.Delete(labels)
+			metricPeerForwardedBytes.Delete(labels)
+		case RoomClosed:
+			labels := prometheus.Labels{"room": event.Room.ID}
+			for _, vec := range []*prometheus.MetricVec{
+				metricForwardedPackets.MetricVec, metricForwardedBytes.MetricVec,
+				metricPeerForwardedPackets.MetricVec, metricPeerForwardedBytes.MetricVec,
+				metricTrackReadErrors.MetricVec, metricTrackWriteErrors.MetricVec,
+			} {
+				vec.DeletePartialMatch(labels)
+			}
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetrics renders the default registry plus the room gauges in the text format
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	rooms := prometheus.NewRegistry()
+	rooms.MustRegister(newRoomCollector(roomManager))
+	handler := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, rooms}, promhttp.HandlerOpts{})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsReportForwarding(t *testing.T) {
+	url := newTestServer(t)
+	_, recorders := joinClients(t, url, "metrics", []string{"m-a", "m-b"})
+	for _, rec := range recorders {
+		waitFor(t, 20*time.Second, "tracks", func() bool { return rec.distinct() == 1 })
+	}
+
+	for _, want := range []string{
+		`sfu_peers{room="metrics"} 2`,
+		`sfu_tracks{room="metrics"} 2`,
+		`sfu_rtp_forwarded_packets_total{room="metrics"}`,
+		`sfu_rtp_forwarded_bytes_total{room="metrics"}`,
+		`sfu_websocket_messages_total{type="join"}`,
+		`sfu_negotiations_total{initiator="server"}`,
+		`sfu_peer_connection_state_transitions_total{state="connected"}`,
+		`sfu_ice_connection_state_transitions_total{state="connected"}`,
+	} {
+		waitFor(t, 5*time.Second, want, func() bool { return strings.Contains(scrapeMetrics(t), want) })
+	}
+
+	// Per-peer series are off by default
+	if strings.Contains(scrapeMetrics(t), "sfu_peer_rtp_forwarded_packets_total") {
+		t.Error("per-peer series exported without -metrics-per-peer")
+	}
+}
+
+func TestCountMessageBoundsTypes(t *testing.T) {
+	countMessage("made-up-type")
+	body := scrapeMetrics(t)
+	if strings.Contains(body, "made-up-type") || !strings.Contains(body, `sfu_websocket_messages_total{type="other"}`) {
+		t.Error("unknown message types should be counted as other")
+	}
+}
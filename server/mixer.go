@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// Room modes, chosen when the room is created
+const (
+	RoomModeSFU = "sfu" // Each peer receives every other peer's track as sent (default)
+	RoomModeMCU = "mcu" // The server mixes; each peer receives one track without its own voice
+)
+
+// checkRoomMode reports why a room of the given mode cannot be created; empty selects the default
+func checkRoomMode(mode string) error {
+	switch mode {
+	case "", RoomModeSFU:
+		return nil
+	case RoomModeMCU:
+		if mixCodec == nil {
+			return &joinError{ErrCodeBadRequest, "room mode mcu needs a server built with -tags opus"}
+		}
+		return nil
+	}
+	return &joinError{ErrCodeBadRequest, fmt.Sprintf("unknown room mode %q", mode)}
+}
+
+// opusDecoder decodes a publisher's Opus packets to 48 kHz mono
+type opusDecoder interface {
+	Decode(data []byte) ([]int16, error)
+}
+
+// opusEncoder encodes 20 ms frames of 48 kHz mono to Opus
+type opusEncoder interface {
+	Encode(pcm []int16) ([]byte, error)
+}
+
+// opusCodec creates the decoders and encoders of MCU rooms
+type opusCodec interface {
+	NewDecoder() (opusDecoder, error)
+	NewEncoder() (opusEncoder, error)
+}
+
+// mixCodec is set by mixer_opus.go, which links libopus through cgo and is only
+// built with -tags opus; without it the server is pure Go and has no MCU rooms
+var mixCodec opusCodec
+
+const (
+	mixSampleRate   = 48000
+	mixFrameSamples = 960 // 20 ms of mono audio at 48 kHz, the unit the mixer works in
+	mixFrameTime    = 20 * time.Millisecond
+
+	// A source starts contributing once this much audio is queued, which absorbs jitter,
+	// and drops its oldest audio beyond the max, which bounds the added latency
+	mixPrimeSamples = 2 * mixFrameSamples
+	mixMaxSamples   = 10 * mixFrameSamples
+
+	// mixStreamID is the stream of the mixed track; clients see it as peer "mix"
+	mixStreamID = "stream-mix"
+	// mixPublisherID keys the mixed track in Peer.Senders; no peer has an empty ID
+	mixPublisherID = ""
+)
+
+// mixer produces an N-minus-one mix for every peer of an MCU room
+//
+// Publishers' audio is decoded as it arrives and queued per source. Every 20 ms
+// the mixer takes one frame from each source, sums them, and sends each sink the
+// sum minus its own peer's frame, so nobody hears themselves.
+type mixer struct {
+	mu      sync.Mutex
+	sources map[*Peer]*mixSource
+	sinks   map[*Peer]*mixSink
+	running bool
+
+	total []int32 // Sum of this tick's frames
+}
+
+// mixSource queues one publisher's decoded audio
+type mixSource struct {
+	queue  []int16
+	primed bool
+	frame  []int16 // This tick's frame; nil when the source is silent
+	buf    []int16
+}
+
+// mixSink receives the mix for one peer
+type mixSink struct {
+	out  []int16             // This tick's mix
+	send func([]int16) error // Encodes and sends a frame; called only by the mixer loop
+}
+
+func newMixer() *mixer {
+	return &mixer{
+		sources: make(map[*Peer]*mixSource),
+		sinks:   make(map[*Peer]*mixSink),
+		total:   make([]int32, mixFrameSamples),
+	}
+}
+
+// addSource registers a publisher; audio is queued with push
+func (m *mixer) addSource(peer *Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sources[peer] == nil {
+		m.sources[peer] = &mixSource{buf: make([]int16, mixFrameSamples)}
+	}
+}
+
+// push queues decoded 48 kHz mono audio from a publisher
+func (m *mixer) push(peer *Peer, pcm []int16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src := m.sources[peer]
+	if src == nil {
+		return
+	}
+	src.queue = append(src.queue, pcm...)
+	if over := len(src.queue) - mixMaxSamples; over > 0 {
+		src.queue = append(src.queue[:0], src.queue[over:]...)
+	}
+	if len(src.queue) >= mixPrimeSamples {
+		src.primed = true
+	}
+}
+
+// addSink registers a peer to receive the mix, starting the mixer if needed
+func (m *mixer) addSink(peer *Peer, sink *mixSink) {
+	sink.out = make([]int16, mixFrameSamples)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sinks[peer] = sink
+	if !m.running {
+		m.running = true
+		go m.run()
+	}
+}
+
+// removePeer drops a peer's source and sink
+func (m *mixer) removePeer(peer *Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sources, peer)
+	delete(m.sinks, peer)
+}
+
+// run mixes every 20 ms until no sinks are left
+func (m *mixer) run() {
+	ticker := time.NewTicker(mixFrameTime)
+	defer ticker.Stop()
+	for range ticker.C {
+		sinks := m.mix()
+		if sinks == nil {
+			return
+		}
+		for _, sink := range sinks {
+			if err := sink.send(sink.out); err != nil {
+				log.Printf("Failed to send mixed audio: %v", err)
+			}
+		}
+	}
+}
+
+// mix takes a frame from every source and computes each sink's output
+// Returns the sinks to send to, or nil (stopping the mixer) when there are none
+func (m *mixer) mix() []*mixSink {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sinks) == 0 {
+		m.running = false
+		return nil
+	}
+
+	clear(m.total)
+	for _, src := range m.sources {
+		src.frame = nil
+		if !src.primed {
+			continue
+		}
+		if len(src.queue) < mixFrameSamples {
+			// Underrun: stay silent until enough audio is queued again
+			src.primed = false
+			continue
+		}
+		copy(src.buf, src.queue)
+		src.queue = append(src.queue[:0], src.queue[mixFrameSamples:]...)
+		src.frame = src.buf
+		for i, s := range src.frame {
+			m.total[i] += int32(s)
+		}
+	}
+
+	sinks := make([]*mixSink, 0, len(m.sinks))
+	for peer, sink := range m.sinks {
+		var own []int16
+		if src := m.sources[peer]; src != nil {
+			own = src.frame
+		}
+		mixMinusOne(sink.out, m.total, own)
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// mixMinusOne writes total minus own (if any) to out, saturating at the int16 range
+func mixMinusOne(out []int16, total []int32, own []int16) {
+	for i, sum := range total {
+		if own != nil {
+			sum -= int32(own[i])
+		}
+		out[i] = int16(max(min(sum, 32767), -32768))
+	}
+}
+
+// newMixedTrack creates the track carrying a peer's mix and the sink that feeds it
+func newMixedTrack(peer *Peer) (*webrtc.TrackLocalStaticRTP, *mixSink, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: mixSampleRate, Channels: 2},
+		fmt.Sprintf("audio-mix-%s", peer.ID),
+		mixStreamID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	encoder, err := mixCodec.NewEncoder()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The track rewrites the SSRC and payload type for each subscriber
+	header := rtp.Header{Version: 2, SSRC: rand.Uint32()}
+
+	sink := &mixSink{send: func(pcm []int16) error {
+		data, err := encoder.Encode(pcm)
+		if err != nil {
+			return err
+		}
+		err = track.WriteRTP(&rtp.Packet{Header: header, Payload: data})
+		header.SequenceNumber++
+		header.Timestamp += mixFrameSamples
+		return err
+	}}
+	return track, sink, nil
+}
+
+// mixTrack decodes a publisher's track into the room's mixer until the track ends
+// levelID is the negotiated audio level extension, or 0
+func mixTrack(room *Room, peer *Peer, remoteTrack *webrtc.TrackRemote, levelID uint8) {
+	decoder, err := mixCodec.NewDecoder()
+	if err != nil {
+		log.Printf("Failed to create decoder for %s: %v", peer.ID, err)
+		return
+	}
+	if room.GetPeer(peer.ID) != peer {
+		return // Left before its track arrived
+	}
+	room.mixer.addSource(peer)
+
+	counters := newForwardCounters(room.ID, peer.ID)
+	buf := make([]byte, 1500)
+	var packet rtp.Packet
+	for {
+		n, _, err := remoteTrack.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				counters.readErrors.Inc()
+			}
+			log.Printf("Track read error for %s: %v", peer.ID, err)
+			return
+		}
+		if peer.forceMuted.Load() {
+			continue
+		}
+		observeAudioLevel(room, peer, levelID, buf[:n])
+		if rec := room.recording.Load(); rec != nil {
+			rec.writeRTP(peer, remoteTrack, buf[:n])
+		}
+
+		if err := packet.Unmarshal(buf[:n]); err != nil || len(packet.Payload) == 0 {
+			continue
+		}
+		pcm, err := decoder.Decode(packet.Payload)
+		if err != nil {
+			continue
+		}
+		room.mixer.push(peer, pcm)
+		counters.add(n)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build opus
+
+package main
+
+import "example.com/agent_bridge/pkg/audio"
+
+func init() {
+	mixCodec = libopusCodec{}
+}
+
+// libopusCodec is the MCU codec backed by libopus
+type libopusCodec struct{}
+
+func (libopusCodec) NewDecoder() (opusDecoder, error) {
+	decoder, err := audio.NewOpusDecoder(mixSampleRate, 1)
+	if err != nil {
+		return nil, err
+	}
+	return decoder, nil
+}
+
+func (libopusCodec) NewEncoder() (opusEncoder, error) {
+	encoder, err := audio.NewOpusEncoder(mixSampleRate, 1, mixFrameSamples)
+	if err != nil {
+		return nil, err
+	}
+	return encoder, nil
+}
@@ -0,0 +1,96 @@
+//go:build opus
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"example.com/agent_bridge/pkg/audio"
+)
+
+func TestMCURoomSendsOneMixedTrack(t *testing.T) {
+	url := newTestServer(t)
+	roomManager.OpenRoom("mcu", &RoomOptions{Mode: RoomModeMCU})
+
+	ids := []string{"mcu-a", "mcu-b", "mcu-c"}
+	_, recorders := joinClients(t, url, "mcu", ids)
+	for i, rec := range recorders {
+		waitFor(t, 20*time.Second, ids[i]+" to receive the mix", func() bool {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			return rec.from[client.MixPeerID] == 1
+		})
+	}
+
+	// Still one track each once everyone's audio is flowing
+	time.Sleep(500 * time.Millisecond)
+	for i, rec := range recorders {
+		if n := rec.distinct(); n != 1 {
+			t.Errorf("%s receives %d tracks, want only the mix", ids[i], n)
+		}
+	}
+	if info := roomManager.GetRoom("mcu").Info(); info.Mode != RoomModeMCU {
+		t.Errorf("room mode %q, want %q", info.Mode, RoomModeMCU)
+	}
+}
+
+// BenchmarkMCUTick measures the full server cost of one 20 ms tick of an MCU room:
+// decoding every publisher, mixing, and encoding every subscriber's mix. The time
+// per op divided by 20 ms is the share of one core the room uses.
+func BenchmarkMCUTick(b *testing.B) {
+	// A 440 Hz tone as a publisher would send it
+	encoder, err := audio.NewOpusEncoder(mixSampleRate, 1, mixFrameSamples)
+	if err != nil {
+		b.Fatal(err)
+	}
+	tone := make([]int16, mixFrameSamples)
+	for i := range tone {
+		tone[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/mixSampleRate))
+	}
+	packet, err := encoder.Encode(tone)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("peers=%d", n), func(b *testing.B) {
+			peers := make([]*Peer, n)
+			decoders := make([]*audio.OpusDecoder, n)
+			encoders := make([]*audio.OpusEncoder, n)
+			m := newMixer()
+			m.running = true
+			for i := range peers {
+				peers[i] = &Peer{ID: fmt.Sprint(i)}
+				decoders[i], _ = audio.NewOpusDecoder(mixSampleRate, 1)
+				encoders[i], _ = audio.NewOpusEncoder(mixSampleRate, 1, mixFrameSamples)
+				enc := encoders[i]
+				m.addSink(peers[i], &mixSink{send: func(pcm []int16) error {
+					_, err := enc.Encode(pcm)
+					return err
+				}})
+				m.addSource(peers[i])
+				m.push(peers[i], constantFrames(1, 0))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j, peer := range peers {
+					pcm, err := decoders[j].Decode(packet)
+					if err != nil {
+						b.Fatal(err)
+					}
+					m.push(peer, pcm)
+				}
+				for _, sink := range m.mix() {
+					if err := sink.send(sink.out); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
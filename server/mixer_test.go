@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// pcm8Codec stands in for libopus in untagged builds: a payload is the PCM itself,
+// one byte per sample (its high byte), so multiples of 256 pass through exactly
+type pcm8Codec struct{}
+
+func (pcm8Codec) NewDecoder() (opusDecoder, error) { return pcm8Codec{}, nil }
+func (pcm8Codec) NewEncoder() (opusEncoder, error) { return pcm8Codec{}, nil }
+
+func (pcm8Codec) Decode(data []byte) ([]int16, error) {
+	pcm := make([]int16, len(data))
+	for i, b := range data {
+		pcm[i] = int16(int8(b)) << 8
+	}
+	return pcm, nil
+}
+
+func (pcm8Codec) Encode(pcm []int16) ([]byte, error) {
+	data := make([]byte, len(pcm))
+	for i, s := range pcm {
+		data[i] = byte(s >> 8)
+	}
+	return data, nil
+}
+
+// constantFrames returns n 20 ms frames of a constant sample value
+func constantFrames(n int, value int16) []int16 {
+	pcm := make([]int16, n*mixFrameSamples)
+	for i := range pcm {
+		pcm[i] = value
+	}
+	return pcm
+}
+
+// testMixer returns a mixer whose ticks the test drives by calling mix
+func testMixer(peers ...*Peer) *mixer {
+	m := newMixer()
+	m.running = true // No background loop
+	for _, peer := range peers {
+		m.addSink(peer, &mixSink{})
+	}
+	return m
+}
+
+func TestMixMinusOneExcludesOwnVoice(t *testing.T) {
+	alice, bob, listener := &Peer{ID: "alice"}, &Peer{ID: "bob"}, &Peer{ID: "listener"}
+	m := testMixer(alice, bob, listener)
+	m.addSource(alice)
+	m.addSource(bob)
+
+	m.push(alice, constantFrames(2, 1000))
+	m.push(bob, constantFrames(2, 2000))
+	m.mix()
+	for peer, want := range map[*Peer]int16{alice: 2000, bob: 1000, listener: 3000} {
+		if got := m.sinks[peer].out[0]; got != want {
+			t.Errorf("%s hears %d, want %d", peer.ID, got, want)
+		}
+	}
+
+	m.mix() // Both play their second frame
+
+	// Bob runs dry and stays silent until enough of his audio is queued again
+	m.push(alice, constantFrames(1, 30000))
+	m.mix()
+	if got := m.sinks[alice].out[0]; got != 0 {
+		t.Errorf("alice hears %d while bob is silent, want silence", got)
+	}
+	m.push(alice, constantFrames(1, 30000))
+	m.push(bob, constantFrames(1, 30000))
+	m.mix()
+	if got := m.sinks[listener].out[0]; got != 30000 {
+		t.Errorf("listener hears %d while bob is unprimed, want alice alone (30000)", got)
+	}
+
+	// Loud sources saturate instead of wrapping
+	m.push(alice, constantFrames(1, 30000))
+	m.push(bob, constantFrames(1, 30000))
+	m.mix()
+	if got := m.sinks[listener].out[0]; got != math.MaxInt16 {
+		t.Errorf("listener hears %d, want the mix clipped to %d", got, math.MaxInt16)
+	}
+
+	// Departed peers neither contribute nor receive
+	m.removePeer(bob)
+	m.push(alice, constantFrames(1, 500))
+	if sinks := m.mix(); len(sinks) != 2 || m.sinks[listener].out[0] != 500 {
+		t.Errorf("after bob left: %d sinks, listener hears %d", len(sinks), m.sinks[listener].out[0])
+	}
+}
+
+func TestMCURoomMixesEveryoneElse(t *testing.T) {
+	saved := mixCodec
+	mixCodec = pcm8Codec{}
+	t.Cleanup(func() { mixCodec = saved })
+	url := closingTestServer(t)
+	roomManager.OpenRoom("mcu-pcm", &RoomOptions{Mode: RoomModeMCU})
+
+	done := make(chan struct{})
+	voices := map[string]int16{"mcu-a": 10 << 8, "mcu-b": 20 << 8, "mcu-c": 40 << 8}
+	heard := make(map[string]*atomic.Int32)
+	var clients []*client.Client
+	for id, voice := range voices {
+		level := &atomic.Int32{}
+		heard[id] = level
+		c := client.NewClient(id, url)
+		c.OnAudioReceived(func(peerID string, track *webrtc.TrackRemote) {
+			if peerID != client.MixPeerID {
+				t.Errorf("%s receives a track from %s, want only the mix", id, peerID)
+				return
+			}
+			for {
+				packet, _, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				if pcm, _ := (pcm8Codec{}).Decode(packet.Payload); len(pcm) > 0 {
+					level.Store(int32(pcm[0]))
+				}
+			}
+		})
+		if err := c.Connect("mcu-pcm"); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+
+		frame, _ := pcm8Codec{}.Encode(constantFrames(1, voice))
+		go func() {
+			ticker := time.NewTicker(mixFrameTime)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					c.WriteOpus(frame)
+				}
+			}
+		}()
+	}
+	t.Cleanup(func() {
+		close(done)
+		for _, c := range clients {
+			c.Disconnect()
+		}
+	})
+
+	// Each peer hears the sum of the others, decoded by mixTrack and encoded by newMixedTrack
+	for id, own := range voices {
+		want := int32(voices["mcu-a"] + voices["mcu-b"] + voices["mcu-c"] - own)
+		waitFor(t, 20*time.Second, id+" to hear everyone else", func() bool { return heard[id].Load() == want })
+	}
+}
+
+func TestJoinRejectsMCUWithoutCodec(t *testing.T) {
+	saved := mixCodec
+	mixCodec = nil
+	t.Cleanup(func() { mixCodec = saved })
+
+	url := newTestServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(SignalMessage{Type: "join", Room: "no-codec", ClientID: "a", RoomOptions: &RoomOptions{Mode: RoomModeMCU}})
+	var msg SignalMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" || msg.Code != ErrCodeBadRequest {
+		t.Errorf("got %+v, want a bad_request error", msg)
+	}
+	if roomManager.GetRoom("no-codec") != nil {
+		t.Error("MCU room created without a codec")
+	}
+}
+
+func TestJoinRejectsUnknownRoomMode(t *testing.T) {
+	url := newTestServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(SignalMessage{Type: "join", Room: "bad-mode", ClientID: "a", RoomOptions: &RoomOptions{Mode: "p2p"}})
+	var msg SignalMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "error" || msg.Code != ErrCodeBadRequest {
+		t.Errorf("got %+v, want a bad_request error", msg)
+	}
+	if roomManager.GetRoom("bad-mode") != nil {
+		t.Error("room created despite the invalid mode")
+	}
+}
+
+// BenchmarkMix measures summing and N-minus-one mixing alone, per 20 ms tick
+func BenchmarkMix(b *testing.B) {
+	for _, n := range []int{2, 4, 8, 16, 32} {
+		b.Run(fmt.Sprintf("peers=%d", n), func(b *testing.B) {
+			peers := make([]*Peer, n)
+			for i := range peers {
+				peers[i] = &Peer{ID: fmt.Sprint(i)}
+			}
+			m := testMixer(peers...)
+			frame := constantFrames(1, 100)
+			for _, peer := range peers {
+				m.addSource(peer)
+				m.push(peer, constantFrames(2, 100))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, peer := range peers {
+					m.push(peer, frame)
+				}
+				m.mix()
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"example.com/agent_bridge/pkg/trickle"
+	"github.com/pion/webrtc/v4"
+)
+
+// negotiator serializes SDP negotiation with one peer
+//
+// Only one server offer is in flight at a time. Changes requested meanwhile are
+// coalesced into a single follow-up offer, sent once the answer arrives. If the
+// client sends its own offer while ours is in flight (glare), the server is the
+// impolite side of perfect negotiation: it ignores that offer and the client rolls
+// back, answers ours and offers again. (Pion cannot roll back a local offer.)
+// An answer that cannot be applied is met with a new offer, since the client sends
+// no other answer.
+//
+// Remote candidates are buffered until the description they belong to is applied.
+type negotiator struct {
+	peer       *Peer
+	mu         sync.Mutex // Held across every signaling state change of the PeerConnection
+	inFlight   bool       // An offer was sent and its answer has not arrived
+	pending    bool       // Changes were requested while an offer was in flight
+	iceRestart bool       // The next offer restarts ICE
+	candidates trickle.Buffer
+}
+
+func newNegotiator(peer *Peer) *negotiator {
+	return &negotiator{peer: peer}
+}
+
+// Negotiate requests an offer reflecting the current tracks
+// Safe to call from any goroutine; calls during an exchange are coalesced
+func (n *negotiator) Negotiate() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.inFlight {
+		n.pending = true
+		return
+	}
+	n.sendOffer()
+}
+
+// HandleAnswer applies the client's answer and sends any coalesced follow-up offer
+func (n *negotiator) HandleAnswer(sdp string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	pc := n.peer.PeerConnection
+	if pc.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		metricNegotiationFailures.WithLabelValues(stepUnexpected).Inc()
+		log.Printf("Ignoring answer from %s in signaling state %s", n.peer.ID, pc.SignalingState())
+		return
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}); err != nil {
+		metricNegotiationFailures.WithLabelValues(stepSetRemote).Inc()
+		log.Printf("Failed to set remote description for %s: %v", n.peer.ID, err)
+		n.reoffer()
+		return
+	}
+
+	n.inFlight = false
+	metricNegotiations.WithLabelValues(negotiationServer).Inc()
+	n.candidates.Flush(pc)
+	if n.pending {
+		n.sendOffer()
+	}
+}
+
+// HandleOffer answers an offer from the client, ignoring it on glare with our own offer
+func (n *negotiator) HandleOffer(sdp string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	pc := n.peer.PeerConnection
+	if n.inFlight || pc.SignalingState() != webrtc.SignalingStateStable {
+		metricNegotiationFailures.WithLabelValues(stepGlare).Inc()
+		log.Printf("Glare with %s - ignoring its offer while ours is in flight", n.peer.ID)
+		return
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+		metricNegotiationFailures.WithLabelValues(stepSetRemote).Inc()
+		log.Printf("Failed to set remote description for %s: %v", n.peer.ID, err)
+		return
+	}
+	n.candidates.Flush(pc)
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		metricNegotiationFailures.WithLabelValues(stepCreateAnswer).Inc()
+		log.Printf("Failed to create answer for %s: %v", n.peer.ID, err)
+		return
+	}
+
+	if err := pc.SetLocalDescription(answer); err != nil {
+		metricNegotiationFailures.WithLabelValues(stepSetLocal).Inc()
+		log.Printf("Failed to set local description for %s: %v", n.peer.ID, err)
+		return
+	}
+
+	n.peer.SendMessage(SignalMessage{
+		Type: "answer",
+		SDP:  answer.SDP,
+	})
+	metricNegotiations.WithLabelValues(negotiationClient).Inc()
+
+	// Send changes requested during the client's exchange
+	if n.pending {
+		n.sendOffer()
+	}
+}
+
+// RestartICE sends an offer with fresh ICE credentials, after any offer in flight
+func (n *negotiator) RestartICE() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.iceRestart = true
+	if n.inFlight {
+		n.pending = true
+		return
+	}
+	n.sendOffer()
+}
+
+// HandleCandidate adds a remote candidate, buffering it until its description is applied
+// An empty candidate marks the end of the client's candidates
+func (n *negotiator) HandleCandidate(candidate webrtc.ICECandidateInit) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.candidates.Add(n.peer.PeerConnection, candidate); err != nil {
+		metricNegotiationFailures.WithLabelValues(stepAddCandidate).Inc()
+		log.Printf("Failed to add ICE candidate for %s: %v", n.peer.ID, err)
+	}
+}
+
+// reoffer asks again for an answer that could not be applied; n.mu must be held
+// The offer in flight is rolled back and replaced, or sent again where the
+// PeerConnection refuses to roll it back, as Pion does.
+func (n *negotiator) reoffer() {
+	pc := n.peer.PeerConnection
+	if err := pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err == nil {
+		n.inFlight = false
+		n.sendOffer()
+		return
+	}
+
+	offer := pc.PendingLocalDescription()
+	if offer == nil {
+		return
+	}
+	log.Printf("Sending %s the offer in flight again", n.peer.ID)
+	n.peer.SendMessage(SignalMessage{
+		Type: "offer",
+		SDP:  offer.SDP,
+	})
+}
+
+// sendOffer creates, applies and sends an offer; n.mu must be held and no offer in flight
+func (n *negotiator) sendOffer() {
+	n.pending = false
+	pc := n.peer.PeerConnection
+	if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+
+	var options *webrtc.OfferOptions
+	if n.iceRestart {
+		options = &webrtc.OfferOptions{ICERestart: true}
+	}
+
+	offer, err := pc.CreateOffer(options)
+	if err != nil {
+		metricNegotiationFailures.WithLabelValues(stepCreateOffer).Inc()
+		log.Printf("Failed to create offer for %s: %v", n.peer.ID, err)
+		return
+	}
+
+	if err := pc.SetLocalDescription(offer); err != nil {
+		metricNegotiationFailures.WithLabelValues(stepSetLocal).Inc()
+		log.Printf("Failed to set local description for %s: %v", n.peer.ID, err)
+		return
+	}
+
+	n.inFlight = true
+	if n.iceRestart {
+		metricICERestarts.Inc()
+	}
+	n.iceRestart = false
+	n.peer.SendMessage(SignalMessage{
+		Type: "offer",
+		SDP:  offer.SDP,
+	})
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// newTestServer serves the signaling endpoint and returns its WebSocket URL
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// trackRecorder collects the publishers whose audio a client receives
+type trackRecorder struct {
+	mu   sync.Mutex
+	from map[string]int
+}
+
+func (r *trackRecorder) record(peerID string, track *webrtc.TrackRemote) {
+	r.mu.Lock()
+	r.from[peerID]++
+	r.mu.Unlock()
+}
+
+func (r *trackRecorder) distinct() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.from)
+}
+
+// joinClients connects clients to a room concurrently, each sending test audio
+func joinClients(t *testing.T, url, room string, ids []string) ([]*client.Client, []*trackRecorder) {
+	t.Helper()
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	clients := make([]*client.Client, len(ids))
+	recorders := make([]*trackRecorder, len(ids))
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids))
+	for i, id := range ids {
+		clients[i] = client.NewClient(id, url)
+		recorders[i] = &trackRecorder{from: make(map[string]int)}
+		clients[i].OnAudioReceived(recorders[i].record)
+
+		wg.Add(1)
+		go func(c *client.Client) {
+			defer wg.Done()
+			if err := c.Connect(room); err != nil {
+				errs <- err
+				return
+			}
+			go client.NewSimpleAudioGenerator().StartGenerating(c, done)
+		}(clients[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("connect failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		for _, c := range clients {
+			c.Disconnect()
+		}
+	})
+	return clients, recorders
+}
+
+// roomSettled reports whether every peer in the room has no negotiation in progress
+func roomSettled(room string) bool {
+	r := roomManager.GetOrCreateRoom(room)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, peer := range r.Peers {
+		peer.negotiator.mu.Lock()
+		busy := peer.negotiator.inFlight || peer.negotiator.pending
+		peer.negotiator.mu.Unlock()
+		if busy || peer.PeerConnection.SignalingState() != webrtc.SignalingStateStable {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConcurrentJoinsReceiveAllTracks(t *testing.T) {
+	url := newTestServer(t)
+	const n = 5
+
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("peer-%d", i)
+	}
+	_, recorders := joinClients(t, url, "concurrent", ids)
+
+	for i, rec := range recorders {
+		waitFor(t, 20*time.Second, fmt.Sprintf("%s to receive %d tracks", ids[i], n-1), func() bool {
+			return rec.distinct() == n-1
+		})
+	}
+	waitFor(t, 5*time.Second, "negotiations to settle", func() bool { return roomSettled("concurrent") })
+}
+
+func TestLeaveRemovesForwardedTracks(t *testing.T) {
+	url := newTestServer(t)
+	clients, recorders := joinClients(t, url, "leave", []string{"stay-a", "stay-b", "leaver"})
+
+	for _, rec := range recorders {
+		waitFor(t, 20*time.Second, "all tracks", func() bool { return rec.distinct() == 2 })
+	}
+
+	clients[2].Disconnect()
+	room := roomManager.GetOrCreateRoom("leave")
+	waitFor(t, 10*time.Second, "leaver to be removed", func() bool { return room.GetPeer("leaver") == nil })
+	waitFor(t, 10*time.Second, "negotiations to settle", func() bool { return roomSettled("leave") })
+
+	for _, id := range []string{"stay-a", "stay-b"} {
+		peer := room.GetPeer(id)
+		peer.tracksMu.Lock()
+		senders := len(peer.Senders["leaver"])
+		peer.tracksMu.Unlock()
+		if senders != 0 {
+			t.Errorf("%s still has %d senders for the peer that left", id, senders)
+		}
+		// One transceiver for the peer's own audio, one for the remaining publisher
+		if got := len(peer.PeerConnection.GetTransceivers()); got > 2 {
+			t.Errorf("%s has %d transceivers, want at most 2", id, got)
+		}
+	}
+}
+
+func TestGlareIgnoresClientOffer(t *testing.T) {
+	url := newTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.WriteJSON(SignalMessage{Type: "join", Room: "glare", ClientID: "glare-peer"}); err != nil {
+		t.Fatal(err)
+	}
+	// Every message the server sends, in order
+	var received []string
+	readType := func(want string) SignalMessage {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for {
+			var msg SignalMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("waiting for %s: %v", want, err)
+			}
+			received = append(received, msg.Type)
+			if msg.Type == want {
+				return msg
+			}
+		}
+	}
+	answerOffer := func(offer SignalMessage) {
+		t.Helper()
+		if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+			t.Fatal(err)
+		}
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pc.SetLocalDescription(answer); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteJSON(SignalMessage{Type: "answer", SDP: answer.SDP}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The server's initial offer is in flight when the client offers too.
+	// The client is the polite side: it discards its offer (as a rollback would) and answers
+	serverOffer := readType("offer")
+	glare, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(SignalMessage{Type: "offer", SDP: glare.SDP}); err != nil {
+		t.Fatal(err)
+	}
+	answerOffer(serverOffer)
+	waitFor(t, 5*time.Second, "the server's offer to be answered", func() bool { return roomSettled("glare") })
+
+	// Changes requested while an offer is in flight are coalesced into one follow-up offer
+	peer := roomManager.GetOrCreateRoom("glare").GetPeer("glare-peer")
+	triggerNegotiation(peer)
+	inFlight := readType("offer")
+	triggerNegotiation(peer)
+	triggerNegotiation(peer)
+	triggerNegotiation(peer)
+	answerOffer(inFlight)
+	answerOffer(readType("offer"))
+	waitFor(t, 5*time.Second, "renegotiation to settle", func() bool { return roomSettled("glare") })
+
+	// The glare offer must not have been answered. Data for an unknown peer is
+	// refused at once, so the refusal follows anything sent before it
+	if err := conn.WriteJSON(SignalMessage{Type: "data", Topic: "flush", TargetID: "nobody"}); err != nil {
+		t.Fatal(err)
+	}
+	readType("error")
+	for _, typ := range received {
+		if typ == "answer" {
+			t.Fatalf("server answered the client's glare offer; received %v", received)
+		}
+	}
+
+	// Once stable, the client's own offer is answered
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(SignalMessage{Type: "offer", SDP: offer.SDP}); err != nil {
+		t.Fatal(err)
+	}
+	answer := readType("answer")
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "client negotiation to settle", func() bool { return roomSettled("glare") })
+}
+
+// relayProxy serves a WebSocket endpoint that relays every message to and from the
+// signaling server at url, passing it through rewrite on the way
+func relayProxy(t *testing.T, url string, rewrite func(fromClient bool, data []byte) []byte) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		down, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer down.Close()
+		up, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			return
+		}
+		defer up.Close()
+
+		relay := func(from, to *websocket.Conn, fromClient bool) {
+			for {
+				kind, data, err := from.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := to.WriteMessage(kind, rewrite(fromClient, data)); err != nil {
+					return
+				}
+			}
+		}
+		go relay(up, down, false)
+		relay(down, up, true)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestUnusableAnswerIsOfferedAgain(t *testing.T) {
+	var corrupted atomic.Bool
+	var offers atomic.Int32
+	proxy := relayProxy(t, newTestServer(t), func(fromClient bool, data []byte) []byte {
+		var msg SignalMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return data
+		}
+		if !fromClient {
+			if msg.Type == "offer" {
+				offers.Add(1)
+			}
+			return data
+		}
+		if msg.Type != "answer" || !corrupted.CompareAndSwap(false, true) {
+			return data
+		}
+		msg.SDP = "v=0\r\nnot an answer\r\n"
+		corrupt, err := json.Marshal(msg)
+		if err != nil {
+			t.Error(err)
+			return data
+		}
+		return corrupt
+	})
+
+	c := client.NewClient("corrupt-peer", proxy)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := c.ConnectContext(ctx, "corrupt-answer"); err != nil {
+		t.Fatalf("connect after an unusable answer: %v", err)
+	}
+	defer c.Disconnect()
+
+	if !corrupted.Load() {
+		t.Fatal("no answer was corrupted")
+	}
+	if got := offers.Load(); got < 2 {
+		t.Errorf("client received %d offers, want another after the unusable answer", got)
+	}
+	waitFor(t, 5*time.Second, "negotiation to settle", func() bool { return roomSettled("corrupt-answer") })
+}
+
+func TestCandidatesBufferedUntilDescription(t *testing.T) {
+	url := newTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan struct{})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+
+	if err := conn.WriteJSON(SignalMessage{Type: "join", Room: "trickle", ClientID: "trickle-peer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Gather our candidates completely before the server has our answer, then send
+	// them ahead of it: the server must hold them until the answer is applied
+	var offer SignalMessage
+	var early []SignalMessage
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for offer.Type != "offer" {
+		var msg SignalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		switch msg.Type {
+		case "offer":
+			offer = msg
+		case "candidate":
+			early = append(early, msg)
+		}
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	local, err := pc.LocalDescription().Unmarshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := 0
+	for _, media := range local.MediaDescriptions {
+		for _, attr := range media.Attributes {
+			if attr.Key == "candidate" {
+				sent++
+				if err := conn.WriteJSON(SignalMessage{Type: "candidate", Candidate: "candidate:" + attr.Value}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := conn.WriteJSON(SignalMessage{Type: "end_of_candidates"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent == 0 {
+		t.Fatal("no local candidates gathered")
+	}
+
+	// Every candidate and the end-of-candidates marker wait for the answer
+	peer := roomManager.GetOrCreateRoom("trickle").GetPeer("trickle-peer")
+	waitFor(t, 5*time.Second, "candidates to be buffered", func() bool {
+		peer.negotiator.mu.Lock()
+		defer peer.negotiator.mu.Unlock()
+		return peer.negotiator.candidates.Len() == sent+1
+	})
+
+	// Answer without candidates in the SDP, so only the trickled ones can connect
+	if err := conn.WriteJSON(SignalMessage{Type: "answer", SDP: answer.SDP}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Apply the server's candidates, including any sent before its offer
+	candidates := make(chan SignalMessage, 64)
+	go func() {
+		defer close(candidates)
+		for _, msg := range early {
+			candidates <- msg
+		}
+		for {
+			var msg SignalMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "candidate" || msg.Type == "end_of_candidates" {
+				candidates <- msg
+			}
+		}
+	}()
+
+	var received []SignalMessage
+	waitConnected := connected
+	timeout := time.After(15 * time.Second)
+	for ended := false; waitConnected != nil || !ended; {
+		select {
+		case msg, ok := <-candidates:
+			if !ok {
+				t.Fatal("signaling connection closed")
+			}
+			if msg.Type == "end_of_candidates" {
+				ended = true
+				continue
+			}
+			received = append(received, msg)
+			if err := pc.AddICECandidate(msg.candidateInit()); err != nil {
+				t.Fatal(err)
+			}
+		case <-waitConnected:
+			waitConnected = nil
+		case <-timeout:
+			t.Fatal("timed out waiting for the connection and the server's candidates")
+		}
+	}
+	if len(received) == 0 {
+		t.Fatal("no candidates received from the server")
+	}
+
+	// Candidates carry the full set of fields, not just the candidate line
+	for _, msg := range received {
+		if msg.SDPMid == nil || *msg.SDPMid == "" || msg.SDPMLineIndex == nil {
+			t.Errorf("candidate without sdp_mid or sdp_mline_index: %+v", msg)
+		}
+		if msg.UsernameFragment == nil || *msg.UsernameFragment == "" {
+			t.Errorf("candidate without username_fragment: %+v", msg)
+		}
+	}
+}
+
+func TestClientRequestedICERestart(t *testing.T) {
+	url := newTestServer(t)
+	clients, _ := joinClients(t, url, "restart", []string{"restarter"})
+
+	room := roomManager.GetOrCreateRoom("restart")
+	waitFor(t, 5*time.Second, "the peer to join", func() bool { return room.GetPeer("restarter") != nil })
+	peer := room.GetPeer("restarter")
+	pc := peer.PeerConnection
+	waitFor(t, 10*time.Second, "the connection", func() bool {
+		return pc.ConnectionState() == webrtc.PeerConnectionStateConnected
+	})
+	waitFor(t, 5*time.Second, "negotiation to settle", func() bool { return roomSettled("restart") })
+
+	ufrag := func() string {
+		peer.negotiator.mu.Lock()
+		defer peer.negotiator.mu.Unlock()
+		desc := webrtc.SessionDescription{SDP: pc.LocalDescription().SDP}
+		parsed, err := desc.Unmarshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, _ := parsed.MediaDescriptions[0].Attribute("ice-ufrag")
+		return value
+	}
+	before := ufrag()
+
+	if err := clients[0].RestartICE(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "new ICE credentials", func() bool { return ufrag() != before })
+	waitFor(t, 5*time.Second, "the restart to settle", func() bool { return roomSettled("restart") })
+	waitFor(t, 10*time.Second, "the connection after the restart", func() bool {
+		return pc.ConnectionState() == webrtc.PeerConnectionStateConnected
+	})
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"example.com/agent_bridge/pkg/relay"
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
 )
@@ -11,11 +14,26 @@ import (
 // Peer represents a connected client
 type Peer struct {
 	ID             string
+	Role           string // Granted by the join token
+	JoinedAt       time.Time
 	Conn           *websocket.Conn
 	PeerConnection *webrtc.PeerConnection
 	Room           *Room
 	LocalTracks    map[string]*webrtc.TrackLocalStaticRTP
 	mu             sync.Mutex
+
+	// Senders of the tracks forwarded to this peer, keyed by publisher ID
+	Senders  map[string][]*webrtc.RTPSender
+	tracksMu sync.Mutex
+
+	negotiator *negotiator
+	forceMuted atomic.Bool // Set by an operator; the peer's audio is not forwarded
+	suspended  atomic.Bool // Lost its signaling connection; held in the room for a resume
+
+	relay     *webrtc.DataChannel // Application data to and from the peer, besides signaling
+	relayCap  uint64              // Most data queued on relay before messages to the peer are refused
+	relayMu   sync.Mutex          // Guards relay and relayCap; serializes the frames of each message sent on it
+	relayRecv *relay.Assembler    // Used only by the relay's message handler
 }
 
 // SendMessage sends a signaling message to the peer
@@ -26,13 +44,17 @@ func (p *Peer) SendMessage(msg SignalMessage) error {
 }
 
 // addTrackToPeer adds a track to the peer and triggers renegotiation
-func addTrackToPeer(peer *Peer, track *webrtc.TrackLocalStaticRTP, _ string) {
+func addTrackToPeer(peer *Peer, track *webrtc.TrackLocalStaticRTP, publisherID string) {
 	sender, err := peer.PeerConnection.AddTrack(track)
 	if err != nil {
 		log.Printf("Failed to add track to peer %s: %v", peer.ID, err)
 		return
 	}
 
+	peer.tracksMu.Lock()
+	peer.Senders[publisherID] = append(peer.Senders[publisherID], sender)
+	peer.tracksMu.Unlock()
+
 	// Read and discard RTCP packets to keep the connection alive
 	go func() {
 		buf := make([]byte, 1500)
@@ -46,3 +68,25 @@ func addTrackToPeer(peer *Peer, track *webrtc.TrackLocalStaticRTP, _ string) {
 	// Trigger renegotiation
 	triggerNegotiation(peer)
 }
+
+// removeTracksFromPeer removes the tracks forwarded from a publisher and triggers renegotiation
+// The freed transceivers become recvonly and are reused by later tracks
+func removeTracksFromPeer(peer *Peer, publisherID string) {
+	peer.tracksMu.Lock()
+	senders := peer.Senders[publisherID]
+	delete(peer.Senders, publisherID)
+	peer.tracksMu.Unlock()
+
+	if len(senders) == 0 {
+		return
+	}
+
+	for _, sender := range senders {
+		if err := peer.PeerConnection.RemoveTrack(sender); err != nil {
+			log.Printf("Failed to remove %s's track from peer %s: %v", publisherID, peer.ID, err)
+		}
+	}
+
+	// Trigger renegotiation
+	triggerNegotiation(peer)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/agent_bridge/pkg/auth"
+	"example.com/agent_bridge/pkg/oggopus"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// recordingConfig holds recording settings, set from flags in main
+var recordingConfig struct {
+	dir string // Where recordings are written; empty disables recording
+}
+
+var (
+	errRecordingDisabled = errors.New("recording is disabled on this server")
+	errAlreadyRecording  = errors.New("room is already being recorded")
+	errNotRecording      = errors.New("room is not being recorded")
+)
+
+// manifestFile is the name of the manifest written next to the track files
+const manifestFile = "manifest.json"
+
+// recordingManifest describes a finished recording
+// Offsets are milliseconds from StartedAt, so all tracks share one timeline
+type recordingManifest struct {
+	Room         string               `json:"room"`
+	StartedAt    time.Time            `json:"started_at"`
+	StoppedAt    time.Time            `json:"stopped_at"`
+	DurationMS   int64                `json:"duration_ms"`
+	StartedBy    string               `json:"started_by,omitempty"`
+	Participants []*participantRecord `json:"participants"`
+}
+
+// participantRecord is one stay of a peer in the room while it was recorded
+// A peer that leaves and joins again gets a new record
+type participantRecord struct {
+	ID             string         `json:"id"`
+	Role           string         `json:"role"`
+	JoinedOffsetMS int64          `json:"joined_offset_ms"` // 0 if already present when recording started
+	LeftOffsetMS   int64          `json:"left_offset_ms"`   // The end of the recording if still present
+	Tracks         []*trackRecord `json:"tracks"`
+}
+
+// trackRecord is one Ogg Opus file holding a participant's track
+type trackRecord struct {
+	File          string `json:"file"` // Relative to the manifest
+	TrackID       string `json:"track_id"`
+	StartOffsetMS int64  `json:"start_offset_ms"` // Where the first decoded sample belongs
+	DurationMS    int64  `json:"duration_ms"`     // Decoded duration, after the pre-skip
+}
+
+// recording writes the audio of one room, one file per participant track
+type recording struct {
+	room      *Room
+	dir       string
+	startedAt time.Time
+	startedBy string
+
+	mu      sync.Mutex
+	stopped bool
+	present map[*Peer]*participantRecord // Participants in the room
+	records []*participantRecord         // Every participant, in join order
+	tracks  map[*webrtc.TrackRemote]*trackFile
+	files   int
+}
+
+// trackFile writes one remote track to an Ogg Opus file
+type trackFile struct {
+	peer   *Peer
+	record *trackRecord
+	first  time.Time // Arrival of the first packet
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *oggopus.Writer
+	closed bool
+}
+
+// StartRecording starts recording the room and tells every peer
+// by identifies who started it, for the manifest
+func (r *Room) StartRecording(by string) (*recording, error) {
+	if recordingConfig.dir == "" {
+		return nil, errRecordingDisabled
+	}
+	if r.recording.Load() != nil {
+		return nil, errAlreadyRecording
+	}
+
+	rec := &recording{
+		room:      r,
+		startedAt: time.Now(),
+		startedBy: by,
+		present:   make(map[*Peer]*participantRecord),
+		tracks:    make(map[*webrtc.TrackRemote]*trackFile),
+	}
+	rec.dir = filepath.Join(recordingConfig.dir, safeFileName(r.ID), rec.startedAt.UTC().Format("20060102T150405.000Z"))
+	if err := os.MkdirAll(filepath.Dir(rec.dir), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(rec.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// Checked under the room lock so a closing room cannot be left recording
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		os.Remove(rec.dir)
+		return nil, errRoomClosed
+	}
+	if !r.recording.CompareAndSwap(nil, rec) {
+		r.mu.Unlock()
+		os.Remove(rec.dir)
+		return nil, errAlreadyRecording
+	}
+	r.mu.Unlock()
+
+	for _, peer := range r.ListPeers() {
+		rec.addParticipant(peer)
+	}
+	log.Printf("Recording room %s to %s (started by %s)", r.ID, rec.dir, by)
+	r.BroadcastExcept("", SignalMessage{Type: "recording", Recording: rec.info()})
+	return rec, nil
+}
+
+// StopRecording stops the room's recording, writes its manifest and tells every peer
+func (r *Room) StopRecording() (*recording, *recordingManifest, error) {
+	rec := r.recording.Swap(nil)
+	if rec == nil {
+		return nil, nil, errNotRecording
+	}
+	manifest, err := rec.stop()
+	if err != nil {
+		log.Printf("Failed to finish recording of room %s: %v", r.ID, err)
+	} else {
+		log.Printf("Stopped recording room %s", r.ID)
+	}
+	r.BroadcastExcept("", SignalMessage{Type: "recording", Recording: &RecordingInfo{Active: false}})
+	return rec, manifest, err
+}
+
+// info returns the recording state sent to peers
+func (rec *recording) info() *RecordingInfo {
+	return &RecordingInfo{
+		Active:    true,
+		StartedAt: rec.startedAt.Unix(),
+		StartedBy: rec.startedBy,
+	}
+}
+
+// offset returns the milliseconds from the start of the recording to t, at least 0
+func (rec *recording) offset(t time.Time) int64 {
+	return max(t.Sub(rec.startedAt).Milliseconds(), 0)
+}
+
+// addParticipant starts a participant record; a peer already present is ignored
+func (rec *recording) addParticipant(peer *Peer) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.stopped || rec.present[peer] != nil {
+		return
+	}
+	record := &participantRecord{
+		ID:             peer.ID,
+		Role:           peer.Role,
+		JoinedOffsetMS: rec.offset(peer.JoinedAt),
+		Tracks:         []*trackRecord{},
+	}
+	rec.present[peer] = record
+	rec.records = append(rec.records, record)
+}
+
+// removeParticipant ends a participant's record and closes its files
+func (rec *recording) removeParticipant(peer *Peer) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	record := rec.present[peer]
+	if rec.stopped || record == nil {
+		return
+	}
+	delete(rec.present, peer)
+	record.LeftOffsetMS = rec.offset(time.Now())
+
+	for _, track := range rec.tracks {
+		if track.peer == peer {
+			track.close()
+		}
+	}
+}
+
+// writeRTP records a packet read from a peer's track
+func (rec *recording) writeRTP(peer *Peer, remote *webrtc.TrackRemote, data []byte) {
+	track := rec.track(peer, remote, data)
+	if track == nil {
+		return
+	}
+	var packet rtp.Packet
+	if err := packet.Unmarshal(data); err != nil {
+		return
+	}
+
+	track.mu.Lock()
+	defer track.mu.Unlock()
+	if track.closed {
+		return
+	}
+	if err := track.writer.WriteRTP(&packet); err != nil && !errors.Is(err, oggopus.ErrInvalidPacket) {
+		log.Printf("Recording %s failed: %v", track.record.File, err)
+		track.closeLocked()
+	}
+}
+
+// track returns the recorder for a remote track, opening its file on the first packet
+// Returns nil once the recording stopped or the peer left, or if the track cannot be recorded
+func (rec *recording) track(peer *Peer, remote *webrtc.TrackRemote, first []byte) *trackFile {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if track, ok := rec.tracks[remote]; ok {
+		return track
+	}
+	record := rec.present[peer]
+	if rec.stopped || record == nil {
+		return nil
+	}
+
+	// Remember tracks that cannot be recorded, so they are not retried per packet
+	track := &trackFile{peer: peer, record: &trackRecord{TrackID: remote.ID()}, first: time.Now(), closed: true}
+	rec.tracks[remote] = track
+	if !strings.EqualFold(remote.Codec().MimeType, webrtc.MimeTypeOpus) {
+		log.Printf("Not recording %s's %s track", peer.ID, remote.Codec().MimeType)
+		return nil
+	}
+
+	// Mono unless the first packet is stereo; mono packets decode fine in a stereo file
+	var packet rtp.Packet
+	channels := uint8(1)
+	if packet.Unmarshal(first) == nil && len(packet.Payload) > 0 && packet.Payload[0]&0x04 != 0 {
+		channels = 2
+	}
+
+	rec.files++
+	name := fmt.Sprintf("%s-%d.ogg", safeFileName(peer.ID), rec.files)
+	file, err := os.Create(filepath.Join(rec.dir, name))
+	if err != nil {
+		log.Printf("Failed to record %s: %v", peer.ID, err)
+		return nil
+	}
+	writer, err := oggopus.NewWriter(file, channels)
+	if err != nil {
+		log.Printf("Failed to record %s: %v", peer.ID, err)
+		file.Close()
+		return nil
+	}
+
+	track.record.File = name
+	track.record.StartOffsetMS = rec.offset(track.first) + oggopus.PreSkip*1000/oggopus.SampleRate
+	track.file, track.writer, track.closed = file, writer, false
+	record.Tracks = append(record.Tracks, track.record)
+	return track
+}
+
+// stop closes every file and writes the manifest
+func (rec *recording) stop() (*recordingManifest, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.stopped = true
+	stoppedAt := time.Now()
+
+	for _, track := range rec.tracks {
+		track.close()
+	}
+	for _, record := range rec.present {
+		record.LeftOffsetMS = rec.offset(stoppedAt)
+	}
+
+	manifest := &recordingManifest{
+		Room:         rec.room.ID,
+		StartedAt:    rec.startedAt,
+		StoppedAt:    stoppedAt,
+		DurationMS:   rec.offset(stoppedAt),
+		StartedBy:    rec.startedBy,
+		Participants: rec.records,
+	}
+	if manifest.Participants == nil {
+		manifest.Participants = []*participantRecord{}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return manifest, os.WriteFile(filepath.Join(rec.dir, manifestFile), data, 0o644)
+}
+
+// close finishes the track's file
+func (t *trackFile) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closeLocked()
+}
+
+// closeLocked finishes the track's file; t.mu must be held
+func (t *trackFile) closeLocked() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	if err := t.writer.Close(); err != nil {
+		log.Printf("Failed to finish %s: %v", t.record.File, err)
+	}
+	if err := t.file.Close(); err != nil {
+		log.Printf("Failed to close %s: %v", t.record.File, err)
+	}
+	decoded := int64(t.writer.Samples()) - oggopus.PreSkip
+	t.record.DurationMS = max(decoded, 0) * 1000 / oggopus.SampleRate
+}
+
+// safeFileName maps an ID to a name that is safe as a single path element
+func safeFileName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, id)
+	if strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	return name
+}
+
+// handleRecording starts or stops recording the peer's room
+// With join tokens enabled, only agents and admins may
+func handleRecording(peer *Peer, msg SignalMessage) {
+	if len(authConfig.secret) > 0 && peer.Role == auth.RoleParticipant {
+		peer.SendMessage(SignalMessage{
+			Type:  "error",
+			Code:  ErrCodeForbidden,
+			Error: "only agents and admins may control recording",
+		})
+		return
+	}
+
+	var err error
+	if msg.Type == "start_recording" {
+		_, err = peer.Room.StartRecording(peer.ID)
+	} else {
+		_, _, err = peer.Room.StopRecording()
+	}
+	if err != nil {
+		log.Printf("Peer %s: %s failed: %v", peer.ID, msg.Type, err)
+		code := ErrCodeBadRequest
+		if errors.Is(err, errRecordingDisabled) {
+			code = ErrCodeRecordingUnavailable
+		}
+		peer.SendMessage(SignalMessage{Type: "error", Code: code, Error: err.Error()})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// silence20ms is a 20 ms Opus frame that decodes to silence
+var silence20ms = []byte{0xf8, 0xff, 0xfe}
+
+// lastGranule returns the granule position of the last page of an Ogg file
+func lastGranule(t *testing.T, path string) uint64 {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("OggS")) {
+		t.Fatalf("%s is not an Ogg file", path)
+	}
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if data[last+5]&0x04 == 0 {
+		t.Errorf("%s does not end with an end-of-stream page", path)
+	}
+	return binary.LittleEndian.Uint64(data[last+6:])
+}
+
+func TestRecordingWritesTracksAndManifest(t *testing.T) {
+	recordingConfig.dir = t.TempDir()
+	t.Cleanup(func() { recordingConfig.dir = "" })
+	base, url := adminTestServer(t)
+	roomURL := base + "/admin/rooms/rec"
+
+	alice, aliceEvents := joinWatched(t, url, "rec", "alice")
+	waitFor(t, 10*time.Second, "alice to be connected", func() bool {
+		return roomManager.GetRoom("rec").GetPeer("alice").PeerConnection.ConnectionState().String() == "connected"
+	})
+
+	var started recordingStatus
+	if got := adminRequest(t, "POST", roomURL+"/recording", testAdminKey, "", &started); got != http.StatusCreated {
+		t.Fatalf("start recording: status %d", got)
+	}
+	if !started.Active || started.StartedBy != "admin" || started.Dir == "" {
+		t.Errorf("unexpected start response %+v", started)
+	}
+	if got := adminRequest(t, "POST", roomURL+"/recording", testAdminKey, "", nil); got != http.StatusConflict {
+		t.Errorf("starting twice: status %d, want 409", got)
+	}
+	waitFor(t, 5*time.Second, "alice to be told about the recording", aliceEvents.has(func() bool {
+		return len(aliceEvents.recording) == 1 && aliceEvents.recording[0]
+	}))
+
+	// A peer joining a recorded room is told on arrival
+	bob, bobEvents := joinWatched(t, url, "rec", "bob")
+	waitFor(t, 5*time.Second, "bob to be told about the recording", bobEvents.has(func() bool {
+		return len(bobEvents.recording) == 1 && bobEvents.recording[0]
+	}))
+
+	// Alice speaks for a second, with a muted stretch that is recorded as silence
+	alicePeer := roomManager.GetRoom("rec").GetPeer("alice")
+	for i := 0; i < 50; i++ {
+		setForceMuted(alicePeer, i >= 20 && i < 35)
+		if err := alice.WriteOpus(silence20ms); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	bob.Disconnect()
+	waitFor(t, 5*time.Second, "bob to leave", func() bool { return roomManager.GetRoom("rec").GetPeer("bob") == nil })
+
+	// Stopping over signaling is allowed without join tokens
+	if err := alice.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "alice to be told the recording stopped", aliceEvents.has(func() bool {
+		return len(aliceEvents.recording) == 2 && !aliceEvents.recording[1]
+	}))
+	if got := adminRequest(t, "DELETE", roomURL+"/recording", testAdminKey, "", nil); got != http.StatusConflict {
+		t.Errorf("stopping twice: status %d, want 409", got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(started.Dir, manifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest recordingManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Room != "rec" || manifest.StartedBy != "admin" || len(manifest.Participants) != 2 {
+		t.Fatalf("unexpected manifest %s", data)
+	}
+
+	a, b := manifest.Participants[0], manifest.Participants[1]
+	if a.ID != "alice" || a.JoinedOffsetMS != 0 || a.LeftOffsetMS != manifest.DurationMS {
+		t.Errorf("alice's record %+v, want present from start to end (%d ms)", a, manifest.DurationMS)
+	}
+	if b.ID != "bob" || b.JoinedOffsetMS <= 0 || b.LeftOffsetMS < b.JoinedOffsetMS || b.LeftOffsetMS >= manifest.DurationMS {
+		t.Errorf("bob's record %+v, want joined and left during the recording", b)
+	}
+	if len(a.Tracks) != 1 {
+		t.Fatalf("alice has %d tracks, want 1", len(a.Tracks))
+	}
+
+	// The file holds what was sent: granules count 48 kHz samples of 20 ms packets
+	track := a.Tracks[0]
+	granule := lastGranule(t, filepath.Join(started.Dir, track.File))
+	if granule < 40*960 || granule%960 != 0 || granule > 50*960 {
+		t.Errorf("final granule %d, want a multiple of 960 covering the muted stretch, up to %d", granule, 50*960)
+	}
+	if want := (int64(granule) - 3840) * 1000 / 48000; track.DurationMS != want {
+		t.Errorf("track duration %d ms, want %d from the granule", track.DurationMS, want)
+	}
+	if track.StartOffsetMS < 80 || track.StartOffsetMS > manifest.DurationMS {
+		t.Errorf("track start offset %d ms outside the recording", track.StartOffsetMS)
+	}
+}
+
+func TestRecordingUnavailableWithoutDirectory(t *testing.T) {
+	base, url := adminTestServer(t)
+	_, events := joinWatched(t, url, "rec-off", "alice")
+
+	if got := adminRequest(t, "POST", base+"/admin/rooms/rec-off/recording", testAdminKey, "", nil); got != http.StatusServiceUnavailable {
+		t.Errorf("start without -recordings-dir: status %d, want 503", got)
+	}
+
+	// Over signaling the peer gets an error instead
+	peer := roomManager.GetRoom("rec-off").GetPeer("alice")
+	handleRecording(peer, SignalMessage{Type: "start_recording"})
+	waitFor(t, 5*time.Second, "alice to get recording_unavailable", events.has(func() bool {
+		return len(events.errCodes) == 1 && events.errCodes[0] == ErrCodeRecordingUnavailable
+	}))
+}
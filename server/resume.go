@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// defaultResumeGrace is how long a peer that lost its connection keeps its place by default
+const defaultResumeGrace = 20 * time.Second
+
+// resumeConfig holds resume settings, set from flags in main
+var resumeConfig struct {
+	grace time.Duration // How long a dropped peer is held for a resuming join; 0 disables resume
+}
+
+// suspendPeer handles a peer whose signaling connection dropped
+// The peer keeps its place in the room for the grace period, so a client that
+// reconnects with a resuming join takes it over without the others seeing it leave
+func suspendPeer(peer *Peer) {
+	grace := resumeConfig.grace
+	if grace <= 0 || peer.Room == nil || peer.Room.GetPeer(peer.ID) != peer {
+		handlePeerDisconnect(peer)
+		return
+	}
+
+	log.Printf("Peer %s lost its connection; holding its place for %s", peer.ID, grace)
+	peer.suspended.Store(true)
+	time.AfterFunc(grace, func() {
+		if peer.Room.GetPeer(peer.ID) == peer {
+			log.Printf("Peer %s did not resume within %s", peer.ID, grace)
+			handlePeerDisconnect(peer)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"example.com/agent_bridge/client"
+)
+
+// dropConnection closes a peer's signaling connection from the server's side, as a
+// network failure would
+func dropConnection(t *testing.T, room, id string) *Peer {
+	t.Helper()
+	peer := roomManager.GetRoom(room).GetPeer(id)
+	if peer == nil {
+		t.Fatalf("%s is not in room %s", id, room)
+	}
+	peer.Conn.Close()
+	return peer
+}
+
+func TestClientResumesAfterDroppedConnection(t *testing.T) {
+	resumeConfig.grace = 10 * time.Second
+	t.Cleanup(func() { resumeConfig.grace = 0 })
+	secret := withJoinSecret(t) // Only a token-backed ID may take over its old connection
+	_, url := adminTestServer(t)
+	alice, aliceEvents := joinWatched(t, url, "resume", "alice", client.WithToken(joinToken(t, secret, "resume", "alice")))
+	alice.SetReconnectPolicy(client.ReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	_, bobEvents := joinWatched(t, url, "resume", "bob", client.WithToken(joinToken(t, secret, "resume", "bob")))
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go client.NewSimpleAudioGenerator().StartGenerating(alice, done)
+	countTracks := func(events *clientEvents, from string) func() int {
+		return func() int {
+			events.mu.Lock()
+			defer events.mu.Unlock()
+			n := 0
+			for _, id := range events.tracks {
+				if id == from {
+					n++
+				}
+			}
+			return n
+		}
+	}
+	bobTracks := countTracks(bobEvents, "alice")
+	waitFor(t, 10*time.Second, "bob to receive alice's audio", func() bool { return bobTracks() == 1 })
+
+	old := dropConnection(t, "resume", "alice")
+	waitFor(t, 10*time.Second, "alice to resume", func() bool {
+		return roomManager.GetRoom("resume").GetPeer("alice") != old && alice.State() == client.StateConnected
+	})
+	// The outgoing track is renegotiated on the new PeerConnection
+	waitFor(t, 10*time.Second, "bob to receive alice's audio again", func() bool { return bobTracks() == 2 })
+
+	aliceEvents.mu.Lock()
+	states := slices.Clone(aliceEvents.states)
+	aliceEvents.mu.Unlock()
+	want := []client.ConnectionState{client.StateConnecting, client.StateConnected, client.StateReconnecting, client.StateConnected}
+	if !slices.Equal(states, want) {
+		t.Errorf("alice went through states %v, want %v", states, want)
+	}
+	bobEvents.mu.Lock()
+	if len(bobEvents.peers) != 0 {
+		t.Errorf("bob saw peer events %v during alice's resume", bobEvents.peers)
+	}
+	bobEvents.mu.Unlock()
+
+	// Leaving on purpose is seen at once, not after the grace period
+	alice.Disconnect()
+	waitFor(t, 3*time.Second, "bob to see alice leave", bobEvents.has(func() bool {
+		return slices.Equal(bobEvents.peers, []string{"-alice"})
+	}))
+	if state := alice.State(); state != client.StateDisconnected {
+		t.Errorf("alice is %s after disconnecting", state)
+	}
+}
+
+func TestDroppedPeerLeavesAfterGracePeriod(t *testing.T) {
+	resumeConfig.grace = 2 * time.Second
+	t.Cleanup(func() { resumeConfig.grace = 0 })
+	_, url := adminTestServer(t)
+	alice, _ := joinWatched(t, url, "resume-expiry", "alice")
+	alice.SetReconnectPolicy(client.ReconnectPolicy{Disabled: true})
+	_, bobEvents := joinWatched(t, url, "resume-expiry", "bob")
+
+	dropConnection(t, "resume-expiry", "alice")
+	waitFor(t, 5*time.Second, "alice to give up", func() bool { return alice.State() == client.StateDisconnected })
+	if alice.IsConnected() {
+		t.Error("alice still counts as connected")
+	}
+	bobEvents.mu.Lock()
+	if len(bobEvents.peers) != 0 {
+		t.Errorf("bob saw %v before the grace period ended", bobEvents.peers)
+	}
+	bobEvents.mu.Unlock()
+
+	waitFor(t, 5*time.Second, "bob to see alice leave", bobEvents.has(func() bool {
+		return slices.Equal(bobEvents.peers, []string{"-alice"})
+	}))
+	if roomManager.GetRoom("resume-expiry").GetPeer("alice") != nil {
+		t.Error("alice is still in the room")
+	}
+}
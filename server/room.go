@@ -1,27 +1,151 @@
 package main
 
-import "sync"
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRoomIdleTTL is how long an empty room is kept before it is closed
+const defaultRoomIdleTTL = 5 * time.Minute
+
+var (
+	errRoomFull   = errors.New("room is full")
+	errRoomClosed = errors.New("room is closed")
+)
+
+// Reasons a room is closed, reported in RoomClosed events and to peers still inside
+const (
+	CloseReasonIdle    = "idle"    // Empty for longer than the idle TTL
+	CloseReasonExpired = "expired" // Reached its max duration
+	CloseReasonAdmin   = "admin"   // Closed by an operator
+)
+
+// RoomOptions is optional metadata set when a room is created
+type RoomOptions struct {
+	Name               string `json:"name,omitempty"`
+	MaxPeers           int    `json:"max_peers,omitempty"`            // 0 uses the server default
+	MaxDurationSeconds int    `json:"max_duration_seconds,omitempty"` // 0 for no limit
+	DefaultPersona     string `json:"default_persona,omitempty"`      // Persona agents should use
+	Mode               string `json:"mode,omitempty"`                 // RoomModeSFU (default) or RoomModeMCU
+}
+
+// MaxDuration returns the room's maximum lifetime, or 0 for no limit
+func (o RoomOptions) MaxDuration() time.Duration {
+	return time.Duration(o.MaxDurationSeconds) * time.Second
+}
 
 // Room holds all peers in a room
 type Room struct {
-	ID    string
-	Peers map[string]*Peer
-	mu    sync.RWMutex
+	ID        string
+	Options   RoomOptions // Fixed at creation
+	CreatedAt time.Time
+	Peers     map[string]*Peer
+	mu        sync.RWMutex
+
+	manager     *RoomManager
+	closed      bool
+	idleTimer   *time.Timer // Runs while the room is empty
+	idleGen     uint64      // Identifies the current idle timer, so stale ones do nothing
+	expiryTimer *time.Timer // Runs if the room has a max duration
+
+	recording atomic.Pointer[recording] // Set while the room is recorded
+	mixer     *mixer                    // Set in MCU rooms
+	speakers  *speakerDetector
+}
+
+// Info returns the room metadata sent to peers
+func (r *Room) Info() RoomInfo {
+	info := RoomInfo{
+		ID:             r.ID,
+		Name:           r.Options.Name,
+		MaxPeers:       r.Options.MaxPeers,
+		DefaultPersona: r.Options.DefaultPersona,
+		Mode:           r.Options.Mode,
+		CreatedAt:      r.CreatedAt.Unix(),
+	}
+	if d := r.Options.MaxDuration(); d > 0 {
+		info.ExpiresAt = r.CreatedAt.Add(d).Unix()
+	}
+	return info
 }
 
 // AddPeer adds a peer to the room
-func (r *Room) AddPeer(peer *Peer) {
+// Fails with errRoomFull at capacity and errRoomClosed if the room was closed meanwhile
+func (r *Room) AddPeer(peer *Peer) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errRoomClosed
+	}
+	if _, rejoin := r.Peers[peer.ID]; !rejoin && r.Options.MaxPeers > 0 && len(r.Peers) >= r.Options.MaxPeers {
+		r.mu.Unlock()
+		return errRoomFull
+	}
+	r.Peers[peer.ID] = peer
+	peer.Room = r
+	r.stopIdleTimer()
+	r.mu.Unlock()
+
+	r.manager.emit(RoomEvent{Type: PeerJoined, Room: r, PeerID: peer.ID})
+	return nil
+}
+
+// ReplacePeer puts a resuming peer in the place of its previous connection, without
+// the events of a leave and a join
+// Returns false if old is no longer in the room
+func (r *Room) ReplacePeer(old, peer *Peer) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.closed || r.Peers[old.ID] != old {
+		return false
+	}
 	r.Peers[peer.ID] = peer
 	peer.Room = r
+	return true
 }
 
 // RemovePeer removes a peer from the room
-func (r *Room) RemovePeer(peerID string) {
+// Returns false if the peer already left or was replaced by a rejoin with the same ID
+func (r *Room) RemovePeer(peer *Peer) bool {
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	delete(r.Peers, peerID)
+	if r.Peers[peer.ID] != peer {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.Peers, peer.ID)
+	if len(r.Peers) == 0 && !r.closed {
+		r.startIdleTimer()
+	}
+	r.mu.Unlock()
+
+	r.manager.emit(RoomEvent{Type: PeerLeft, Room: r, PeerID: peer.ID})
+	return true
+}
+
+// startIdleTimer closes the room once it has been empty for the idle TTL; r.mu must be held
+func (r *Room) startIdleTimer() {
+	ttl := r.manager.IdleTTL
+	if ttl <= 0 {
+		return
+	}
+	r.stopIdleTimer()
+	gen := r.idleGen
+	r.idleTimer = time.AfterFunc(ttl, func() {
+		r.manager.closeRoom(r, CloseReasonIdle, gen)
+	})
+}
+
+// stopIdleTimer cancels the idle timer, including one already firing; r.mu must be held
+func (r *Room) stopIdleTimer() {
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+	r.idleGen++
 }
 
 // GetOtherPeers returns all peers except the one with excludeID
@@ -38,6 +162,13 @@ func (r *Room) GetOtherPeers(excludeID string) []*Peer {
 	return peers
 }
 
+// ListPeers returns the peers in the room, ordered by ID
+func (r *Room) ListPeers() []*Peer {
+	peers := r.GetOtherPeers("")
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
 // BroadcastExcept sends a message to all peers except the one with excludeID
 func (r *Room) BroadcastExcept(excludeID string, msg SignalMessage) {
 	r.mu.RLock()
@@ -57,30 +188,180 @@ func (r *Room) GetPeer(peerID string) *Peer {
 	return r.Peers[peerID]
 }
 
+// RoomEventType identifies a room lifecycle event
+type RoomEventType string
+
+// Room lifecycle events
+const (
+	RoomCreated RoomEventType = "room_created"
+	RoomClosed  RoomEventType = "room_closed"
+	PeerJoined  RoomEventType = "peer_joined"
+	PeerLeft    RoomEventType = "peer_left"
+)
+
+// RoomEvent describes a change in a room's lifecycle
+type RoomEvent struct {
+	Type   RoomEventType
+	Room   *Room
+	PeerID string // For PeerJoined and PeerLeft
+	Reason string // For RoomClosed
+}
+
+// RoomListener receives room lifecycle events
+// Called synchronously without room locks held; it must not block
+type RoomListener func(event RoomEvent)
+
 // RoomManager manages all rooms
 type RoomManager struct {
 	Rooms map[string]*Room
 	mu    sync.RWMutex
+
+	IdleTTL  time.Duration // How long empty rooms are kept; 0 keeps them forever
+	MaxPeers int           // Default capacity for rooms created without one; 0 for no limit
+
+	listeners   []RoomListener
+	listenersMu sync.RWMutex
+}
+
+// Subscribe registers a listener for room lifecycle events
+func (rm *RoomManager) Subscribe(listener RoomListener) {
+	rm.listenersMu.Lock()
+	defer rm.listenersMu.Unlock()
+	rm.listeners = append(rm.listeners, listener)
 }
 
-// GetOrCreateRoom returns an existing room or creates a new one
+func (rm *RoomManager) emit(event RoomEvent) {
+	rm.listenersMu.RLock()
+	listeners := rm.listeners
+	rm.listenersMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// GetOrCreateRoom returns an existing room or creates a new one with default options
 func (rm *RoomManager) GetOrCreateRoom(roomID string) *Room {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
+	return rm.OpenRoom(roomID, nil)
+}
 
+// OpenRoom returns an existing room or creates a new one
+// The options apply only if the room is created; an existing room keeps its own
+func (rm *RoomManager) OpenRoom(roomID string, opts *RoomOptions) *Room {
+	rm.mu.Lock()
 	if room, exists := rm.Rooms[roomID]; exists {
+		rm.mu.Unlock()
 		return room
 	}
 
 	room := &Room{
-		ID:    roomID,
-		Peers: make(map[string]*Peer),
+		ID:        roomID,
+		CreatedAt: time.Now(),
+		Peers:     make(map[string]*Peer),
+		manager:   rm,
+	}
+	if opts != nil {
+		room.Options = *opts
+	}
+	if room.Options.MaxPeers <= 0 {
+		room.Options.MaxPeers = rm.MaxPeers
+	}
+	if room.Options.Mode == "" {
+		room.Options.Mode = RoomModeSFU
 	}
+	if room.Options.Mode == RoomModeMCU {
+		room.mixer = newMixer()
+	}
+	room.speakers = newSpeakerDetector(room)
+
+	room.mu.Lock()
+	// A room nobody joins is cleaned up like one everybody left
+	room.startIdleTimer()
+	if d := room.Options.MaxDuration(); d > 0 {
+		room.expiryTimer = time.AfterFunc(d, func() {
+			rm.CloseRoom(room, CloseReasonExpired)
+		})
+	}
+	room.mu.Unlock()
+
 	rm.Rooms[roomID] = room
+	rm.mu.Unlock()
+
+	log.Printf("Room %s created (%s)", roomID, room.Options.Mode)
+	rm.emit(RoomEvent{Type: RoomCreated, Room: room})
 	return room
 }
 
+// GetRoom returns the room with the given ID, or nil
+func (rm *RoomManager) GetRoom(roomID string) *Room {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.Rooms[roomID]
+}
+
+// ListRooms returns all open rooms, ordered by ID
+func (rm *RoomManager) ListRooms() []*Room {
+	rm.mu.RLock()
+	rooms := make([]*Room, 0, len(rm.Rooms))
+	for _, room := range rm.Rooms {
+		rooms = append(rooms, room)
+	}
+	rm.mu.RUnlock()
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms
+}
+
+// CloseRoom removes a room and disconnects any peers still in it
+// Safe to call more than once; later calls do nothing
+func (rm *RoomManager) CloseRoom(room *Room, reason string) {
+	rm.closeRoom(room, reason, 0)
+}
+
+// closeRoom implements CloseRoom; from an idle timer (idleGen != 0), it closes only if
+// that timer is still current, since a peer may have joined meanwhile
+func (rm *RoomManager) closeRoom(room *Room, reason string, idleGen uint64) {
+	room.mu.Lock()
+	if room.closed || (idleGen != 0 && idleGen != room.idleGen) {
+		room.mu.Unlock()
+		return
+	}
+	room.closed = true
+	room.stopIdleTimer()
+	if room.expiryTimer != nil {
+		room.expiryTimer.Stop()
+	}
+	peers := make([]*Peer, 0, len(room.Peers))
+	for _, peer := range room.Peers {
+		peers = append(peers, peer)
+	}
+	room.mu.Unlock()
+
+	rm.mu.Lock()
+	if rm.Rooms[room.ID] == room {
+		delete(rm.Rooms, room.ID)
+	}
+	rm.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.SendMessage(SignalMessage{
+			Type:  "error",
+			Code:  ErrCodeRoomClosed,
+			Error: "room closed: " + reason,
+		})
+		handlePeerDisconnect(peer)
+		peer.Conn.Close()
+	}
+	if room.recording.Load() != nil {
+		room.StopRecording()
+	}
+
+	log.Printf("Room %s closed (%s)", room.ID, reason)
+	rm.emit(RoomEvent{Type: RoomClosed, Room: room, Reason: reason})
+}
+
 // Global room manager instance
 var roomManager = &RoomManager{
-	Rooms: make(map[string]*Room),
+	Rooms:   make(map[string]*Room),
+	IdleTTL: defaultRoomIdleTTL,
 }
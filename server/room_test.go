@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventRecorder collects room lifecycle events
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []RoomEvent
+}
+
+func (r *eventRecorder) record(event RoomEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) types() []RoomEventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]RoomEventType, len(r.events))
+	for i, event := range r.events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func TestEmptyRoomClosedAfterIdleTTL(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	rm := &RoomManager{Rooms: make(map[string]*Room), IdleTTL: ttl}
+	events := &eventRecorder{}
+	rm.Subscribe(events.record)
+
+	room := rm.OpenRoom("idle", &RoomOptions{Name: "Idle"})
+	peer := &Peer{ID: "a"}
+	if err := room.AddPeer(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	// An occupied room outlives the TTL
+	time.Sleep(3 * ttl)
+	if rm.GetRoom("idle") == nil {
+		t.Fatal("occupied room was closed")
+	}
+
+	room.RemovePeer(peer)
+	// The room leaves the manager just before its closed event is emitted
+	waitFor(t, time.Second, "the empty room to close", func() bool {
+		return rm.GetRoom("idle") == nil && len(events.types()) == 4
+	})
+
+	if err := room.AddPeer(&Peer{ID: "late"}); err != errRoomClosed {
+		t.Errorf("joining a closed room: got %v, want errRoomClosed", err)
+	}
+
+	want := []RoomEventType{RoomCreated, PeerJoined, PeerLeft, RoomClosed}
+	got := events.types()
+	if len(got) != len(want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events %v, want %v", got, want)
+		}
+	}
+	if reason := events.events[3].Reason; reason != CloseReasonIdle {
+		t.Errorf("close reason %q, want %q", reason, CloseReasonIdle)
+	}
+}
+
+func TestJoinRejectedWhenRoomFull(t *testing.T) {
+	url := newTestServer(t)
+
+	join := func(id string, opts *RoomOptions) (*websocket.Conn, SignalMessage) {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := conn.WriteJSON(SignalMessage{Type: "join", Room: "full", ClientID: id, RoomOptions: opts}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var msg SignalMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("%s waiting for a reply to its join: %v", id, err)
+			}
+			if msg.Type == "room_info" || msg.Type == "error" {
+				return conn, msg
+			}
+		}
+	}
+
+	_, first := join("first", &RoomOptions{Name: "Full", MaxPeers: 1, DefaultPersona: "coach"})
+	if first.Type != "room_info" || first.RoomInfo == nil {
+		t.Fatalf("first join got %+v, want room_info", first)
+	}
+	if info := first.RoomInfo; info.Name != "Full" || info.MaxPeers != 1 || info.DefaultPersona != "coach" {
+		t.Errorf("room info %+v does not reflect the creation options", info)
+	}
+
+	// Options from later joins do not change the room
+	conn, second := join("second", &RoomOptions{MaxPeers: 10})
+	if second.Type != "error" || second.Code != ErrCodeRoomFull {
+		t.Fatalf("second join got %+v, want a room_full error", second)
+	}
+	var msg SignalMessage
+	if err := conn.ReadJSON(&msg); err == nil {
+		t.Errorf("connection still open after rejection, got %+v", msg)
+	}
+
+	if room := roomManager.GetRoom("full"); room == nil || room.GetPeer("second") != nil {
+		t.Error("rejected peer was added to the room")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// audioLevelURI is the RTP header extension carrying a packet's audio level (RFC 6464)
+const audioLevelURI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+const (
+	speakerInterval  = 200 * time.Millisecond // How often the dominant speaker is re-evaluated
+	speakerSmoothing = 0.5                    // Weight of the latest interval in a peer's smoothed loudness
+
+	// Loudness is 127 minus the RFC 6464 level, so silence is 0 and full scale 127;
+	// a peer counts as speaking above -50 dBov
+	speakerMinLoudness = 127 - 50
+	// Another speaker takes over only when this much louder (in dB) for speakerSwitchTicks
+	speakerSwitchMargin = 6
+	speakerSwitchTicks  = 2
+	// The active speaker is cleared after the room was quiet for speakerQuietTicks
+	speakerQuietTicks = 5
+)
+
+// speakerDetector tracks the dominant speaker of a room from the audio levels
+// publishers put in their RTP packets, without decoding any audio
+type speakerDetector struct {
+	room *Room
+
+	mu      sync.Mutex
+	levels  map[*Peer]*speakerLevel
+	current *Peer
+	running bool
+
+	candidate      *Peer // Louder than current, not yet for long enough
+	candidateTicks int
+	quietTicks     int
+}
+
+// speakerLevel accumulates one peer's loudness
+type speakerLevel struct {
+	sum, count int     // Loudness of the packets since the last tick
+	smoothed   float64 // Moving average across ticks
+}
+
+func newSpeakerDetector(room *Room) *speakerDetector {
+	return &speakerDetector{room: room, levels: make(map[*Peer]*speakerLevel)}
+}
+
+// addPeer starts tracking a peer, starting the detector if needed
+func (d *speakerDetector) addPeer(peer *Peer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.levels[peer] == nil {
+		d.levels[peer] = &speakerLevel{}
+	}
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+}
+
+// removePeer stops tracking a peer; a departed active speaker is replaced on the next tick
+func (d *speakerDetector) removePeer(peer *Peer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.levels, peer)
+	if d.candidate == peer {
+		d.candidate, d.candidateTicks = nil, 0
+	}
+}
+
+// observe records the level of one packet from a peer, in -dBov as RFC 6464 defines it
+func (d *speakerDetector) observe(peer *Peer, level uint8) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if l := d.levels[peer]; l != nil {
+		l.sum += 127 - int(min(level, 127))
+		l.count++
+	}
+}
+
+// activeSpeaker returns the current dominant speaker, or nil
+func (d *speakerDetector) activeSpeaker() *Peer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current
+}
+
+// run re-evaluates the speaker every interval and tells the room when it changes,
+// until no peers are left
+func (d *speakerDetector) run() {
+	ticker := time.NewTicker(speakerInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		speaker, changed, ok := d.tick()
+		if !ok {
+			return
+		}
+		if !changed {
+			continue
+		}
+		msg := SignalMessage{Type: "active_speaker"}
+		if speaker != nil {
+			msg.ClientID = speaker.ID
+			log.Printf("Room %s: %s is speaking", d.room.ID, speaker.ID)
+		}
+		d.room.BroadcastExcept("", msg)
+	}
+}
+
+// tick folds the levels observed since the last tick into each peer's smoothed loudness
+// and picks the dominant speaker
+// Returns ok false (stopping the detector) when no peers are left
+func (d *speakerDetector) tick() (speaker *Peer, changed, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.levels) == 0 {
+		d.running = false
+		changed = d.current != nil
+		d.current = nil
+		return nil, changed, false
+	}
+
+	// A peer that sent nothing (silence suppression, muted) is silent
+	var loudest *Peer
+	for peer, l := range d.levels {
+		loudness := 0.0
+		if l.count > 0 {
+			loudness = float64(l.sum) / float64(l.count)
+		}
+		l.sum, l.count = 0, 0
+		l.smoothed += (loudness - l.smoothed) * speakerSmoothing
+		if l.smoothed >= speakerMinLoudness && (loudest == nil || l.smoothed > d.levels[loudest].smoothed) {
+			loudest = peer
+		}
+	}
+
+	previous := d.current
+	if d.current != nil && d.levels[d.current] == nil {
+		d.current = nil // Left the room
+	}
+	switch {
+	case loudest == nil:
+		d.candidate, d.candidateTicks = nil, 0
+		d.quietTicks++
+		if d.quietTicks >= speakerQuietTicks {
+			d.current = nil
+		}
+	case loudest == d.current:
+		d.candidate, d.candidateTicks = nil, 0
+		d.quietTicks = 0
+	case d.current == nil || d.levels[d.current].smoothed < speakerMinLoudness:
+		// Nobody else is speaking; no need to wait
+		d.current = loudest
+		d.candidate, d.candidateTicks = nil, 0
+		d.quietTicks = 0
+	case d.levels[loudest].smoothed >= d.levels[d.current].smoothed+speakerSwitchMargin:
+		if d.candidate != loudest {
+			d.candidate, d.candidateTicks = loudest, 0
+		}
+		d.candidateTicks++
+		if d.candidateTicks >= speakerSwitchTicks {
+			d.current = loudest
+			d.candidate, d.candidateTicks = nil, 0
+		}
+		d.quietTicks = 0
+	default:
+		// Louder, but not clearly
+		d.candidate, d.candidateTicks = nil, 0
+		d.quietTicks = 0
+	}
+	return d.current, d.current != previous, true
+}
+
+// audioLevelExtensionID returns the ID negotiated for the audio level extension, or 0
+func audioLevelExtensionID(params webrtc.RTPParameters) uint8 {
+	for _, ext := range params.HeaderExtensions {
+		if ext.URI == audioLevelURI {
+			return uint8(ext.ID)
+		}
+	}
+	return 0
+}
+
+// observeAudioLevel passes the audio level of a packet from peer, if it has one, to
+// the room's speaker detector
+func observeAudioLevel(room *Room, peer *Peer, extID uint8, data []byte) {
+	if extID == 0 {
+		return
+	}
+	var header rtp.Header
+	if _, err := header.Unmarshal(data); err != nil {
+		return
+	}
+	payload := header.GetExtension(extID)
+	if payload == nil {
+		return
+	}
+	var level rtp.AudioLevelExtension
+	if err := level.Unmarshal(payload); err != nil {
+		return
+	}
+	room.speakers.observe(peer, level.Level)
+}
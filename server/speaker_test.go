@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// tickWith feeds one interval of packets at the given levels and ticks the detector
+func tickWith(d *speakerDetector, levels map[*Peer]uint8) (*Peer, bool) {
+	for peer, level := range levels {
+		for i := 0; i < 10; i++ {
+			d.observe(peer, level)
+		}
+	}
+	speaker, changed, _ := d.tick()
+	return speaker, changed
+}
+
+func TestSpeakerDetectorSmoothsAndHolds(t *testing.T) {
+	alice, bob := &Peer{ID: "alice"}, &Peer{ID: "bob"}
+	d := newSpeakerDetector(nil)
+	d.running = true // No background loop
+	d.addPeer(alice)
+	d.addPeer(bob)
+
+	// A single loud interval is not enough to register as speech
+	if speaker, _ := tickWith(d, map[*Peer]uint8{alice: 20}); speaker != nil {
+		t.Fatalf("speaker %s after one interval, want nobody yet", speaker.ID)
+	}
+	speaker, changed := tickWith(d, map[*Peer]uint8{alice: 20})
+	if speaker != alice || !changed {
+		t.Fatalf("speaker %v (changed %v), want alice", speaker, changed)
+	}
+
+	// Bob, slightly louder, does not take over; clearly louder, he does after a while
+	if speaker, _ := tickWith(d, map[*Peer]uint8{alice: 20, bob: 18}); speaker != alice {
+		t.Errorf("speaker switched to %s on a small difference", speaker.ID)
+	}
+	for i := 0; i < speakerSwitchTicks+3; i++ {
+		speaker, _ = tickWith(d, map[*Peer]uint8{alice: 40, bob: 10})
+	}
+	if speaker != bob {
+		t.Errorf("speaker %v, want bob once clearly louder", speaker)
+	}
+
+	// Short pauses keep the speaker; a long silence clears it
+	if speaker, _ := tickWith(d, nil); speaker != bob {
+		t.Errorf("speaker %v after a short pause, want bob", speaker)
+	}
+	for i := 0; i < speakerQuietTicks+3; i++ {
+		speaker, changed = tickWith(d, map[*Peer]uint8{alice: 127, bob: 127})
+		if speaker == nil {
+			break
+		}
+	}
+	if speaker != nil || !changed {
+		t.Errorf("speaker %v after a long silence, want nobody", speaker)
+	}
+
+	// A departing speaker is replaced right away
+	tickWith(d, map[*Peer]uint8{alice: 20})
+	tickWith(d, map[*Peer]uint8{alice: 20})
+	d.removePeer(alice)
+	if speaker, changed := tickWith(d, map[*Peer]uint8{bob: 20}); speaker == alice || !changed {
+		t.Errorf("speaker %v (changed %v) after alice left", speaker, changed)
+	}
+}
+
+func TestActiveSpeakerFromAudioLevels(t *testing.T) {
+	_, url := adminTestServer(t)
+	alice, _ := joinWatched(t, url, "speakers", "alice")
+	_, bobEvents := joinWatched(t, url, "speakers", "bob")
+	waitFor(t, 10*time.Second, "alice to be connected", func() bool {
+		return roomManager.GetRoom("speakers").GetPeer("alice").PeerConnection.ConnectionState().String() == "connected"
+	})
+
+	// The payload is silence; only the level in the header extension counts
+	send := func(level uint8, d time.Duration) {
+		for end := time.Now().Add(d); time.Now().Before(end); {
+			if err := alice.WriteOpusWithLevel(silence20ms, level); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	send(20, time.Second)
+	waitFor(t, 5*time.Second, "bob to hear that alice is speaking", bobEvents.has(func() bool {
+		return len(bobEvents.speakers) > 0 && bobEvents.speakers[0] == "alice"
+	}))
+
+	send(127, 2*time.Second)
+	waitFor(t, 5*time.Second, "bob to hear that nobody is speaking", bobEvents.has(func() bool {
+		return len(bobEvents.speakers) == 2 && bobEvents.speakers[1] == ""
+	}))
+}
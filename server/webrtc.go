@@ -1,10 +1,6 @@
 package main
 
-import (
-	"log"
-
-	"github.com/pion/webrtc/v4"
-)
+import "github.com/pion/webrtc/v4"
 
 // createPeerConnection creates a new WebRTC peer connection with Opus audio support
 func createPeerConnection() (*webrtc.PeerConnection, error) {
@@ -27,26 +23,18 @@ func createPeerConnection() (*webrtc.PeerConnection, error) {
 	}, webrtc.RTPCodecTypeAudio); err != nil {
 		return nil, err
 	}
+	// Audio levels drive active speaker detection
+	if err := mediaEngine.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: audioLevelURI}, webrtc.RTPCodecTypeAudio,
+	); err != nil {
+		return nil, err
+	}
 
 	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
 	return api.NewPeerConnection(config)
 }
 
-// triggerNegotiation creates and sends an offer to the peer
+// triggerNegotiation requests an offer to the peer; offers are serialized per peer
 func triggerNegotiation(peer *Peer) {
-	offer, err := peer.PeerConnection.CreateOffer(nil)
-	if err != nil {
-		log.Printf("Failed to create offer for %s: %v", peer.ID, err)
-		return
-	}
-
-	if err := peer.PeerConnection.SetLocalDescription(offer); err != nil {
-		log.Printf("Failed to set local description for %s: %v", peer.ID, err)
-		return
-	}
-
-	peer.SendMessage(SignalMessage{
-		Type: "offer",
-		SDP:  offer.SDP,
-	})
+	peer.negotiator.Negotiate()
 }
@@ -8,6 +8,8 @@ function App() {
   const [clientId, setClientId] = useState(`web-${Math.random().toString(36).slice(2, 8)}`);
   const [isMuted, setIsMuted] = useState(false);
   const [isScreenSharing, setIsScreenSharing] = useState(false);
+  const [isRecorded, setIsRecorded] = useState(false);
+  const [activeSpeaker, setActiveSpeaker] = useState<string | null>(null);
   const [peers, setPeers] = useState<string[]>([]);
   const [logs, setLogs] = useState<string[]>([]);
   const [targetPeerId, setTargetPeerId] = useState('ai-agent');
@@ -25,6 +27,8 @@ function App() {
       clientRef.current?.disconnect();
       clientRef.current = null;
       setPeers([]);
+      setIsRecorded(false);
+      setActiveSpeaker(null);
       return;
     }
 
@@ -64,6 +68,23 @@ function App() {
       onError: (error) => {
         addLog(`Error: ${error}`);
       },
+      onSystemMessage: (text) => {
+        addLog(`System: ${text}`);
+      },
+      onForceMute: (peerId, muted) => {
+        const who = peerId === clientId ? 'You were' : `${peerId} was`;
+        addLog(`${who} ${muted ? 'muted' : 'unmuted'} by an operator`);
+      },
+      onRecording: (info) => {
+        setIsRecorded(info.active);
+        addLog(info.active ? `This call is being recorded (started by ${info.started_by || 'unknown'})` : 'Recording stopped');
+      },
+      onData: (msg) => {
+        addLog(`Data from ${msg.from} on ${msg.topic}${msg.broadcast ? ' (to everyone)' : ''}`);
+      },
+      onActiveSpeaker: (peerId) => {
+        setActiveSpeaker(peerId);
+      },
       onScreenShareStateChange: (sharing) => {
         setIsScreenSharing(sharing);
         addLog(sharing ? 'Screen sharing started' : 'Screen sharing stopped');
@@ -71,7 +92,9 @@ function App() {
     });
 
     try {
-      await client.connect(room);
+      // Join token, when the server requires one: open the UI with ?token=...
+      const token = new URLSearchParams(window.location.search).get('token') || undefined;
+      await client.connect(room, token);
     } catch (error) {
       addLog(`Failed to connect: ${error}`);
     }
@@ -193,6 +216,8 @@ function App() {
             color: connectionState === 'connected' ? '#22c55e' :
                    connectionState === 'connecting' ? '#f59e0b' : '#6b7280'
           }}>{connectionState}</span>
+          {isRecorded && <span style={{ color: '#ef4444', marginLeft: 12 }}>● Recording</span>}
+          {activeSpeaker === clientId && <span style={{ color: '#22c55e', marginLeft: 12 }}>● Speaking</span>}
         </div>
       </div>
 
@@ -211,7 +236,10 @@ function App() {
         ) : (
           <ul style={styles.peerList}>
             {peers.map(peer => (
-              <li key={peer} style={styles.peerItem}>{peer}</li>
+              <li key={peer} style={styles.peerItem}>
+                {peer}
+                {peer === activeSpeaker && <span style={{ color: '#22c55e', marginLeft: 8 }}>● speaking</span>}
+              </li>
             ))}
           </ul>
         )}
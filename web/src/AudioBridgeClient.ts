@@ -1,3 +1,7 @@
+import {
+  RELAY_LABEL, RELAY_CHANNEL_ID, RelayAssembler, relayFrames, base64ToBytes, bytesToBase64,
+} from './relay';
+
 export interface SignalMessage {
   type: string;
   room?: string;
@@ -5,7 +9,49 @@ export interface SignalMessage {
   sdp?: string;
   candidate?: string;
   data?: string;      // For screenshot base64 data
-  target_id?: string; // Target peer for screenshot
+  target_id?: string; // Target peer for screenshot and data; unset broadcasts data
+  token?: string;     // Signed join token
+  code?: string;      // Machine-readable error code
+  error?: string;     // Human-readable error message
+
+  // Remaining ICE candidate fields
+  sdp_mid?: string | null;
+  sdp_mline_index?: number | null;
+  username_fragment?: string | null;
+
+  room_info?: RoomInfo; // Sent in "room_info" after joining
+  text?: string;        // Operator message in "system"
+  muted?: boolean;      // In "force_mute": whether client_id is muted
+  recording?: RecordingInfo; // Sent in "recording" when recording starts or stops
+
+  // Application data in "data": a JSON payload, or binary as base64
+  topic?: string;
+  payload?: unknown;
+  binary?: string;
+}
+
+export interface DataMessage {
+  from: string;
+  topic: string;
+  payload?: unknown; // Set for JSON data
+  binary?: string;   // Base64, set for binary data
+  broadcast: boolean; // Sent to the whole room rather than to this peer only
+}
+
+export interface RoomInfo {
+  id: string;
+  name?: string;
+  max_peers?: number;
+  default_persona?: string;
+  mode: 'sfu' | 'mcu'; // In mcu rooms one mixed track arrives, from peer "mix"
+  created_at: number;  // Unix seconds
+  expires_at?: number; // Unix seconds; unset without a max duration
+}
+
+export interface RecordingInfo {
+  active: boolean;
+  started_at?: number; // Unix seconds
+  started_by?: string; // Peer ID, or "admin"
 }
 
 export type ConnectionState = 'disconnected' | 'connecting' | 'connected' | 'failed';
@@ -16,6 +62,12 @@ export interface AudioBridgeCallbacks {
   onPeerLeft?: (peerId: string) => void;
   onAudioTrack?: (peerId: string, track: MediaStreamTrack) => void;
   onError?: (error: string) => void;
+  onRoomInfo?: (info: RoomInfo) => void;
+  onSystemMessage?: (text: string) => void;
+  onForceMute?: (peerId: string, muted: boolean) => void;
+  onRecording?: (info: RecordingInfo) => void;
+  onActiveSpeaker?: (peerId: string | null) => void; // null when nobody is speaking
+  onData?: (msg: DataMessage) => void;
   onScreenShareStateChange?: (isSharing: boolean) => void;
 }
 
@@ -27,6 +79,14 @@ export class AudioBridgeClient {
   private clientId: string;
   private serverUrl: string;
 
+  // Remote candidates that arrived before their description was applied
+  private pendingCandidates: RTCIceCandidateInit[] = [];
+
+  // Data channel to the server, which relays application data between peers
+  private relay: RTCDataChannel | null = null;
+  private relayAssembler = new RelayAssembler();
+  private relayId = 0;
+
   // Screen sharing
   private screenStream: MediaStream | null = null;
   private screenshotInterval: number | null = null;
@@ -42,7 +102,7 @@ export class AudioBridgeClient {
     this.callbacks = callbacks;
   }
 
-  async connect(room: string): Promise<void> {
+  async connect(room: string, token?: string): Promise<void> {
     this.callbacks.onConnectionStateChange?.('connecting');
 
     try {
@@ -75,14 +135,22 @@ export class AudioBridgeClient {
         this.pc!.addTrack(track, this.localStream!);
       });
 
+      // The server creates its end of the data relay with the same ID
+      this.openRelay(this.pc);
+
       // Handle ICE candidates
       this.pc.onicecandidate = (event) => {
-        if (event.candidate) {
-          this.sendMessage({
-            type: 'candidate',
-            candidate: event.candidate.candidate,
-          });
+        if (!event.candidate) {
+          this.sendMessage({ type: 'end_of_candidates' });
+          return;
         }
+        this.sendMessage({
+          type: 'candidate',
+          candidate: event.candidate.candidate,
+          sdp_mid: event.candidate.sdpMid,
+          sdp_mline_index: event.candidate.sdpMLineIndex,
+          username_fragment: event.candidate.usernameFragment,
+        });
       };
 
       // Handle incoming tracks
@@ -105,6 +173,8 @@ export class AudioBridgeClient {
             break;
           case 'failed':
             this.callbacks.onConnectionStateChange?.('failed');
+            // Ask the server for an offer with fresh ICE credentials
+            this.restartIce();
             break;
           case 'disconnected':
           case 'closed':
@@ -128,6 +198,7 @@ export class AudioBridgeClient {
         type: 'join',
         room: room,
         client_id: this.clientId,
+        token: token,
       });
 
     } catch (error) {
@@ -150,12 +221,42 @@ export class AudioBridgeClient {
       case 'candidate':
         await this.handleCandidate(msg);
         break;
+      case 'end_of_candidates':
+        await this.addCandidate({ candidate: '' });
+        break;
+      case 'room_info':
+        if (msg.room_info) this.callbacks.onRoomInfo?.(msg.room_info);
+        break;
+      case 'system':
+        this.callbacks.onSystemMessage?.(msg.text || '');
+        break;
+      case 'force_mute':
+        this.callbacks.onForceMute?.(msg.client_id || 'unknown', !!msg.muted);
+        break;
+      case 'recording':
+        if (msg.recording) this.callbacks.onRecording?.(msg.recording);
+        break;
+      case 'data':
+        this.callbacks.onData?.({
+          from: msg.client_id || 'unknown',
+          topic: msg.topic || '',
+          payload: msg.payload,
+          binary: msg.binary,
+          broadcast: !msg.target_id,
+        });
+        break;
+      case 'active_speaker':
+        this.callbacks.onActiveSpeaker?.(msg.client_id || null);
+        break;
       case 'peer_joined':
         this.callbacks.onPeerJoined?.(msg.client_id || 'unknown');
         break;
       case 'peer_left':
         this.callbacks.onPeerLeft?.(msg.client_id || 'unknown');
         break;
+      case 'error':
+        this.callbacks.onError?.(`${msg.error || 'Server error'} (${msg.code || 'unknown'})`);
+        break;
     }
   }
 
@@ -167,6 +268,7 @@ export class AudioBridgeClient {
         type: 'offer',
         sdp: msg.sdp,
       });
+      await this.flushCandidates();
 
       const answer = await this.pc.createAnswer();
       await this.pc.setLocalDescription(answer);
@@ -188,23 +290,98 @@ export class AudioBridgeClient {
         type: 'answer',
         sdp: msg.sdp,
       });
+      await this.flushCandidates();
     } catch (error) {
       console.error('Failed to handle answer:', error);
     }
   }
 
   private async handleCandidate(msg: SignalMessage) {
-    if (!this.pc || !msg.candidate) return;
+    if (!msg.candidate) return;
+
+    await this.addCandidate({
+      candidate: msg.candidate,
+      sdpMid: msg.sdp_mid,
+      sdpMLineIndex: msg.sdp_mline_index,
+      usernameFragment: msg.username_fragment,
+    });
+  }
+
+  // Add a remote candidate, buffering it until the remote description is set
+  private async addCandidate(candidate: RTCIceCandidateInit) {
+    if (!this.pc) return;
+    if (!this.pc.remoteDescription) {
+      this.pendingCandidates.push(candidate);
+      return;
+    }
 
     try {
-      await this.pc.addIceCandidate({
-        candidate: msg.candidate,
-      });
+      await this.pc.addIceCandidate(candidate);
     } catch (error) {
       console.error('Failed to add ICE candidate:', error);
     }
   }
 
+  private async flushCandidates() {
+    const pending = this.pendingCandidates;
+    this.pendingCandidates = [];
+    for (const candidate of pending) {
+      await this.addCandidate(candidate);
+    }
+  }
+
+  // Ask the server to renegotiate with fresh ICE credentials, e.g. after a network change
+  restartIce() {
+    this.sendMessage({ type: 'ice_restart' });
+  }
+
+  // Send JSON data to a peer on a topic, or to every other peer without a target
+  // Uses the data channel once open, and signaling until then
+  sendData(topic: string, payload: unknown, targetPeerId?: string) {
+    if (this.relay?.readyState === 'open') {
+      const bytes = new TextEncoder().encode(JSON.stringify(payload));
+      this.sendRelay({ topic, target_id: targetPeerId, json: true }, bytes);
+      return;
+    }
+    this.sendMessage({ type: 'data', topic, payload, target_id: targetPeerId });
+  }
+
+  // Send binary data, base64-encoded, to a peer on a topic, or to every other peer
+  // Uses the data channel once open, and signaling until then
+  sendBinary(topic: string, base64Data: string, targetPeerId?: string) {
+    if (this.relay?.readyState === 'open') {
+      this.sendRelay({ topic, target_id: targetPeerId }, base64ToBytes(base64Data));
+      return;
+    }
+    this.sendMessage({ type: 'data', topic, binary: base64Data, target_id: targetPeerId });
+  }
+
+  private openRelay(pc: RTCPeerConnection) {
+    this.relay = pc.createDataChannel(RELAY_LABEL, { negotiated: true, id: RELAY_CHANNEL_ID });
+    this.relay.binaryType = 'arraybuffer';
+    this.relay.onmessage = (event) => {
+      try {
+        const message = this.relayAssembler.add(new Uint8Array(event.data as ArrayBuffer));
+        if (!message) return;
+        const { header, payload } = message;
+        this.callbacks.onData?.({
+          from: header.from || 'unknown',
+          topic: header.topic || '',
+          payload: header.json ? JSON.parse(new TextDecoder().decode(payload)) : undefined,
+          binary: header.json ? undefined : bytesToBase64(payload),
+          broadcast: !header.target_id,
+        });
+      } catch (error) {
+        console.error('Dropped data frame:', error);
+      }
+    };
+  }
+
+  private sendRelay(header: { topic: string; target_id?: string; json?: boolean }, payload: Uint8Array) {
+    const frames = relayFrames({ ...header, id: ++this.relayId }, payload);
+    frames.forEach(frame => this.relay!.send(frame));
+  }
+
   private sendMessage(msg: SignalMessage) {
     if (this.ws?.readyState === WebSocket.OPEN) {
       this.ws.send(JSON.stringify(msg));
@@ -212,12 +389,17 @@ export class AudioBridgeClient {
   }
 
   disconnect() {
+    // Leaving on purpose; otherwise the server holds our place for a resume
+    this.sendMessage({ type: 'leave' });
     this.localStream?.getTracks().forEach(track => track.stop());
     this.pc?.close();
     this.ws?.close();
     this.localStream = null;
     this.pc = null;
     this.ws = null;
+    this.pendingCandidates = [];
+    this.relay = null;
+    this.relayAssembler = new RelayAssembler();
     this.callbacks.onConnectionStateChange?.('disconnected');
   }
 
@@ -328,12 +510,8 @@ export class AudioBridgeClient {
     const dataUrl = canvas.toDataURL('image/jpeg', 0.7);
     const base64Data = dataUrl.split(',')[1]; // Remove "data:image/jpeg;base64," prefix
 
-    // Send via WebSocket
-    this.sendMessage({
-      type: 'screenshot',
-      data: base64Data,
-      target_id: targetPeerId,
-    });
+    // Send as JPEG bytes on the screenshot data topic
+    this.sendBinary('screenshot', base64Data, targetPeerId);
 
     console.log(`Screenshot sent to ${targetPeerId} (${Math.round(base64Data.length / 1024)}KB)`);
   }
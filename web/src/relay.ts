@@ -0,0 +1,86 @@
+// Framing of application data on the data channel shared with the server (see pkg/relay)
+// Each frame is a 2-byte big-endian header length, a JSON header, and up to CHUNK_SIZE payload bytes
+
+export const RELAY_LABEL = 'relay';
+export const RELAY_CHANNEL_ID = 0;
+export const CHUNK_SIZE = 16 * 1024;
+
+export interface RelayHeader {
+  id: number;
+  topic?: string;
+  target_id?: string; // Recipient; unset for every other peer
+  from?: string;      // Sender, set by the server
+  json?: boolean;     // The payload is JSON rather than binary
+  size: number;       // Payload bytes in the whole message
+  offset: number;     // Position of this frame's payload
+}
+
+export interface RelayMessage {
+  header: RelayHeader;
+  payload: Uint8Array;
+}
+
+const encoder = new TextEncoder();
+const decoder = new TextDecoder();
+
+// Split a message into frames ready to send
+export function relayFrames(header: Omit<RelayHeader, 'size' | 'offset'>, payload: Uint8Array): Uint8Array[] {
+  const frames: Uint8Array[] = [];
+  for (let offset = 0; offset === 0 || offset < payload.length; offset += CHUNK_SIZE) {
+    const head = encoder.encode(JSON.stringify({ ...header, size: payload.length, offset }));
+    const chunk = payload.subarray(offset, offset + CHUNK_SIZE);
+    const frame = new Uint8Array(2 + head.length + chunk.length);
+    new DataView(frame.buffer).setUint16(0, head.length);
+    frame.set(head, 2);
+    frame.set(chunk, 2 + head.length);
+    frames.push(frame);
+  }
+  return frames;
+}
+
+// Reassembles messages from frames; frames of one message arrive in order
+export class RelayAssembler {
+  private pending = new Map<string, { header: RelayHeader; payload: Uint8Array; received: number }>();
+
+  // Returns the message once complete, or null; throws on a malformed frame
+  add(frame: Uint8Array): RelayMessage | null {
+    const length = new DataView(frame.buffer, frame.byteOffset).getUint16(0);
+    const header: RelayHeader = JSON.parse(decoder.decode(frame.subarray(2, 2 + length)));
+    const chunk = frame.subarray(2 + length);
+    const key = `${header.from || ''}/${header.id}`;
+
+    let message = this.pending.get(key);
+    if (!message) {
+      if (header.offset !== 0) return null; // The rest of a message refused earlier
+      message = { header, payload: new Uint8Array(header.size), received: 0 };
+    }
+    if (header.offset !== message.received || header.offset + chunk.length > message.payload.length) {
+      this.pending.delete(key);
+      throw new Error('Malformed relay frame');
+    }
+
+    message.payload.set(chunk, header.offset);
+    message.received += chunk.length;
+    if (message.received < message.payload.length) {
+      this.pending.set(key, message);
+      return null;
+    }
+    this.pending.delete(key);
+    return { header: message.header, payload: message.payload };
+  }
+}
+
+export function base64ToBytes(base64: string): Uint8Array {
+  const binary = atob(base64);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) bytes[i] = binary.charCodeAt(i);
+  return bytes;
+}
+
+export function bytesToBase64(bytes: Uint8Array): string {
+  let binary = '';
+  for (let i = 0; i < bytes.length; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+  return btoa(binary);
+}